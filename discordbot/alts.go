@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"limpan/rotaria-bot/namemc"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// altPlan is what an /alt add request shows staff in place of the
+// application form's answers.
+const altPlan = "Additional account for an existing member"
+
+func altCommand() *discordgo.ApplicationCommand {
+	username := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "username",
+		Description: "Minecraft username",
+		Required:    true,
+		MaxLength:   16,
+	}
+	return &discordgo.ApplicationCommand{
+		Name:        "alt",
+		Description: "Manage the Minecraft accounts linked to you",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Link another Minecraft account",
+				Options:     []*discordgo.ApplicationCommandOption{username},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Unlink one of your Minecraft accounts",
+				Options:     []*discordgo.ApplicationCommandOption{username},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show your linked Minecraft accounts",
+			},
+		},
+	}
+}
+
+// accountSlots renders "2/3", or just the count when there is no limit.
+func accountSlots(n, limit int) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%d/%d", n, limit)
+}
+
+func (a *App) onAltCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown alt command.")
+		return
+	}
+	sub := data.Options[0]
+	var username string
+	for _, o := range sub.Options {
+		if o.Name == "username" {
+			username = strings.TrimSpace(o.StringValue())
+		}
+	}
+
+	user := getSubmittingUser(i)
+	entries, err := a.Store.GetWhitelistEntries(context.Background(), user.ID)
+	if err != nil {
+		log.Printf("Error retrieving whitelist entries for Discord ID %s: %v", user.ID, err)
+		respondEphemeral(s, i, "❌ Something went wrong looking up your accounts. Please try again later.")
+		return
+	}
+	if len(entries) == 0 {
+		respondEphemeral(s, i, "❌ You're not whitelisted yet. Use /whitelist to apply first.")
+		return
+	}
+
+	switch sub.Name {
+	case "add":
+		a.addAlt(s, i, user.ID, username, entries)
+	case "remove":
+		a.removeAlt(s, i, user.ID, username, entries)
+	case "list":
+		respondEphemeralEmbed(s, i, a.altListEmbed(entries))
+	default:
+		respondEphemeral(s, i, "❌ Unknown alt command.")
+	}
+}
+
+func (a *App) addAlt(s *discordgo.Session, i *discordgo.InteractionCreate, discordID, username string, entries []entities.WhiteListEntry) {
+	limit := a.Config.MaxAccountsPerMember
+	if limit > 0 && len(entries) >= limit {
+		respondEphemeral(s, i, fmt.Sprintf("⚠️ You already have %s accounts linked. Remove one with `/alt remove` first.", accountSlots(len(entries), limit)))
+		return
+	}
+	if !minecraftNameRe.MatchString(username) {
+		respondEphemeral(s, i, fmt.Sprintf("❌ `%s` isn't a valid Minecraft username.", username))
+		return
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.MinecraftUsername, username) {
+			respondEphemeral(s, i, fmt.Sprintf("⚠️ `%s` is already linked to you.", e.MinecraftUsername))
+			return
+		}
+	}
+
+	uuid, err := namemc.New().UsernameToUUID(username)
+	if err != nil {
+		log.Printf("Error getting UUID for Minecraft username %s: %v", username, err)
+		respondEphemeral(s, i, fmt.Sprintf("❌ Could not find Minecraft account `%s`. Please ensure the username is spelled correctly", username))
+		return
+	}
+
+	if a.Config.AltsRequireApproval {
+		app := &entities.Application{
+			DiscordID:         discordID,
+			MinecraftUsername: username,
+			MinecraftUUID:     uuid,
+			Plan:              altPlan,
+		}
+		if err := a.Store.CreateApplication(context.Background(), app); err != nil {
+			if errors.Is(err, db.ErrApplicationPending) {
+				respondEphemeral(s, i, "⏳ You already have a whitelist request waiting for review.")
+				return
+			}
+			log.Printf("Error saving alt request for %s: %v", discordID, err)
+			respondEphemeral(s, i, "❌ Something went wrong saving your request. Please try again later.")
+			return
+		}
+		a.sendWLForReview(s, app)
+		respondEphemeral(s, i, fmt.Sprintf("✅ Thanks! Staff will review linking `%s` shortly.", username))
+		return
+	}
+
+	err = a.addWhitelist(discordID, username, uuid)
+	a.audit(entities.AuditEntry{
+		ActorID:    discordID,
+		Action:     entities.AuditWhitelistLink,
+		TargetUser: discordID,
+		Target:     username,
+		Result:     auditResult(err),
+	})
+	if err != nil {
+		var conflict *db.ConflictError
+		if errors.As(err, &conflict) && errors.Is(err, db.ErrUsernameClaimed) {
+			respondEphemeral(s, i, fmt.Sprintf("⚠️ `%s` is already whitelisted by another member. Ask staff if it's yours.", username))
+			return
+		}
+		if errors.Is(err, db.ErrAccountLimit) {
+			respondEphemeral(s, i, "⚠️ You have no free account slots. Remove one with `/alt remove` first.")
+			return
+		}
+		respondEphemeral(s, i, "❌ Something went wrong linking that account. Please try again later.")
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("✅ Linked and whitelisted `%s`.", username))
+}
+
+func (a *App) removeAlt(s *discordgo.Session, i *discordgo.InteractionCreate, discordID, username string, entries []entities.WhiteListEntry) {
+	var entry *entities.WhiteListEntry
+	for n := range entries {
+		if strings.EqualFold(entries[n].MinecraftUsername, username) {
+			entry = &entries[n]
+			break
+		}
+	}
+	if entry == nil {
+		respondEphemeral(s, i, fmt.Sprintf("❌ `%s` isn't linked to you.", username))
+		return
+	}
+	// Leaving the server is how members give up their whitelist entirely.
+	if len(entries) == 1 {
+		respondEphemeral(s, i, "❌ That's your only account. Ask staff if you want it removed.")
+		return
+	}
+	if err := a.removeWhitelistEntry(discordID, entry, "unlinked with /alt remove"); err != nil {
+		respondEphemeral(s, i, "❌ Something went wrong unlinking that account. Please try again later.")
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("✅ Unlinked `%s` and removed it from the whitelist.", entry.MinecraftUsername))
+}
+
+func (a *App) altListEmbed(entries []entities.WhiteListEntry) *discordgo.MessageEmbed {
+	var b strings.Builder
+	for n, e := range entries {
+		fmt.Fprintf(&b, "`%s`", e.MinecraftUsername)
+		if n == 0 {
+			b.WriteString(" (main)")
+		}
+		b.WriteString("\n")
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Your Minecraft Accounts",
+		Description: b.String(),
+		Color:       0x3B82F6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s accounts linked", accountSlots(len(entries), a.Config.MaxAccountsPerMember)),
+		},
+	}
+}
@@ -24,7 +24,17 @@ func initCommands(a *App) []*discordgo.ApplicationCommand {
 			Name:        "report",
 			Description: "Report an issue on the server",
 		},
+		queueCommand(),
+		applicationsCommand(),
+		reportsCommand(),
+		auditCommand(),
+		chatlogCommand(),
+		dbCommand(),
+		whitelistAdminCommand(),
+		altCommand(),
+		statsCommand(),
 	}
+	commands = append(commands, sessionCommands()...)
 
 	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
 		"commands": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -142,6 +152,18 @@ func initCommands(a *App) []*discordgo.ApplicationCommand {
 				},
 			})
 		},
+		"queue":           a.onQueueCommand,
+		"applications":    a.onApplicationsCommand,
+		"reports":         a.onReportsCommand,
+		"audit":           a.onAuditCommand,
+		"chatlog":         a.onChatlogCommand,
+		"db":              a.onDBCommand,
+		"whitelist-admin": a.onWhitelistAdminCommand,
+		"alt":             a.onAltCommand,
+		"playtime":        a.onPlaytimeCommand,
+		"seen":            a.onSeenCommand,
+		"leaderboard":     a.onLeaderboardCommand,
+		"stats":           a.onStatsCommand,
 	}
 	return commands
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const applicationSearchLimit = 15
+
+var applicationColors = map[entities.ApplicationStatus]int{
+	entities.ApplicationPending:   0x3B82F6, // blue
+	entities.ApplicationApproved:  0x22C55E, // green
+	entities.ApplicationRejected:  0xEF4444, // red
+	entities.ApplicationWithdrawn: 0x6B7280, // gray
+}
+
+// applicationEmbed renders an application from its stored record.
+func applicationEmbed(app *entities.Application) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: "Whitelist Request",
+		Color: applicationColors[app.Status],
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Applicant", Value: fmt.Sprintf("<@%s>", app.DiscordID), Inline: true},
+			{Name: "Minecraft Username", Value: fmt.Sprintf("`%s`", app.MinecraftUsername), Inline: true},
+		},
+		Footer:    &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Rotaria Whitelist • Application #%d", app.ID)},
+		Timestamp: app.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	// Alt requests from /alt add have no age.
+	if app.Age != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Age", Value: app.Age, Inline: true})
+	}
+	embed.Fields = append(embed.Fields,
+		&discordgo.MessageEmbedField{Name: "UUID", Value: fmt.Sprintf("`%s`", app.MinecraftUUID)},
+		&discordgo.MessageEmbedField{Name: "Plan on the Server", Value: app.Plan},
+	)
+	if app.Status != entities.ApplicationPending {
+		decision := strings.Title(string(app.Status))
+		if app.ReviewerID != "" {
+			decision += fmt.Sprintf(" by <@%s>", app.ReviewerID)
+		}
+		if !app.DecidedAt.IsZero() {
+			decision += fmt.Sprintf(" <t:%d:R>", app.DecidedAt.Unix())
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Decision", Value: decision})
+	}
+	return embed
+}
+
+// withdrawApplications closes a departed member's open applications and
+// takes the buttons off their review messages.
+func (a *App) withdrawApplications(s *discordgo.Session, discordID string) {
+	apps, err := a.Store.WithdrawApplications(context.Background(), discordID)
+	if err != nil {
+		log.Printf("Error withdrawing applications for %s: %v", discordID, err)
+		return
+	}
+	for i := range apps {
+		app := &apps[i]
+		log.Printf("Withdrew application %d for %s (%s)", app.ID, app.MinecraftUsername, discordID)
+		a.audit(entities.AuditEntry{
+			ActorID:    entities.AuditSystem,
+			Action:     entities.AuditApplicationWithdraw,
+			TargetUser: discordID,
+			Target:     fmt.Sprintf("#%d %s", app.ID, app.MinecraftUsername),
+			Reason:     "Left the Discord server",
+		})
+		if app.MessageID == "" {
+			continue
+		}
+		embed := applicationEmbed(app)
+		embed.Description = "📝 The applicant left the server before a decision was made."
+		_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			Channel:    app.ChannelID,
+			ID:         app.MessageID,
+			Embeds:     &[]*discordgo.MessageEmbed{embed},
+			Components: &[]discordgo.MessageComponent{},
+		})
+		if err != nil {
+			log.Printf("Failed to update review message for application %d: %v", app.ID, err)
+		}
+	}
+}
+
+func applicationsCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	statusChoices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, st := range []entities.ApplicationStatus{
+		entities.ApplicationPending, entities.ApplicationApproved,
+		entities.ApplicationRejected, entities.ApplicationWithdrawn,
+	} {
+		statusChoices = append(statusChoices, &discordgo.ApplicationCommandOptionChoice{Name: string(st), Value: string(st)})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:                     "applications",
+		Description:              "Search whitelist applications",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "List applications, newest first",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "user",
+						Description: "Applicant",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "minecraft",
+						Description: "Part of the Minecraft username",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "status",
+						Description: "Only applications in this state",
+						Choices:     statusChoices,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show one application in full",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "id",
+						Description: "Application number",
+						Required:    true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onApplicationsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown applications command.")
+		return
+	}
+	ctx := context.Background()
+	sub := data.Options[0]
+	switch sub.Name {
+	case "search":
+		f := db.ApplicationFilter{Limit: applicationSearchLimit}
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "user":
+				f.DiscordID = opt.UserValue(nil).ID
+			case "minecraft":
+				f.MinecraftUsername = strings.TrimSpace(opt.StringValue())
+			case "status":
+				f.Status = entities.ApplicationStatus(opt.StringValue())
+			}
+		}
+		apps, err := a.Store.SearchApplications(ctx, f)
+		if err != nil {
+			log.Printf("Error searching applications: %v", err)
+			respondEphemeral(s, i, "❌ Could not search applications.")
+			return
+		}
+		if len(apps) == 0 {
+			respondEphemeral(s, i, "🔎 No applications match.")
+			return
+		}
+		var b strings.Builder
+		for _, app := range apps {
+			b.WriteString(a.applicationLine(&app))
+			b.WriteByte('\n')
+		}
+		embed := &discordgo.MessageEmbed{
+			Title:       "Whitelist applications",
+			Description: truncate(b.String(), 4000),
+			Color:       0x3B82F6,
+			Footer:      &discordgo.MessageEmbedFooter{Text: "Use /applications show <id> for details"},
+		}
+		respondEphemeralEmbed(s, i, embed)
+
+	case "show":
+		id := sub.Options[0].IntValue()
+		app, err := a.Store.GetApplication(ctx, id)
+		if err != nil {
+			log.Printf("Error loading application %d: %v", id, err)
+			respondEphemeral(s, i, "❌ Could not load that application.")
+			return
+		}
+		if app == nil {
+			respondEphemeral(s, i, fmt.Sprintf("❌ No application #%d.", id))
+			return
+		}
+		embed := applicationEmbed(app)
+		if link := a.messageLink(app.ChannelID, app.MessageID); link != "" {
+			embed.Description = fmt.Sprintf("[Review message](%s)", link)
+		}
+		respondEphemeralEmbed(s, i, embed)
+
+	default:
+		respondEphemeral(s, i, "❌ Unknown applications command.")
+	}
+}
+
+func (a *App) applicationLine(app *entities.Application) string {
+	line := fmt.Sprintf("`#%d` `%s` <@%s> • **%s** <t:%d:d>", app.ID, app.MinecraftUsername, app.DiscordID, app.Status, app.CreatedAt.Unix())
+	if app.ReviewerID != "" {
+		line += fmt.Sprintf(" by <@%s>", app.ReviewerID)
+	}
+	if link := a.messageLink(app.ChannelID, app.MessageID); link != "" {
+		line += fmt.Sprintf(" • [message](%s)", link)
+	}
+	return line
+}
+
+func (a *App) messageLink(channelID, messageID string) string {
+	if channelID == "" || messageID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", a.Config.GuildID, channelID, messageID)
+}
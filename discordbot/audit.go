@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	auditSearchLimit = 20
+	auditDateLayout  = "2006-01-02"
+)
+
+// audit records a staff or automated action and mirrors it to the audit
+// channel, if one is configured. Failures are logged; they never block the
+// action itself.
+func (a *App) audit(e entities.AuditEntry) {
+	if e.Result == "" {
+		e.Result = entities.AuditOK
+	}
+	log.Printf("Audit: %s %s %s %s (%s)", e.ActorID, e.Action, e.Target, e.TargetUser, e.Result)
+	if a.Store != nil {
+		if err := a.Store.AppendAudit(context.Background(), &e); err != nil {
+			log.Printf("Error writing audit entry: %v", err)
+		}
+	}
+	if a.Config.AuditChannelID == "" || a.DiscordSession == nil {
+		return
+	}
+	// Sent in the background so a slow channel doesn't hold up the event
+	// loop, e.g. during a burst of automatic kicks.
+	go func() {
+		if _, err := a.DiscordSession.ChannelMessageSendEmbed(a.Config.AuditChannelID, auditEmbed(&e)); err != nil {
+			log.Printf("Error mirroring audit entry to Discord: %v", err)
+		}
+	}()
+}
+
+// auditResult turns an action's error into the result column.
+func auditResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return entities.AuditOK
+}
+
+func auditActor(id string) string {
+	if id == entities.AuditSystem || id == "" {
+		return "🤖 system"
+	}
+	return fmt.Sprintf("<@%s>", id)
+}
+
+func auditEmbed(e *entities.AuditEntry) *discordgo.MessageEmbed {
+	color := 0x3B82F6 // blue
+	if e.Result != entities.AuditOK {
+		color = 0xEF4444 // red
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Actor", Value: auditActor(e.ActorID), Inline: true},
+	}
+	if e.Target != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Target", Value: truncate(e.Target, 1024), Inline: true})
+	}
+	if e.TargetUser != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Member", Value: fmt.Sprintf("<@%s>", e.TargetUser), Inline: true})
+	}
+	if e.Reason != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Reason", Value: truncate(e.Reason, 1024)})
+	}
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Result", Value: truncate(e.Result, 1024)})
+	return &discordgo.MessageEmbed{
+		Title:     e.Action,
+		Color:     color,
+		Fields:    fields,
+		Footer:    &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Rotaria Audit • #%d", e.ID)},
+		Timestamp: e.At.UTC().Format(time.RFC3339),
+	}
+}
+
+func auditCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	actionChoices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, act := range entities.AuditActions {
+		actionChoices = append(actionChoices, &discordgo.ApplicationCommandOptionChoice{Name: act, Value: act})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:                     "audit",
+		Description:              "Search the audit log",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "List audit entries, newest first",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "user",
+						Description: "Staff member who acted, or member acted on",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "action",
+						Description: "Only this kind of action",
+						Choices:     actionChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "since",
+						Description: "From this day, YYYY-MM-DD (UTC)",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "until",
+						Description: "Up to and including this day, YYYY-MM-DD (UTC)",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onAuditCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "search" {
+		respondEphemeral(s, i, "❌ Unknown audit command.")
+		return
+	}
+	f := db.AuditFilter{Limit: auditSearchLimit}
+	for _, opt := range data.Options[0].Options {
+		switch opt.Name {
+		case "user":
+			f.UserID = opt.UserValue(nil).ID
+		case "action":
+			f.Action = opt.StringValue()
+		case "since", "until":
+			day, err := time.Parse(auditDateLayout, strings.TrimSpace(opt.StringValue()))
+			if err != nil {
+				respondEphemeral(s, i, fmt.Sprintf("❌ `%s` is not a date; use YYYY-MM-DD.", opt.StringValue()))
+				return
+			}
+			if opt.Name == "since" {
+				f.Since = day
+			} else {
+				f.Until = day.AddDate(0, 0, 1)
+			}
+		}
+	}
+
+	entries, err := a.Store.SearchAudit(context.Background(), f)
+	if err != nil {
+		log.Printf("Error searching audit log: %v", err)
+		respondEphemeral(s, i, "❌ Could not search the audit log.")
+		return
+	}
+	if len(entries) == 0 {
+		respondEphemeral(s, i, "🔎 No audit entries match.")
+		return
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(auditLine(&e))
+		b.WriteByte('\n')
+	}
+	respondEphemeralEmbed(s, i, &discordgo.MessageEmbed{
+		Title:       "Audit log",
+		Description: truncate(b.String(), 4000),
+		Color:       0x3B82F6,
+		Footer:      &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Showing up to %d entries", auditSearchLimit)},
+	})
+}
+
+func auditLine(e *entities.AuditEntry) string {
+	line := fmt.Sprintf("<t:%d:f> %s **%s**", e.At.Unix(), auditActor(e.ActorID), e.Action)
+	if e.Target != "" {
+		line += " " + truncate(e.Target, 60)
+	}
+	if e.TargetUser != "" {
+		line += fmt.Sprintf(" <@%s>", e.TargetUser)
+	}
+	if e.Reason != "" {
+		line += " — " + truncate(e.Reason, 80)
+	}
+	if e.Result != entities.AuditOK {
+		line += " ⚠️ " + truncate(e.Result, 80)
+	}
+	return line
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const backupListLimit = 10
+
+func (a *App) newBackups(conn *sql.DB) *db.Backups {
+	return &db.Backups{
+		Conn:       conn,
+		Dir:        a.Config.BackupDir,
+		KeepDaily:  a.Config.BackupKeepDaily,
+		KeepWeekly: a.Config.BackupKeepWeekly,
+	}
+}
+
+// startBackups takes a backup every BackupInterval, counting from the newest
+// one on disk so restarts don't reset the clock.
+func (a *App) startBackups(ctx context.Context, conn *sql.DB) {
+	a.backups = a.newBackups(conn)
+	if a.Config.BackupInterval <= 0 {
+		log.Printf("Scheduled backups are disabled")
+		return
+	}
+	go func() {
+		wait := time.Minute
+		if list, err := a.backups.List(); err == nil && len(list) > 0 {
+			wait = max(time.Until(list[0].At.Add(a.Config.BackupInterval)), time.Minute)
+		}
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			a.runBackup(ctx, entities.AuditSystem)
+			t.Reset(a.Config.BackupInterval)
+		}
+	}()
+}
+
+// runBackup takes, verifies and rotates a backup and records it in the
+// audit log.
+func (a *App) runBackup(ctx context.Context, actorID string) (db.BackupInfo, []db.BackupInfo, error) {
+	info, err := a.backups.Create(ctx)
+	entry := entities.AuditEntry{
+		ActorID: actorID,
+		Action:  entities.AuditDBBackup,
+		Result:  auditResult(err),
+	}
+	if err != nil {
+		log.Printf("Database backup failed: %v", err)
+		a.audit(entry)
+		return info, nil, err
+	}
+	entry.Target = filepath.Base(info.Path)
+	a.audit(entry)
+	log.Printf("Database backed up to %s (%s)", info.Path, formatBytes(info.Size))
+
+	removed, err := a.backups.Rotate()
+	if err != nil {
+		log.Printf("Error rotating backups: %v", err)
+	}
+	for _, bk := range removed {
+		log.Printf("Removed old backup %s", bk.Path)
+	}
+	return info, removed, nil
+}
+
+func dbCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	return &discordgo.ApplicationCommand{
+		Name:                     "db",
+		Description:              "Database maintenance",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "backup",
+				Description: "Back up the database now",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "backups",
+				Description: "List the backups on disk",
+			},
+		},
+	}
+}
+
+func (a *App) onDBCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown db command.")
+		return
+	}
+	if a.backups == nil {
+		respondEphemeral(s, i, "❌ Built-in backups are only available when the database is SQLite.")
+		return
+	}
+	switch data.Options[0].Name {
+	case "backup":
+		// VACUUM INTO can outlast the three seconds Discord gives us.
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+		})
+		info, removed, err := a.runBackup(context.Background(), i.Member.User.ID)
+		content := fmt.Sprintf("💾 Backed up to `%s` (%s); integrity check passed.", filepath.Base(info.Path), formatBytes(info.Size))
+		if err != nil {
+			content = fmt.Sprintf("❌ Backup failed: %v", err)
+		} else if len(removed) > 0 {
+			content += fmt.Sprintf(" Rotated out %d old backup(s).", len(removed))
+		}
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+
+	case "backups":
+		list, err := a.backups.List()
+		if err != nil {
+			log.Printf("Error listing backups: %v", err)
+			respondEphemeral(s, i, "❌ Could not list backups.")
+			return
+		}
+		if len(list) == 0 {
+			respondEphemeral(s, i, "📭 No backups yet.")
+			return
+		}
+		var b strings.Builder
+		for n, bk := range list {
+			if n == backupListLimit {
+				fmt.Fprintf(&b, "…and %d more", len(list)-n)
+				break
+			}
+			fmt.Fprintf(&b, "`%s` • %s • <t:%d:R>\n", filepath.Base(bk.Path), formatBytes(bk.Size), bk.At.Unix())
+		}
+		respondEphemeralEmbed(s, i, &discordgo.MessageEmbed{
+			Title:       "Database backups",
+			Description: b.String(),
+			Color:       0x3B82F6,
+			Footer: &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Keeping %d daily and %d weekly in %s",
+				a.backups.KeepDaily, a.backups.KeepWeekly, a.backups.Dir)},
+		})
+
+	default:
+		respondEphemeral(s, i, "❌ Unknown db command.")
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// loadBackupConfig reads the Backup* settings. DatabaseConfigPath must
+// already be set, since backups default to a directory next to it.
+func (a *App) loadBackupConfig() error {
+	c := &a.Config
+	c.BackupDir = os.Getenv("BackupDir")
+	if c.BackupDir == "" {
+		_, _, path := db.ParseDSN(c.DatabaseConfigPath)
+		c.BackupDir = filepath.Join(filepath.Dir(path), "backups")
+	}
+	c.BackupInterval = 24 * time.Hour
+	if v := os.Getenv("BackupInterval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid BackupInterval %q: %w", v, err)
+		}
+		c.BackupInterval = d
+	}
+	c.BackupKeepDaily, c.BackupKeepWeekly = 7, 4
+	for _, setting := range []struct {
+		name string
+		dst  *int
+	}{{"BackupKeepDaily", &c.BackupKeepDaily}, {"BackupKeepWeekly", &c.BackupKeepWeekly}} {
+		v := os.Getenv(setting.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q", setting.name, v)
+		}
+		*setting.dst = n
+	}
+	return nil
+}
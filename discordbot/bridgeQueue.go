@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"limpan/rotaria-bot/internals/imq"
+	"limpan/rotaria-bot/internals/tcpbridge"
+	"log"
+	"strings"
+)
+
+// Commands for the mod (whitelist add, unwhitelist, kick) go through a durable
+// queue so a bot restart or a bridge outage doesn't silently lose them.
+func (a *App) startBridgeQueue(ctx context.Context, conn *sql.DB) error {
+	q, err := imq.NewSQLite(conn, "bridge")
+	if err != nil {
+		return fmt.Errorf("open bridge queue: %w", err)
+	}
+	dlq, err := imq.NewSQLite(conn, "bridge_dlq")
+	if err != nil {
+		return fmt.Errorf("open bridge dead-letter queue: %w", err)
+	}
+	a.bridgeQueue = q
+	a.bridgeDLQ = dlq
+
+	w := &imq.Worker{
+		Q:   q,
+		DLQ: dlq,
+		// A few commands in flight is plenty for the mod; commands for the
+		// same player must not overtake each other (add, then unwhitelist).
+		// Keyed by UUID so a rename doesn't split one player's commands.
+		Concurrency: 4,
+		OrderKey:    "minecraft_uuid",
+		// While the bridge is down, hold commands back without using up
+		// their retries; they go out once it reconnects.
+		Ready: func(ctx context.Context) error {
+			if a.MinecraftConn == nil {
+				return errBridgeNotConnected
+			}
+			st := a.MinecraftConn.Status()
+			if !st.Connected {
+				return tcpbridge.ErrUnavailable
+			}
+			if st.BreakerState == tcpbridge.BreakerOpen {
+				return tcpbridge.ErrBreakerOpen
+			}
+			return nil
+		},
+		Handle: func(ctx context.Context, m imq.Message) error {
+			_, err := a.MinecraftConn.Send(ctx, m.Body)
+			if err != nil {
+				log.Printf("Bridge command %q failed (attempt %d): %v", m.Body, m.Attempts, err)
+			}
+			return classifyBridgeError(err)
+		},
+	}
+	w.Start(ctx)
+	a.bridgeWorker = w
+	log.Printf("Bridge queue started with %d pending commands", q.Len())
+	return nil
+}
+
+// openQueueDatabase returns the connection the durable queues live on. They
+// are SQLite-only, so with a Postgres store they get a local file of their
+// own (QueueDatabasePath, default queue.db). That file is migrated like the
+// store, so it gets the queue tables; the store's own tables stay empty.
+func (a *App) openQueueDatabase(ctx context.Context, store *db.SQLStore) (*sql.DB, error) {
+	if store.Dialect == db.SQLite {
+		return store.Conn, nil
+	}
+	path := a.Config.QueueDatabasePath
+	if path == "" {
+		path = "queue.db"
+	}
+	if d, _, _ := db.ParseDSN(path); d != db.SQLite {
+		return nil, fmt.Errorf("QueueDatabasePath must be a SQLite file, got %s", d)
+	}
+	qs, err := db.OpenStore(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("open queue database: %w", err)
+	}
+	a.queueStore = qs
+	log.Printf("Durable queues are stored in %s", path)
+	return qs.Conn, nil
+}
+
+var errBridgeNotConnected = errors.New("minecraft connection not established")
+
+// bridgeRejections are ERR messages that mean the command itself is bad, so
+// sending it again cannot help. The mod reports a failed command as the
+// exception that ended it ("<class>: <message>").
+var bridgeRejections = []string{
+	"com.mojang.brigadier.exceptions.CommandSyntaxException", // unknown command or bad arguments
+	"java.lang.IllegalArgumentException",                     // e.g. an invalid player name
+	"java.lang.IndexOutOfBoundsException",                    // commandexec that printed nothing
+}
+
+// classifyBridgeError tells the worker which failures are worth retrying:
+// outages are transient, a command the server rejected outright is
+// permanent, and anything else (timeouts, other errors from the mod) gets the
+// normal retry budget.
+func classifyBridgeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var rerr *tcpbridge.RemoteError
+	switch {
+	case errors.Is(err, tcpbridge.ErrUnavailable),
+		errors.Is(err, tcpbridge.ErrBreakerOpen),
+		errors.Is(err, tcpbridge.ErrClosed):
+		return imq.Transient(err)
+	case errors.As(err, &rerr):
+		if rerr.NotReady() {
+			return imq.Transient(err)
+		}
+		for _, prefix := range bridgeRejections {
+			if strings.HasPrefix(rerr.Msg, prefix) {
+				return imq.Permanent(err)
+			}
+		}
+	}
+	return err
+}
+
+// Moderation (kicks, bans) jumps ahead of whitelist changes and announcements.
+const (
+	bridgePriorityNormal     = 0
+	bridgePriorityModeration = 10
+)
+
+// playerHeaders identifies the player a bridge command is about.
+func playerHeaders(entry entities.WhiteListEntry) map[string]string {
+	h := map[string]string{"minecraft_username": entry.MinecraftUsername}
+	if entry.MinecraftUUID != "" {
+		h["minecraft_uuid"] = entry.MinecraftUUID
+	}
+	if entry.DiscordID != "" {
+		h["discord_id"] = entry.DiscordID
+	}
+	return h
+}
+
+// queueBridgeCommand hands a mod command to the bridge queue.
+func (a *App) queueBridgeCommand(cmd string, priority int, headers map[string]string) error {
+	if a.bridgeQueue == nil {
+		return fmt.Errorf("bridge queue not started")
+	}
+	return a.bridgeQueue.Publish(context.Background(), imq.Message{Body: []byte(cmd), Priority: priority, Headers: headers})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/internals/imq"
+	"limpan/rotaria-bot/internals/tcpbridge"
+	"testing"
+)
+
+func TestClassifyBridgeError(t *testing.T) {
+	remote := func(msg string) error { return &tcpbridge.RemoteError{Msg: msg} }
+	type test struct {
+		name string
+		err  error
+		want string // "permanent", "transient", "retry" or "nil"
+	}
+	tests := []test{
+		{"nil", nil, "nil"},
+		{"unavailable", tcpbridge.ErrUnavailable, "transient"},
+		{"breaker open", fmt.Errorf("send: %w", tcpbridge.ErrBreakerOpen), "transient"},
+		{"closed", tcpbridge.ErrClosed, "transient"},
+		{"server not ready", remote(tcpbridge.MsgServerNotReady), "transient"},
+		{"timeout", context.DeadlineExceeded, "retry"},
+		{"other error from the mod", remote("java.lang.NullPointerException: level is null"), "retry"},
+		{"rejection text not at the start", remote("wrapped: java.lang.IllegalArgumentException: x"), "retry"},
+		{"plain error", errors.New("boom"), "retry"},
+	}
+	for _, prefix := range bridgeRejections {
+		tests = append(tests,
+			test{prefix, remote(prefix + ": rejected"), "permanent"},
+			test{prefix + " wrapped", fmt.Errorf("kick Steve: %w", remote(prefix)), "permanent"},
+		)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyBridgeError(tt.err)
+			var kind string
+			switch {
+			case got == nil:
+				kind = "nil"
+			case imq.IsPermanent(got):
+				kind = "permanent"
+			case imq.IsTransient(got):
+				kind = "transient"
+			default:
+				kind = "retry"
+			}
+			if kind != tt.want {
+				t.Fatalf("classifyBridgeError(%v) is %s, want %s", tt.err, kind, tt.want)
+			}
+			if tt.err != nil && !errors.Is(got, tt.err) {
+				t.Fatalf("classified error %v no longer wraps %v", got, tt.err)
+			}
+		})
+	}
+}
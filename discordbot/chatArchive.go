@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Relayed chat is archived in both directions, including lines the
+// blacklist stopped, so moderators can check what was said when a report
+// comes in. Old lines are pruned after Config.ChatRetentionDays.
+const (
+	chatArchivePruneInterval = 24 * time.Hour
+	chatlogPageSize          = 10
+	chatlogQueryMax          = 50 // keeps the pagination custom ID under 100 chars
+)
+
+// archiveChat stores one line; errors are logged and otherwise ignored.
+func (a *App) archiveChat(m entities.ChatMessage) {
+	if err := a.Store.ArchiveChat(context.Background(), &m); err != nil {
+		log.Printf("Error archiving chat from %s: %v", m.Author, err)
+	}
+}
+
+func (a *App) startChatArchivePruner(ctx context.Context) {
+	if a.Config.ChatRetentionDays <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTimer(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			before := time.Now().AddDate(0, 0, -a.Config.ChatRetentionDays)
+			n, err := a.Store.PruneChat(ctx, before)
+			if err != nil {
+				log.Printf("Error pruning chat archive: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d archived chat messages older than %d days", n, a.Config.ChatRetentionDays)
+			}
+			t.Reset(chatArchivePruneInterval)
+		}
+	}()
+}
+
+func chatlogCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	return &discordgo.ApplicationCommand{
+		Name:                     "chatlog",
+		Description:              "Search archived chat",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "Find chat lines containing all the given words",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "query",
+						Description: "Words to look for",
+						Required:    true,
+						MaxLength:   chatlogQueryMax,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "player",
+						Description: "Minecraft name, Discord name or ID",
+						MaxLength:   20,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "since",
+						Description: "YYYY-MM-DD, or how far back, e.g. 24h or 7d",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onChatlogCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "search" {
+		respondEphemeral(s, i, "❌ Unknown chatlog command.")
+		return
+	}
+	f := db.ChatFilter{Limit: chatlogPageSize}
+	for _, opt := range data.Options[0].Options {
+		switch opt.Name {
+		case "query":
+			f.Query = strings.TrimSpace(opt.StringValue())
+		case "player":
+			f.Author = strings.TrimSpace(opt.StringValue())
+		case "since":
+			since, err := parseSince(opt.StringValue(), time.Now())
+			if err != nil {
+				respondEphemeral(s, i, fmt.Sprintf("❌ `%s` is not a date or duration; use YYYY-MM-DD, 24h or 7d.", opt.StringValue()))
+				return
+			}
+			f.Since = since
+		}
+	}
+	embed, components, err := a.chatlogPage(f)
+	if err != nil {
+		log.Printf("Error searching chat archive: %v", err)
+		respondEphemeral(s, i, "❌ Could not search the chat archive.")
+		return
+	}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: components,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// onChatlogPage handles the Prev/Next buttons. The whole search lives in
+// the custom ID: chatlog|<offset>|<since unix>|<player>|<query>.
+func (a *App) onChatlogPage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+	cid := i.MessageComponentData().CustomID
+	if !strings.HasPrefix(cid, "chatlog|") {
+		return
+	}
+	parts := strings.SplitN(cid, "|", 5)
+	if len(parts) != 5 {
+		return
+	}
+	offset, _ := strconv.Atoi(parts[1])
+	sinceUnix, _ := strconv.ParseInt(parts[2], 10, 64)
+	f := db.ChatFilter{Limit: chatlogPageSize, Offset: offset, Author: parts[3], Query: parts[4]}
+	if sinceUnix > 0 {
+		f.Since = time.Unix(sinceUnix, 0)
+	}
+	embed, components, err := a.chatlogPage(f)
+	if err != nil {
+		log.Printf("Error searching chat archive: %v", err)
+		respondEphemeral(s, i, "❌ Could not search the chat archive.")
+		return
+	}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: components,
+		},
+	})
+}
+
+// chatlogPage renders one page of results with buttons for its neighbours.
+func (a *App) chatlogPage(f db.ChatFilter) (*discordgo.MessageEmbed, []discordgo.MessageComponent, error) {
+	page := f
+	page.Limit = f.Limit + 1 // one extra tells us whether there is a next page
+	msgs, err := a.Store.SearchChat(context.Background(), page)
+	if err != nil {
+		return nil, nil, err
+	}
+	more := len(msgs) > f.Limit
+	if more {
+		msgs = msgs[:f.Limit]
+	}
+
+	title := fmt.Sprintf("Chat matching “%s”", f.Query)
+	if f.Author != "" {
+		title += " from " + f.Author
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:  truncate(title, 256),
+		Color:  0x3B82F6,
+		Footer: &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Page %d", f.Offset/f.Limit+1)},
+	}
+	if len(msgs) == 0 {
+		embed.Description = "🔎 No archived chat matches."
+		return embed, []discordgo.MessageComponent{}, nil
+	}
+	var b strings.Builder
+	for _, m := range msgs {
+		b.WriteString(chatlogLine(&m))
+		b.WriteByte('\n')
+	}
+	embed.Description = truncate(b.String(), 4000)
+
+	var since int64
+	if !f.Since.IsZero() {
+		since = f.Since.Unix()
+	}
+	pageID := func(offset int) string {
+		return fmt.Sprintf("chatlog|%d|%d|%s|%s", offset, since, f.Author, f.Query)
+	}
+	row := discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    "Newer",
+			Style:    discordgo.SecondaryButton,
+			CustomID: pageID(max(f.Offset-f.Limit, 0)),
+			Disabled: f.Offset == 0,
+		},
+		discordgo.Button{
+			Label:    "Older",
+			Style:    discordgo.SecondaryButton,
+			CustomID: pageID(f.Offset + f.Limit),
+			Disabled: !more,
+		},
+	}}
+	return embed, []discordgo.MessageComponent{row}, nil
+}
+
+func chatlogLine(m *entities.ChatMessage) string {
+	icon := "⛏️"
+	if m.Source == entities.ChatFromDiscord {
+		icon = "💬"
+	}
+	line := fmt.Sprintf("<t:%d:f> %s **%s**: %s", m.At.Unix(), icon, m.Author, truncate(m.Content, 200))
+	if m.Blocked {
+		line += " 🚫"
+	}
+	return line
+}
+
+// parseSince accepts a date (YYYY-MM-DD, UTC) or a lookback like 24h or 7d.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Parse(auditDateLayout, v)
+}
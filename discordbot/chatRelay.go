@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"limpan/rotaria-bot/internals/imq"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rotaria-smp/discordwebhook"
+)
+
+// Minecraft -> Discord chat goes through a durable queue and an imq.Worker
+// that posts one message at a time, so lines stay in order and survive
+// webhook errors and restarts. Discord's rate limit is followed from the
+// response headers and 429 retry_after; when a backlog builds up, queued
+// lines are merged into fewer posts instead of being dropped. Posts Discord
+// refuses, or keeps failing on, go to the chat_dlq queue.
+
+const (
+	chatEventUsername = "Rotaria"
+	chatEventAvatar   = "https://cdn.discordapp.com/icons/1373389493218050150/24f94fe60c73b4af4956f10dbecb5919.webp"
+
+	chatMaxContent   = 2000             // Discord's content limit
+	chatCoalesceLag  = 10 * time.Second // lines queued longer than this merge across senders
+	chatReadAhead    = 20               // lines held to merge into the next post
+	chatMaxBackoff   = time.Minute
+	chatLease        = time.Minute // covers a post and its rate-limit waits
+	chatOrderHeader  = "channel"
+	chatOrderChannel = "chat"
+	// Discord 5xx answers before a post is dead-lettered. Rate limits and
+	// network errors are waited out without counting.
+	chatMaxAttempts = 5
+)
+
+type chatRelay struct {
+	url    string
+	q      *imq.SQLiteQueue
+	dlq    *imq.SQLiteQueue
+	w      *imq.Worker
+	client *http.Client
+
+	mu       sync.Mutex
+	resumeAt time.Time // the rate-limit bucket is empty until then
+}
+
+// chatLine is one queued webhook message.
+type chatLine struct {
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+	Content  string `json:"content"`
+}
+
+type webhookRateLimited struct {
+	RetryAfter time.Duration
+	Global     bool
+}
+
+func (e *webhookRateLimited) Error() string {
+	return fmt.Sprintf("rate limited, retry after %v (global=%v)", e.RetryAfter, e.Global)
+}
+
+type webhookStatusError struct {
+	Code int
+	Body string
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned %d: %s", e.Code, e.Body)
+}
+
+func (a *App) startChatRelay(ctx context.Context, conn *sql.DB) error {
+	r, err := newChatRelay(conn, a.Config.MessageWebhookUrl)
+	if err != nil {
+		return err
+	}
+	r.w.Start(ctx)
+	a.chatRelay = r
+	log.Printf("Chat relay started with %d pending messages (%d dead-lettered)", r.q.Len(), r.dlq.Len())
+	return nil
+}
+
+// newChatRelay opens the chat queues on conn; the worker is not started.
+func newChatRelay(conn *sql.DB, url string) (*chatRelay, error) {
+	q, err := imq.NewSQLite(conn, "chat")
+	if err != nil {
+		return nil, fmt.Errorf("open chat queue: %w", err)
+	}
+	q.VisibilityTimeout = chatLease
+	dlq, err := imq.NewSQLite(conn, "chat_dlq")
+	if err != nil {
+		return nil, fmt.Errorf("open chat dead-letter queue: %w", err)
+	}
+	r := &chatRelay{
+		url:    url,
+		q:      q,
+		dlq:    dlq,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+	r.w = &imq.Worker{
+		Q:   q,
+		DLQ: dlq,
+		// Every line has the same key, so posts go out one at a time and
+		// in order; Concurrency only sets how many lines are read ahead.
+		OrderKey:    chatOrderHeader,
+		Concurrency: chatReadAhead,
+		MaxRetries:  chatMaxAttempts - 1,
+		Backoff: func(attempt int) time.Duration {
+			return min(time.Second<<min(attempt-1, 6), chatMaxBackoff)
+		},
+		Handle: r.handle,
+		Merge:  r.merge,
+	}
+	return r, nil
+}
+
+// relayChat queues a message for the chat webhook.
+func (a *App) relayChat(line chatLine) {
+	if a.chatRelay == nil {
+		log.Printf("Chat relay not started; dropping message from %s", line.Username)
+		return
+	}
+	if err := a.chatRelay.publish(line); err != nil {
+		log.Printf("Failed to queue chat message: %v", err)
+	}
+}
+
+func (r *chatRelay) publish(line chatLine) error {
+	body, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	return r.q.Publish(context.Background(), imq.Message{
+		Body:    body,
+		Headers: map[string]string{chatOrderHeader: chatOrderChannel},
+	})
+}
+
+// Close stops the worker, which hands back anything it was holding.
+func (r *chatRelay) Close(ctx context.Context) error {
+	defer r.dlq.Close()
+	defer r.q.Close()
+	return r.w.Stop(ctx)
+}
+
+// handle posts the lines in m. A 4xx other than 429 is permanent, since
+// Discord will never accept that post; 5xx answers count toward
+// chatMaxAttempts; rate limits are waited out here while the lease allows,
+// and they and network errors are retried without counting.
+func (r *chatRelay) handle(ctx context.Context, m imq.Message) error {
+	lines, err := chatLines(m.Body)
+	if err != nil {
+		return imq.Permanent(fmt.Errorf("unreadable chat message: %w", err))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !sleepCtx(ctx, time.Until(r.resumeAt)) {
+		return imq.Transient(ctx.Err())
+	}
+	msg := render(lines).message()
+	for {
+		pause, err := r.post(ctx, msg)
+		if err == nil {
+			r.resumeAt = time.Now().Add(pause)
+			return nil
+		}
+		var limited *webhookRateLimited
+		var status *webhookStatusError
+		switch {
+		case errors.As(err, &limited):
+			log.Printf("Chat webhook %v", err)
+			if dl, ok := ctx.Deadline(); ok && time.Until(dl) < limited.RetryAfter {
+				return imq.Transient(err)
+			}
+			if !sleepCtx(ctx, limited.RetryAfter) {
+				return imq.Transient(err)
+			}
+		case errors.As(err, &status) && status.Code < 500:
+			log.Printf("Discord refused %d chat line(s), dead-lettering: %v", len(lines), err)
+			return imq.Permanent(err)
+		case errors.As(err, &status):
+			log.Printf("Error sending chat to Discord (attempt %d of %d): %v", m.Attempts, chatMaxAttempts, err)
+			return err
+		default:
+			log.Printf("Error sending chat to Discord, will retry: %v", err)
+			return imq.Transient(err)
+		}
+	}
+}
+
+// merge folds next into batch if it fits in one post: always for the same
+// sender, and for anyone once lines are falling behind.
+func (r *chatRelay) merge(batch, next imq.Message) (imq.Message, bool) {
+	lines, err := chatLines(batch.Body)
+	if err != nil {
+		return batch, false
+	}
+	l, err := chatLines(next.Body)
+	if err != nil || len(l) != 1 {
+		return batch, false
+	}
+	merged := append(lines, l[0])
+	if !mergeable(lines[0], l[0], time.Since(next.EnqueuedAt)) ||
+		utf8.RuneCountInString(render(merged).content()) > chatMaxContent {
+		return batch, false
+	}
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return batch, false
+	}
+	batch.Body = body
+	return batch, true
+}
+
+// chatLines decodes a queued line, or the list of lines merge made of several.
+func chatLines(body []byte) ([]chatLine, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var lines []chatLine
+		if err := json.Unmarshal(body, &lines); err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			return nil, errors.New("no lines")
+		}
+		return lines, nil
+	}
+	var l chatLine
+	if err := json.Unmarshal(body, &l); err != nil {
+		return nil, err
+	}
+	return []chatLine{l}, nil
+}
+
+// mergeable reports whether l can share a post with a batch that starts with
+// first: always for the same sender, and for anyone once l has waited longer
+// than chatCoalesceLag.
+func mergeable(first, l chatLine, waited time.Duration) bool {
+	if first.Username == l.Username && first.Avatar == l.Avatar {
+		return true
+	}
+	return waited >= chatCoalesceLag
+}
+
+type chatPost struct {
+	username, avatar string
+	lines            []string
+}
+
+func (p chatPost) content() string { return strings.Join(p.lines, "\n") }
+
+func (p chatPost) message() discordwebhook.Message {
+	content := truncate(p.content(), chatMaxContent)
+	flag := discordwebhook.MessageFlagSuppressNotifications
+	return discordwebhook.Message{
+		Content:   &content,
+		Username:  &p.username,
+		AvatarURL: &p.avatar,
+		Flags:     &flag,
+	}
+}
+
+// render turns a batch into one post. Mixed senders are posted as the server
+// with each line attributed.
+func render(lines []chatLine) chatPost {
+	p := chatPost{username: lines[0].Username, avatar: lines[0].Avatar}
+	mixed := false
+	for _, l := range lines[1:] {
+		if l.Username != p.username || l.Avatar != p.avatar {
+			mixed = true
+			break
+		}
+	}
+	if mixed {
+		p.username, p.avatar = chatEventUsername, chatEventAvatar
+	}
+	for _, l := range lines {
+		if mixed && l.Username != "" && l.Username != chatEventUsername {
+			p.lines = append(p.lines, fmt.Sprintf("**%s**: %s", l.Username, l.Content))
+			continue
+		}
+		p.lines = append(p.lines, l.Content)
+	}
+	return p
+}
+
+// post executes the webhook once. pause is how long to hold off before the
+// next post when the rate-limit bucket is empty.
+func (r *chatRelay) post(ctx context.Context, msg discordwebhook.Message) (pause time.Duration, err error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var rl struct {
+			RetryAfter float64 `json:"retry_after"`
+			Global     bool    `json:"global"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&rl)
+		after := seconds(rl.RetryAfter)
+		if after <= 0 {
+			after = parseSeconds(resp.Header.Get("Retry-After"))
+		}
+		if after <= 0 {
+			after = time.Second
+		}
+		return 0, &webhookRateLimited{RetryAfter: after, Global: rl.Global}
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, &webhookStatusError{Code: resp.StatusCode, Body: string(b)}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		pause = parseSeconds(resp.Header.Get("X-RateLimit-Reset-After"))
+	}
+	return pause, nil
+}
+
+func seconds(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+
+func parseSeconds(v string) time.Duration {
+	s, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0
+	}
+	return seconds(s)
+}
+
+// sleepCtx waits for d; it returns false if ctx ended first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
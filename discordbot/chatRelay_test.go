@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhook answers posts with the given status codes in turn, repeating
+// the last one, and records the content of every post.
+type fakeWebhook struct {
+	mu      sync.Mutex
+	codes   []int
+	posts   []string
+	release chan struct{} // if set, the first post waits for it
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg struct {
+		Content string `json:"content"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&msg)
+	f.mu.Lock()
+	n := len(f.posts)
+	f.posts = append(f.posts, msg.Content)
+	code := f.codes[min(n, len(f.codes)-1)]
+	release := f.release
+	f.mu.Unlock()
+	if n == 0 && release != nil {
+		<-release
+	}
+	w.WriteHeader(code)
+	if code == http.StatusTooManyRequests {
+		_, _ = w.Write([]byte(`{"retry_after": 0.05, "global": false}`))
+	}
+}
+
+func (f *fakeWebhook) Posts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.posts...)
+}
+
+func startTestRelay(t *testing.T, hook *fakeWebhook) *chatRelay {
+	t.Helper()
+	srv := httptest.NewServer(hook)
+	t.Cleanup(srv.Close)
+	r, err := newChatRelay(openTestStore(t).Conn, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.w.Backoff = func(int) time.Duration { return time.Millisecond }
+	r.w.TransientBackoff = func(int) time.Duration { return time.Millisecond }
+	r.w.Start(context.Background())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.Close(ctx); err != nil {
+			t.Errorf("close relay: %v", err)
+		}
+	})
+	return r
+}
+
+func TestChatRelayOutcomes(t *testing.T) {
+	tests := []struct {
+		name      string
+		codes     []int
+		wantPosts int
+		wantDead  string // LastError of the dead letter, "" for none
+	}{
+		{"delivered", []int{http.StatusNoContent}, 1, ""},
+		{"rate limited then delivered", []int{http.StatusTooManyRequests, http.StatusNoContent}, 2, ""},
+		{"server errors until dead-lettered", []int{http.StatusBadGateway}, chatMaxAttempts, "webhook returned 502"},
+		{"refused straight away", []int{http.StatusBadRequest}, 1, "webhook returned 400"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &fakeWebhook{codes: tt.codes}
+			r := startTestRelay(t, hook)
+			if err := r.publish(chatLine{Username: "<Steve>", Content: "hello"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantDead != "" {
+				waitFor(t, "a dead letter", func() bool { return r.dlq.Len() == 1 })
+			} else {
+				waitFor(t, "the queue to drain", func() bool { return len(hook.Posts()) == tt.wantPosts && r.q.Len() == 0 })
+			}
+			// Give a wrong extra attempt the chance to show up.
+			time.Sleep(50 * time.Millisecond)
+
+			posts := hook.Posts()
+			if len(posts) != tt.wantPosts {
+				t.Fatalf("%d posts, want %d", len(posts), tt.wantPosts)
+			}
+			for _, p := range posts {
+				if p != "hello" {
+					t.Fatalf("posted %q, want hello", p)
+				}
+			}
+			dead, err := r.dlq.Peek(context.Background(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantDead == "" && len(dead) != 0:
+				t.Fatalf("dead-lettered %+v", dead)
+			case tt.wantDead != "" && (len(dead) != 1 || !strings.Contains(dead[0].LastError, tt.wantDead)):
+				t.Fatalf("dead letters = %+v, want one with %q", dead, tt.wantDead)
+			}
+			if n := r.q.Len(); n != 0 {
+				t.Fatalf("%d messages left in the chat queue", n)
+			}
+		})
+	}
+}
+
+func TestChatRelayMergesBacklogInOrder(t *testing.T) {
+	hook := &fakeWebhook{codes: []int{http.StatusNoContent}, release: make(chan struct{})}
+	r := startTestRelay(t, hook)
+	publish := func(user, content string) {
+		if err := r.publish(chatLine{Username: user, Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("<Steve>", "first")
+	waitFor(t, "the first post", func() bool { return len(hook.Posts()) == 1 })
+	publish("<Steve>", "second")
+	publish("<Steve>", "third")
+	publish("<Alex>", "fourth")
+	time.Sleep(50 * time.Millisecond) // let them be read ahead
+	close(hook.release)
+
+	waitFor(t, "the backlog to be posted", func() bool { return len(hook.Posts()) == 3 })
+	want := []string{"first", "second\nthird", "fourth"}
+	for i, p := range hook.Posts() {
+		if p != want[i] {
+			t.Fatalf("post %d = %q, want %q", i, p, want[i])
+		}
+	}
+}
+
+func TestChatLinesReadsMergedBodies(t *testing.T) {
+	lines := []chatLine{{Username: "a", Content: "1"}, {Username: "b", Content: "2"}}
+	body, _ := json.Marshal(lines)
+	got, err := chatLines(body)
+	if err != nil || len(got) != 2 || got[1] != lines[1] {
+		t.Fatalf("chatLines(list) = %v, %v", got, err)
+	}
+	body, _ = json.Marshal(lines[0])
+	got, err = chatLines(body)
+	if err != nil || len(got) != 1 || got[0] != lines[0] {
+		t.Fatalf("chatLines(line) = %v, %v", got, err)
+	}
+	if _, err := chatLines([]byte("[]")); err == nil {
+		t.Fatal("chatLines accepted an empty list")
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/imq"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// When a member leaves Discord their accounts come off the server straight
+// away, but the rows are only marked departed. A job scheduled on a durable
+// queue deletes them once DepartureGracePeriod has passed; rejoining before
+// then restores everything.
+
+const (
+	departureDigestInterval = 24 * time.Hour
+	departureDigestLimit    = 25
+)
+
+func (a *App) startDepartures(ctx context.Context, conn *sql.DB) error {
+	if a.Config.DepartureGracePeriod <= 0 {
+		return nil
+	}
+	q, err := imq.NewSQLite(conn, "departures")
+	if err != nil {
+		return fmt.Errorf("open departures queue: %w", err)
+	}
+	dlq, err := imq.NewSQLite(conn, "departures_dlq")
+	if err != nil {
+		return fmt.Errorf("open departures dead-letter queue: %w", err)
+	}
+	a.departureQueue = q
+	a.departureDLQ = dlq
+
+	// A purge that keeps failing is dead-lettered; the digest retries it
+	// the next day anyway.
+	w := &imq.Worker{
+		Q:   q,
+		DLQ: dlq,
+		Handle: func(ctx context.Context, m imq.Message) error {
+			return a.purgeDeparted(ctx, m.Headers["discord_id"])
+		},
+	}
+	w.Start(ctx)
+	a.departureWorker = w
+
+	// Reschedule anyone the queue doesn't know about, e.g. departures from
+	// before a restore; jobs that are already queued are left alone.
+	departed, err := a.Store.ListDepartedWhitelistEntries(ctx)
+	if err != nil {
+		return fmt.Errorf("list departed members: %w", err)
+	}
+	for _, m := range departedMembers(departed) {
+		a.schedulePurge(ctx, m.discordID, m.at)
+	}
+	log.Printf("Departures queue started with %d pending purges", q.Len())
+
+	go func() {
+		t := time.NewTimer(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			a.sendDepartureDigest(ctx)
+			t.Reset(departureDigestInterval)
+		}
+	}()
+	return nil
+}
+
+// schedulePurge queues the purge of a member who left at at. The job ID
+// includes at, so a member who rejoins and leaves again gets a fresh job and
+// the old one finds nothing to do.
+func (a *App) schedulePurge(ctx context.Context, discordID string, at time.Time) {
+	err := a.departureQueue.PublishAt(ctx, imq.Message{
+		ID:      fmt.Sprintf("purge-%s-%d", discordID, at.Unix()),
+		Headers: map[string]string{"discord_id": discordID},
+	}, at.Add(a.Config.DepartureGracePeriod))
+	if err != nil {
+		log.Printf("Error scheduling whitelist purge for %s: %v", discordID, err)
+	}
+}
+
+// departMember takes a member who left off the server and starts their
+// grace period.
+func (a *App) departMember(discordID string) {
+	ctx := context.Background()
+	now := time.Now()
+	entries, err := a.Store.DepartWhitelistEntries(ctx, discordID, now)
+	if err != nil {
+		log.Printf("Error marking whitelist entries departed for Discord ID %s: %v", discordID, err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	for _, entry := range entries {
+		audit := entities.AuditEntry{
+			ActorID:    entities.AuditSystem,
+			Action:     entities.AuditWhitelistDepart,
+			TargetUser: discordID,
+			Target:     entry.MinecraftUsername,
+			Reason:     fmt.Sprintf("Left the Discord server; removed for good after %s", formatGracePeriod(a.Config.DepartureGracePeriod)),
+		}
+		msg := fmt.Sprintf("unwhitelist %s\n", entry.MinecraftUsername)
+		if err := a.queueBridgeCommand(msg, bridgePriorityNormal, playerHeaders(entry)); err != nil {
+			log.Printf("Error queueing unwhitelist for %s: %v", entry.MinecraftUsername, err)
+			audit.Result = "unwhitelist not queued: " + err.Error()
+		}
+		a.audit(audit)
+	}
+	a.schedulePurge(ctx, discordID, entries[0].DepartedAt)
+	log.Printf("Discord ID %s left; %d accounts held for %s", discordID, len(entries), formatGracePeriod(a.Config.DepartureGracePeriod))
+}
+
+// onUserJoined gives a member who comes back within the grace period their
+// accounts and role back.
+func (a *App) onUserJoined(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if a.Config.GuildID != "" && m.GuildID != a.Config.GuildID {
+		return
+	}
+	discordID := m.User.ID
+	entries, err := a.Store.RestoreWhitelistEntries(context.Background(), discordID)
+	if err != nil {
+		log.Printf("Error restoring whitelist entries for Discord ID %s: %v", discordID, err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	names := make([]string, len(entries))
+	for n, entry := range entries {
+		names[n] = fmt.Sprintf("`%s`", entry.MinecraftUsername)
+		audit := entities.AuditEntry{
+			ActorID:    entities.AuditSystem,
+			Action:     entities.AuditWhitelistRestore,
+			TargetUser: discordID,
+			Target:     entry.MinecraftUsername,
+			Reason:     "Rejoined the Discord server within the grace period",
+		}
+		msg := fmt.Sprintf("whitelist add %s\n", entry.MinecraftUsername)
+		if err := a.queueBridgeCommand(msg, bridgePriorityNormal, playerHeaders(entry)); err != nil {
+			log.Printf("Error queueing whitelist add for %s: %v", entry.MinecraftUsername, err)
+			audit.Result = "whitelist add not queued: " + err.Error()
+		}
+		a.audit(audit)
+	}
+	if err := s.GuildMemberRoleAdd(a.Config.GuildID, discordID, a.Config.MemberRoleID); err != nil {
+		log.Printf("Failed to assign role to %s: %v", discordID, err)
+	}
+	if dm, err := s.UserChannelCreate(discordID); err == nil {
+		_, _ = s.ChannelMessageSend(dm.ID, fmt.Sprintf(
+			"👋 Welcome back! Your whitelist for %s on Rotaria has been restored.",
+			strings.Join(names, ", "),
+		))
+	}
+	log.Printf("Restored %d whitelist entries for returning Discord ID %s", len(entries), discordID)
+}
+
+// purgeDeparted deletes a member's accounts whose grace period is over.
+func (a *App) purgeDeparted(ctx context.Context, discordID string) error {
+	if discordID == "" {
+		return nil
+	}
+	purged, err := a.Store.PurgeDepartedWhitelistEntries(ctx, discordID, time.Now().Add(-a.Config.DepartureGracePeriod))
+	if err != nil {
+		log.Printf("Error purging departed whitelist entries for Discord ID %s: %v", discordID, err)
+		return err
+	}
+	for _, entry := range purged {
+		a.audit(entities.AuditEntry{
+			ActorID:    entities.AuditSystem,
+			Action:     entities.AuditWhitelistRemove,
+			TargetUser: discordID,
+			Target:     entry.MinecraftUsername,
+			Reason:     "Did not rejoin the Discord server within the grace period",
+		})
+	}
+	return nil
+}
+
+type departedMember struct {
+	discordID string
+	at        time.Time
+	names     []string
+}
+
+// departedMembers groups departed entries by member, earliest departure
+// first.
+func departedMembers(entries []entities.WhiteListEntry) []departedMember {
+	var out []departedMember
+	index := make(map[string]int)
+	for _, e := range entries {
+		n, ok := index[e.DiscordID]
+		if !ok {
+			n = len(out)
+			index[e.DiscordID] = n
+			out = append(out, departedMember{discordID: e.DiscordID, at: e.DepartedAt})
+		}
+		out[n].names = append(out[n].names, e.MinecraftUsername)
+	}
+	return out
+}
+
+// sendDepartureDigest tells staff whose whitelist runs out in the next day,
+// and purges anyone whose job was missed.
+func (a *App) sendDepartureDigest(ctx context.Context) {
+	departed, err := a.Store.ListDepartedWhitelistEntries(ctx)
+	if err != nil {
+		log.Printf("Error listing departed members: %v", err)
+		return
+	}
+	now := time.Now()
+	var lines []string
+	for _, m := range departedMembers(departed) {
+		expires := m.at.Add(a.Config.DepartureGracePeriod)
+		if !expires.After(now) {
+			_ = a.purgeDeparted(ctx, m.discordID) // logged; tried again tomorrow
+			continue
+		}
+		if expires.Sub(now) > departureDigestInterval {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("<@%s> • `%s` • expires <t:%d:R>",
+			m.discordID, strings.Join(m.names, "`, `"), expires.Unix()))
+	}
+	if len(lines) == 0 || a.Config.WhitelistRequestsChannelID == "" || a.DiscordSession == nil {
+		return
+	}
+	total := len(lines)
+	if total > departureDigestLimit {
+		lines = lines[:departureDigestLimit]
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "Expiring Whitelist Removals",
+		Description: strings.Join(lines, "\n"),
+		Color:       0xF59E0B, // amber
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d members left Discord and will lose their whitelist in the next 24 hours unless they rejoin", total),
+		},
+		Timestamp: now.UTC().Format(time.RFC3339),
+	}
+	if _, err := a.DiscordSession.ChannelMessageSendEmbed(a.Config.WhitelistRequestsChannelID, embed); err != nil {
+		log.Printf("Error sending departure digest: %v", err)
+	}
+}
+
+// formatGracePeriod renders d in the largest unit that divides it, e.g.
+// "7 days", "36 hours" or "90 minutes".
+func formatGracePeriod(d time.Duration) string {
+	plural := func(n time.Duration, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return plural(d/(24*time.Hour), "day")
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(d/time.Hour, "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(d/time.Minute, "minute")
+	}
+	return d.String()
+}
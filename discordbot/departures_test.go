@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"limpan/rotaria-bot/internals/imq"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// startTestDepartures runs the departures worker for a on the queues in
+// queues, with a bridge queue nothing consumes so its commands can be
+// inspected.
+func startTestDepartures(t *testing.T, a *App, queues *db.SQLStore) *imq.SQLiteQueue {
+	t.Helper()
+	bridge, err := imq.NewSQLite(queues.Conn, "bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.bridgeQueue = bridge
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.startDepartures(ctx, queues.Conn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		stopCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		if err := a.departureWorker.Stop(stopCtx); err != nil {
+			t.Errorf("stop departures worker: %v", err)
+		}
+	})
+	return bridge
+}
+
+func addTestEntries(t *testing.T, s db.Store, discordID string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		err := s.AddWhitelistEntry(context.Background(), entities.WhiteListEntry{DiscordID: discordID, MinecraftUsername: name}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func bridgeCommands(t *testing.T, q *imq.SQLiteQueue) []string {
+	t.Helper()
+	msgs, err := q.Peek(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmds []string
+	for _, m := range msgs {
+		cmds = append(cmds, strings.TrimSpace(string(m.Body)))
+	}
+	return cmds
+}
+
+func auditActions(t *testing.T, s db.Store, discordID string) []string {
+	t.Helper()
+	entries, err := s.SearchAudit(context.Background(), db.AuditFilter{UserID: discordID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for i := len(entries) - 1; i >= 0; i-- { // oldest first
+		actions = append(actions, entries[i].Action+" "+entries[i].Target)
+	}
+	return actions
+}
+
+func TestDepartedMemberIsPurgedAfterGracePeriod(t *testing.T) {
+	store := openTestStore(t)
+	a := &App{Store: store, Config: Config{DepartureGracePeriod: 300 * time.Millisecond}}
+	bridge := startTestDepartures(t, a, store)
+	addTestEntries(t, store, "1", "Steve", "Alex")
+	addTestEntries(t, store, "2", "Notch")
+	ctx := context.Background()
+
+	a.departMember("1")
+	if got, _ := store.GetWhitelistEntries(ctx, "1"); len(got) != 0 {
+		t.Fatalf("entries right after leaving = %+v", got)
+	}
+	if e, _ := store.GetWhitelistEntryByUsername(ctx, "Steve"); e == nil || e.DepartedAt.IsZero() {
+		t.Fatalf("Steve right after leaving = %+v, want held as departed", e)
+	}
+	want := []string{"unwhitelist Steve", "unwhitelist Alex"}
+	if got := bridgeCommands(t, bridge); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bridge commands = %q, want %q", got, want)
+	}
+	jobs, _ := a.departureQueue.(imq.Inspector).Peek(ctx, 0)
+	if len(jobs) != 1 || !strings.HasPrefix(jobs[0].ID, "purge-1-") || jobs[0].Headers["discord_id"] != "1" {
+		t.Fatalf("departure jobs = %+v, want one purge for 1", jobs)
+	}
+
+	waitFor(t, "the purge", func() bool {
+		departed, _ := store.ListDepartedWhitelistEntries(ctx)
+		return len(departed) == 0
+	})
+	if e, _ := store.GetWhitelistEntryByUsername(ctx, "Steve"); e != nil {
+		t.Fatalf("Steve after the purge = %+v", e)
+	}
+	if e, _ := store.GetWhitelistEntryByUsername(ctx, "Notch"); e == nil {
+		t.Fatal("purge took someone who never left")
+	}
+	want = []string{"whitelist.depart Steve", "whitelist.depart Alex", "whitelist.remove Steve", "whitelist.remove Alex"}
+	if got := auditActions(t, store, "1"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("audit = %q, want %q", got, want)
+	}
+}
+
+func TestRejoiningRestoresWhitelist(t *testing.T) {
+	store := openTestStore(t)
+	session, discord := newFakeSession(t)
+	a := &App{
+		Store:          store,
+		DiscordSession: session,
+		Config:         Config{GuildID: "10", MemberRoleID: "20", DepartureGracePeriod: 300 * time.Millisecond},
+	}
+	bridge := startTestDepartures(t, a, store)
+	addTestEntries(t, store, "1", "Steve")
+	ctx := context.Background()
+
+	a.departMember("1")
+	a.onUserJoined(session, &discordgo.GuildMemberAdd{Member: &discordgo.Member{GuildID: "10", User: &discordgo.User{ID: "1"}}})
+	if got, _ := store.GetWhitelistEntries(ctx, "1"); len(got) != 1 || !got[0].DepartedAt.IsZero() {
+		t.Fatalf("entries after rejoining = %+v", got)
+	}
+	want := []string{"unwhitelist Steve", "whitelist add Steve"}
+	if got := bridgeCommands(t, bridge); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bridge commands = %q, want %q", got, want)
+	}
+	reqs := strings.Join(discord.Requests(), "\n")
+	for _, want := range []string{"PUT /guilds/10/members/1/roles/20", "POST /users/@me/channels", "POST /channels/100/messages"} {
+		if !strings.Contains(reqs, want) {
+			t.Errorf("no %s in Discord requests:\n%s", want, reqs)
+		}
+	}
+	if !strings.Contains(reqs, "Welcome back") || !strings.Contains(reqs, "`Steve`") {
+		t.Errorf("welcome back DM not sent:\n%s", reqs)
+	}
+
+	// The purge that was scheduled when they left finds nothing to do.
+	waitFor(t, "the stale purge job", func() bool {
+		msgs, _ := a.departureQueue.(imq.Inspector).Peek(ctx, 0)
+		return len(msgs) == 0
+	})
+	if got, _ := store.GetWhitelistEntries(ctx, "1"); len(got) != 1 {
+		t.Fatalf("entries after the grace period = %+v", got)
+	}
+
+	// A member from another guild is none of our business.
+	a.departMember("1")
+	a.onUserJoined(session, &discordgo.GuildMemberAdd{Member: &discordgo.Member{GuildID: "11", User: &discordgo.User{ID: "1"}}})
+	if got, _ := store.GetWhitelistEntries(ctx, "1"); len(got) != 0 {
+		t.Fatalf("restored on joining another guild: %+v", got)
+	}
+}
+
+func TestFailedPurgeIsRetried(t *testing.T) {
+	store := openTestStore(t)
+	a := &App{Store: store, Config: Config{DepartureGracePeriod: time.Hour}}
+	startTestDepartures(t, a, openTestStore(t))
+	store.Close()
+
+	a.schedulePurge(context.Background(), "1", time.Now().Add(-2*time.Hour))
+	var job imq.Message
+	waitFor(t, "a failed attempt", func() bool {
+		msgs, _ := a.departureQueue.(imq.Inspector).Peek(context.Background(), 0)
+		if len(msgs) == 1 && msgs[0].Attempts > 0 && msgs[0].LastError != "" {
+			job = msgs[0]
+			return true
+		}
+		return false
+	})
+	if !strings.Contains(job.LastError, "closed") {
+		t.Fatalf("LastError = %q, want the store's error", job.LastError)
+	}
+	if n := a.departureDLQ.Len(); n != 0 {
+		t.Fatalf("dead-lettered after the first failure (%d)", n)
+	}
+}
+
+func TestDepartureDigest(t *testing.T) {
+	store := openTestStore(t)
+	session, discord := newFakeSession(t)
+	grace := 48 * time.Hour
+	a := &App{
+		Store:          store,
+		DiscordSession: session,
+		Config:         Config{WhitelistRequestsChannelID: "30", DepartureGracePeriod: grace},
+	}
+	ctx := context.Background()
+	now := time.Now()
+	depart := func(discordID string, at time.Time, names ...string) {
+		addTestEntries(t, store, discordID, names...)
+		if _, err := store.DepartWhitelistEntries(ctx, discordID, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	depart("1", now.Add(-grace+time.Hour), "Steve", "Alex") // expires within a day
+	depart("2", now.Add(-time.Hour), "Notch")               // expires in two days
+	depart("3", now.Add(-grace-time.Hour), "Herobrine")     // purge was missed
+
+	a.sendDepartureDigest(ctx)
+
+	reqs := discord.Requests()
+	if len(reqs) != 1 || !strings.HasPrefix(reqs[0], "POST /channels/30/messages ") {
+		t.Fatalf("Discord requests = %q, want one post to the requests channel", reqs)
+	}
+	var msg discordgo.MessageSend
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(reqs[0], "POST /channels/30/messages ")), &msg); err != nil || len(msg.Embeds) != 1 {
+		t.Fatalf("digest post %s: %v", reqs[0], err)
+	}
+	digest := msg.Embeds[0].Description
+	for _, want := range []string{"<@1>", "`Steve`, `Alex`"} {
+		if !strings.Contains(digest, want) {
+			t.Errorf("digest has no %q: %s", want, digest)
+		}
+	}
+	for _, notWant := range []string{"<@2>", "<@3>"} {
+		if strings.Contains(digest, notWant) {
+			t.Errorf("digest lists %s: %s", notWant, digest)
+		}
+	}
+	if e, _ := store.GetWhitelistEntryByUsername(ctx, "Herobrine"); e != nil {
+		t.Fatalf("missed purge not caught up: %+v", e)
+	}
+	if e, _ := store.GetWhitelistEntryByUsername(ctx, "Notch"); e == nil {
+		t.Fatal("Notch purged before the grace period was over")
+	}
+
+	// Nothing expiring soon, nothing sent.
+	if _, err := store.RestoreWhitelistEntries(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	a.sendDepartureDigest(ctx)
+	if n := len(discord.Requests()); n != 1 {
+		t.Fatalf("%d posts, want no second digest", n)
+	}
+}
+
+func TestFormatGracePeriod(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{7 * 24 * time.Hour, "7 days"},
+		{24 * time.Hour, "1 day"},
+		{36 * time.Hour, "36 hours"},
+		{time.Hour, "1 hour"},
+		{90 * time.Minute, "90 minutes"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		if got := formatGracePeriod(tt.d); got != tt.want {
+			t.Errorf("formatGracePeriod(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+type ApplicationStatus string
+
+const (
+	ApplicationPending   ApplicationStatus = "pending"
+	ApplicationApproved  ApplicationStatus = "approved"
+	ApplicationRejected  ApplicationStatus = "rejected"
+	ApplicationWithdrawn ApplicationStatus = "withdrawn"
+)
+
+// Application is a whitelist request and what happened to it.
+type Application struct {
+	ID                int64
+	DiscordID         string
+	MinecraftUsername string
+	MinecraftUUID     string
+	Age               string
+	Plan              string
+	Status            ApplicationStatus
+	ReviewerID        string // staff member who decided; empty while pending or when withdrawn
+	CreatedAt         time.Time
+	DecidedAt         time.Time // zero while pending
+	ChannelID         string    // review message, once posted
+	MessageID         string
+}
@@ -0,0 +1,52 @@
+package entities
+
+import "time"
+
+// AuditSystem is the actor for things the bot does on its own.
+const AuditSystem = "system"
+
+// Audit actions. Add new ones here so /audit search can offer them.
+const (
+	AuditApplicationApprove  = "application.approve"
+	AuditApplicationReject   = "application.reject"
+	AuditApplicationWithdraw = "application.withdraw"
+	AuditReportClaim         = "report.claim"
+	AuditReportResolve       = "report.resolve"
+	AuditReportDismiss       = "report.dismiss"
+	AuditWhitelistLink       = "whitelist.link"
+	AuditWhitelistRemove     = "whitelist.remove"
+	AuditWhitelistDepart     = "whitelist.depart"
+	AuditWhitelistRestore    = "whitelist.restore"
+	AuditWhitelistImport     = "whitelist.import"
+	AuditWhitelistExport     = "whitelist.export"
+	AuditWhitelistSync       = "whitelist.sync"
+	AuditWhitelistDismiss    = "whitelist.dismiss"
+	AuditPlayerKick          = "player.kick"
+	AuditChatDelete          = "chat.delete"
+	AuditQueueReplay         = "queue.replay"
+	AuditQueuePurge          = "queue.purge"
+	AuditDBBackup            = "db.backup"
+)
+
+var AuditActions = []string{
+	AuditApplicationApprove, AuditApplicationReject, AuditApplicationWithdraw,
+	AuditReportClaim, AuditReportResolve, AuditReportDismiss,
+	AuditWhitelistLink, AuditWhitelistRemove, AuditWhitelistDepart, AuditWhitelistRestore, AuditWhitelistImport, AuditWhitelistExport, AuditWhitelistSync, AuditWhitelistDismiss,
+	AuditPlayerKick, AuditChatDelete,
+	AuditQueueReplay, AuditQueuePurge, AuditDBBackup,
+}
+
+// Audit results other than an error message.
+const AuditOK = "ok"
+
+// AuditEntry is one row of the append-only audit log.
+type AuditEntry struct {
+	ID         int64
+	At         time.Time
+	ActorID    string // staff Discord ID, or AuditSystem
+	Action     string
+	TargetUser string // Discord ID the action was about, if any
+	Target     string // what was acted on: a Minecraft name, R-0042, #12, ...
+	Reason     string
+	Result     string // AuditOK or what went wrong
+}
@@ -0,0 +1,20 @@
+package entities
+
+import "time"
+
+// Where an archived chat message was written.
+const (
+	ChatFromMinecraft = "minecraft"
+	ChatFromDiscord   = "discord"
+)
+
+// ChatMessage is one archived line of relayed chat, in either direction.
+type ChatMessage struct {
+	ID       int64
+	At       time.Time
+	Source   string // ChatFromMinecraft or ChatFromDiscord
+	Author   string // Minecraft name or Discord display name
+	AuthorID string // Mojang UUID or Discord ID, when known
+	Content  string
+	Blocked  bool // caught by the blacklist and not relayed
+}
@@ -0,0 +1,52 @@
+package entities
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+// Report is something a member flagged for staff, and how it was handled.
+type Report struct {
+	ID         int64
+	Type       string // player, bug, other
+	ReporterID string
+	Subject    string // reported player, for player reports
+	Details    string
+	Evidence   string
+	Context    string
+	Status     ReportStatus
+	AssigneeID string // staff member handling it; set on claim or close
+	Resolution string // moderator note left when closing
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ClosedAt   time.Time // zero while open
+	ChannelID  string    // review message, once posted
+	MessageID  string
+}
+
+// ShortID is the ID staff see and type, e.g. R-0042.
+func (r *Report) ShortID() string { return FormatReportID(r.ID) }
+
+func FormatReportID(id int64) string { return fmt.Sprintf("R-%04d", id) }
+
+// ParseReportID accepts "R-0042", "r42" or plain "42".
+func ParseReportID(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "R"), "r")
+	s = strings.TrimPrefix(s, "-")
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
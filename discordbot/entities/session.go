@@ -0,0 +1,21 @@
+package entities
+
+import "time"
+
+// Why a session ended.
+const (
+	SessionLeft     = "leave"    // the player logged out
+	SessionShutdown = "shutdown" // the server announced it was stopping
+	SessionOutage   = "outage"   // the bridge went down; ended at the last time we saw them
+	SessionRestart  = "restart"  // the bot restarted with the session still open
+)
+
+// Playtime sums a player's sessions over some period.
+type Playtime struct {
+	MinecraftUsername string
+	Total             time.Duration
+	Sessions          int
+	FirstSeen         time.Time // first join in the period
+	LastSeen          time.Time // last leave, or now while online
+	Online            bool
+}
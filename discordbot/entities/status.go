@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// Rollup resolutions. Daily buckets start at midnight UTC.
+const (
+	StatusHourly = time.Hour
+	StatusDaily  = 24 * time.Hour
+)
+
+// StatusSample is one status report from the mod, sent every ~20 seconds.
+type StatusSample struct {
+	At      time.Time
+	TPS     float64
+	Players int
+}
+
+// StatusRollup summarises the samples in one hour or day.
+type StatusRollup struct {
+	Bucket     time.Time // start of the period
+	Resolution time.Duration
+	Samples    int
+	TPSMin     float64
+	TPSAvg     float64
+	TPSMax     float64
+	PlayersAvg float64
+	PlayersMax int
+}
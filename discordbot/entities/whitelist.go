@@ -1,7 +1,25 @@
 package entities
 
+import "time"
+
 type WhiteListEntry struct {
 	ID                int
 	DiscordID         string
 	MinecraftUsername string
+	MinecraftUUID     string // Mojang UUID, no dashes; empty for rows from before UUIDs were stored
+	// DepartedAt is when the member left Discord; zero while they're a
+	// member. Departed entries are purged once the grace period runs out.
+	DepartedAt time.Time
+}
+
+// WhitelistConflict is a whitelist row that a migration had to take out of
+// the whitelist (a duplicate, or an extra account when alts were turned
+// off). It waits for staff to re-add or dismiss it.
+type WhitelistConflict struct {
+	ID                int // the row's id in the whitelist
+	DiscordID         string
+	MinecraftUsername string
+	MinecraftUUID     string
+	Reason            string
+	MovedAt           time.Time
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"limpan/rotaria-bot/internals/db"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// openTestStore opens a migrated SQLite store in a temp dir.
+func openTestStore(t *testing.T) *db.SQLStore {
+	t.Helper()
+	s, err := db.OpenStore(context.Background(), filepath.Join(t.TempDir(), "rotaria.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// fakeDiscord stands in for the Discord REST API: every request succeeds
+// with a stub object, and is recorded as "METHOD /path body".
+type fakeDiscord struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeDiscord) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v"+discordgo.APIVersion)
+	f.mu.Lock()
+	f.requests = append(f.requests, strings.TrimSpace(r.Method+" "+path+" "+string(body)))
+	f.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": "100"}`)),
+		Request:    r,
+	}, nil
+}
+
+func (f *fakeDiscord) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// newFakeSession returns a session whose REST calls go to a fakeDiscord.
+func newFakeSession(t *testing.T) (*discordgo.Session, *fakeDiscord) {
+	t.Helper()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeDiscord{}
+	s.Client = &http.Client{Transport: f}
+	return s, f
+}
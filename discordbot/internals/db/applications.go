@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"strings"
+	"time"
+)
+
+const applicationColumns = `id, discord_id, minecraft_username, minecraft_uuid, age, plan, status,
+	reviewer_id, created_at, decided_at, channel_id, message_id`
+
+func scanApplication(row scanner) (*entities.Application, error) {
+	var (
+		app                            entities.Application
+		uuid, reviewer, channel, msgID sql.NullString
+		createdAt                      int64
+		decidedAt                      sql.NullInt64
+	)
+	err := row.Scan(&app.ID, &app.DiscordID, &app.MinecraftUsername, &uuid, &app.Age, &app.Plan, &app.Status,
+		&reviewer, &createdAt, &decidedAt, &channel, &msgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	app.MinecraftUUID = uuid.String
+	app.ReviewerID = reviewer.String
+	app.CreatedAt = time.Unix(createdAt, 0)
+	if decidedAt.Valid {
+		app.DecidedAt = time.Unix(decidedAt.Int64, 0)
+	}
+	app.ChannelID = channel.String
+	app.MessageID = msgID.String
+	return &app, nil
+}
+
+func (s *SQLStore) CreateApplication(ctx context.Context, app *entities.Application) error {
+	app.Status = entities.ApplicationPending
+	app.CreatedAt = time.Now()
+	id, err := s.insertID(ctx, `INSERT INTO applications
+		(discord_id, minecraft_username, minecraft_uuid, age, plan, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		app.DiscordID, app.MinecraftUsername, nullString(app.MinecraftUUID), app.Age, app.Plan, app.Status, app.CreatedAt.Unix())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrApplicationPending
+		}
+		return err
+	}
+	app.ID = id
+	return nil
+}
+
+func (s *SQLStore) GetApplication(ctx context.Context, id int64) (*entities.Application, error) {
+	return scanApplication(s.queryRow(ctx, `SELECT `+applicationColumns+` FROM applications WHERE id = ?`, id))
+}
+
+func (s *SQLStore) SetApplicationMessage(ctx context.Context, id int64, channelID, messageID string) error {
+	res, err := s.exec(ctx, `UPDATE applications SET channel_id = ?, message_id = ? WHERE id = ?`, channelID, messageID, id)
+	return requireRow(res, err, ErrApplicationNotFound)
+}
+
+func (s *SQLStore) DecideApplication(ctx context.Context, id int64, status entities.ApplicationStatus, reviewerID string) error {
+	res, err := s.exec(ctx, `UPDATE applications SET status = ?, reviewer_id = ?, decided_at = ?
+		WHERE id = ? AND status = ?`,
+		status, nullString(reviewerID), time.Now().Unix(), id, entities.ApplicationPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// Nothing changed: tell a missing application from a decided one.
+	var current entities.ApplicationStatus
+	err = s.queryRow(ctx, `SELECT status FROM applications WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrApplicationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", ErrApplicationClosed, current)
+}
+
+func (s *SQLStore) ReopenApplication(ctx context.Context, id int64, status entities.ApplicationStatus) error {
+	res, err := s.exec(ctx, `UPDATE applications SET status = ?, reviewer_id = NULL, decided_at = NULL
+		WHERE id = ? AND status = ?`, entities.ApplicationPending, id, status)
+	if err != nil && isUniqueViolation(err) {
+		return ErrApplicationPending
+	}
+	return requireRow(res, err, ErrApplicationClosed)
+}
+
+func (s *SQLStore) WithdrawApplications(ctx context.Context, discordID string) ([]entities.Application, error) {
+	var apps []entities.Application
+	err := s.inTx(ctx, func(tx *txn) error {
+		var err error
+		apps, err = queryApplications(ctx, tx, `SELECT `+applicationColumns+` FROM applications
+			WHERE discord_id = ? AND status = ?`, discordID, entities.ApplicationPending)
+		if err != nil || len(apps) == 0 {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.exec(ctx, `UPDATE applications SET status = ?, decided_at = ? WHERE discord_id = ? AND status = ?`,
+			entities.ApplicationWithdrawn, now.Unix(), discordID, entities.ApplicationPending)
+		if err != nil {
+			return err
+		}
+		for i := range apps {
+			apps[i].Status = entities.ApplicationWithdrawn
+			apps[i].DecidedAt = now
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+func (s *SQLStore) SearchApplications(ctx context.Context, f ApplicationFilter) ([]entities.Application, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.DiscordID != "" {
+		where = append(where, "discord_id = ?")
+		args = append(args, f.DiscordID)
+	}
+	if f.MinecraftUsername != "" {
+		where = append(where, s.Dialect.nocaseLike("minecraft_username"))
+		args = append(args, "%"+escapeLike(f.MinecraftUsername)+"%")
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	q := `SELECT ` + applicationColumns + ` FROM applications`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, f.limit())
+	return queryApplications(ctx, s, q, args...)
+}
+
+// querier is a SQLStore or a txn.
+type querier interface {
+	query(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryApplications(ctx context.Context, q querier, query string, args ...any) ([]entities.Application, error) {
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.Application
+	for rows.Next() {
+		app, err := scanApplication(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *app)
+	}
+	return out, rows.Err()
+}
+
+func requireRow(res sql.Result, err error, notFound error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string { return likeEscaper.Replace(s) }
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"limpan/rotaria-bot/entities"
+	"strings"
+	"time"
+)
+
+const auditColumns = `id, at, actor_id, action, target_user, target, reason, result`
+
+func (s *SQLStore) AppendAudit(ctx context.Context, e *entities.AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	id, err := s.insertID(ctx, `INSERT INTO audit_log
+		(at, actor_id, action, target_user, target, reason, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.At.Unix(), e.ActorID, e.Action, e.TargetUser, e.Target, e.Reason, e.Result)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (s *SQLStore) SearchAudit(ctx context.Context, f AuditFilter) ([]entities.AuditEntry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.UserID != "" {
+		where = append(where, "(actor_id = ? OR target_user = ?)")
+		args = append(args, f.UserID, f.UserID)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "at < ?")
+		args = append(args, f.Until.Unix())
+	}
+	q := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY at DESC, id DESC LIMIT ?`
+	args = append(args, f.limit())
+
+	rows, err := s.query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.AuditEntry
+	for rows.Next() {
+		var (
+			e  entities.AuditEntry
+			at int64
+		)
+		if err := rows.Scan(&e.ID, &at, &e.ActorID, &e.Action, &e.TargetUser, &e.Target, &e.Reason, &e.Result); err != nil {
+			return nil, err
+		}
+		e.At = time.Unix(at, 0)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
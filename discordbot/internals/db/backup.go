@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backups are taken online with VACUUM INTO, which writes a compact,
+// consistent copy while the bot keeps running, and are checked with
+// PRAGMA integrity_check before they count. They are SQLite-only; a Postgres
+// database is backed up with pg_dump.
+
+const (
+	backupPrefix     = "rotaria-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405Z"
+
+	sqliteMagic = "SQLite format 3\x00"
+)
+
+var ErrCorrupt = errors.New("db: integrity check failed")
+
+// Backups writes copies of the live database into Dir and prunes old ones.
+type Backups struct {
+	Conn *sql.DB
+	Dir  string
+
+	// Rotate keeps the newest backup of each of the last KeepDaily days and
+	// of each of the last KeepWeekly ISO weeks; everything else is deleted.
+	KeepDaily  int
+	KeepWeekly int
+}
+
+type BackupInfo struct {
+	Path string
+	Size int64
+	At   time.Time
+}
+
+// Create writes a new backup and verifies it. A backup that fails the
+// check is removed.
+func (b *Backups) Create(ctx context.Context) (BackupInfo, error) {
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return BackupInfo{}, err
+	}
+	at := time.Now().UTC().Truncate(time.Second)
+	path := filepath.Join(b.Dir, backupPrefix+at.Format(backupTimeLayout)+backupSuffix)
+	if _, err := os.Stat(path); err == nil {
+		return BackupInfo{}, fmt.Errorf("db: backup %s already exists", path)
+	}
+	if _, err := b.Conn.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		os.Remove(path)
+		return BackupInfo{}, fmt.Errorf("vacuum into %s: %w", path, err)
+	}
+	if err := VerifyIntegrity(ctx, path); err != nil {
+		os.Remove(path)
+		return BackupInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return BackupInfo{Path: path, Size: fi.Size(), At: at}, nil
+}
+
+// List returns the backups in Dir, newest first.
+func (b *Backups) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []BackupInfo
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, backupPrefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, backupSuffix)
+		if !ok {
+			continue
+		}
+		at, err := time.Parse(backupTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, BackupInfo{Path: filepath.Join(b.Dir, name), Size: fi.Size(), At: at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].At.After(out[j].At) })
+	return out, nil
+}
+
+// Rotate deletes backups outside the retention policy and returns them.
+func (b *Backups) Rotate() ([]BackupInfo, error) {
+	all, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, bk := range all { // newest first, so the first of each period wins
+		day := bk.At.Format("2006-01-02")
+		if !days[day] && len(days) < b.KeepDaily {
+			days[day] = true
+			keep[bk.Path] = true
+		}
+		y, w := bk.At.ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", y, w)
+		if !weeks[week] && len(weeks) < b.KeepWeekly {
+			weeks[week] = true
+			keep[bk.Path] = true
+		}
+	}
+	var removed []BackupInfo
+	for _, bk := range all {
+		if keep[bk.Path] {
+			continue
+		}
+		if err := os.Remove(bk.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, bk)
+	}
+	return removed, nil
+}
+
+// VerifyIntegrity runs PRAGMA integrity_check on the database at path.
+func VerifyIntegrity(ctx context.Context, path string) error {
+	// SQLite treats a file too short to have a header as an empty database,
+	// which would pass the pragma, so check the header first.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(sqliteMagic))
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil || string(header) != sqliteMagic {
+		return fmt.Errorf("%w: %s is not a SQLite database", ErrCorrupt, path)
+	}
+	store, err := Open("file:" + path + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	rows, err := store.Conn.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrCorrupt, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Restore replaces the database at dest with the backup at src, after
+// checking the backup. The bot must not be running against dest.
+func Restore(ctx context.Context, src, dest string) error {
+	if err := VerifyIntegrity(ctx, src); err != nil {
+		return fmt.Errorf("backup %s: %w", src, err)
+	}
+	tmp := dest + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Stale WAL files from the old database would be replayed over the
+	// restored one.
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(dest + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dest)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"limpan/rotaria-bot/entities"
+)
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name                  string
+		keepDaily, keepWeekly int
+		backups               []string // stamps in backupTimeLayout
+		want                  []string // the ones left, newest first
+	}{
+		{
+			name:      "newest per day",
+			keepDaily: 2,
+			backups:   []string{"20240115T120000Z", "20240115T060000Z", "20240114T230000Z", "20240113T100000Z"},
+			want:      []string{"20240115T120000Z", "20240114T230000Z"},
+		},
+		{
+			// 2024-01-14 is a Sunday, so it and the 13th share ISO week 2;
+			// 2023-12-31 is in week 52 of 2023, 2024-01-01 in week 1.
+			name:       "weeks reach further back than days",
+			keepDaily:  2,
+			keepWeekly: 4,
+			backups: []string{
+				"20240115T120000Z", "20240115T060000Z", "20240114T230000Z", "20240113T100000Z",
+				"20240107T100000Z", "20240101T100000Z", "20231231T100000Z", "20231225T100000Z",
+			},
+			want: []string{"20240115T120000Z", "20240114T230000Z", "20240107T100000Z", "20231231T100000Z"},
+		},
+		{
+			// 2024-12-30 is in ISO week 1 of 2025, like 2025-01-02.
+			name:       "ISO week across new year",
+			keepWeekly: 2,
+			backups:    []string{"20250102T100000Z", "20241230T100000Z", "20241229T100000Z"},
+			want:       []string{"20250102T100000Z", "20241229T100000Z"},
+		},
+		{
+			name:    "keep nothing",
+			backups: []string{"20240115T120000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, stamp := range tt.backups {
+				if err := os.WriteFile(filepath.Join(dir, backupPrefix+stamp+backupSuffix), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Files that aren't backups are left alone.
+			others := []string{"notes.txt", "rotaria-latest.db", "rotaria.db"}
+			for _, name := range others {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b := &Backups{Dir: dir, KeepDaily: tt.keepDaily, KeepWeekly: tt.keepWeekly}
+			removed, err := b.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			left, err := b.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, bk := range left {
+				got = append(got, bk.At.Format(backupTimeLayout))
+			}
+			if len(got) != len(tt.want) || len(removed) != len(tt.backups)-len(tt.want) {
+				t.Fatalf("kept %v (removed %d), want %v", got, len(removed), tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept %v, want %v", got, tt.want)
+				}
+			}
+			entries, _ := os.ReadDir(dir)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			sort.Strings(names)
+			for _, name := range others {
+				if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+					t.Errorf("%s was removed", name)
+				}
+			}
+		})
+	}
+}
+
+// newTestBackup makes a backup of a small store and returns its path.
+func newTestBackup(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	s, err := OpenStore(ctx, filepath.Join(t.TempDir(), "rotaria.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.AddWhitelistEntry(ctx, entities.WhiteListEntry{DiscordID: "1", MinecraftUsername: "Steve", MinecraftUUID: "uuid-steve"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := (&Backups{Conn: s.Conn, Dir: t.TempDir()}).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Path
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	ctx := context.Background()
+	good := newTestBackup(t)
+	if err := VerifyIntegrity(ctx, good); err != nil {
+		t.Fatalf("fresh backup: %v", err)
+	}
+	data, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"cut inside the header", data[:10]},
+		{"cut after the first page", data[:4096]},
+		{"cut in half", data[:len(data)/2]},
+		{"not a database", []byte("PRAGMA integrity_check; -- definitely not SQLite")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bad.db")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyIntegrity(ctx, path); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("VerifyIntegrity = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	backup := newTestBackup(t)
+	dest := filepath.Join(t.TempDir(), "rotaria.db")
+	// The old database, with WAL files that must not be replayed over the
+	// restored copy.
+	for _, p := range []string{dest, dest + "-wal", dest + "-shm"} {
+		if err := os.WriteFile(p, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Restore(ctx, backup, dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{dest + "-wal", dest + "-shm", dest + ".restore"} {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still there after restore (%v)", filepath.Base(p), err)
+		}
+	}
+	s, err := Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	e, err := s.GetWhitelistEntryByUsername(ctx, "steve")
+	if err != nil || e == nil || e.DiscordID != "1" {
+		t.Fatalf("restored whitelist entry = %+v, %v", e, err)
+	}
+}
+
+func TestRestoreRejectsTruncatedBackup(t *testing.T) {
+	ctx := context.Background()
+	data, err := os.ReadFile(newTestBackup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.db")
+	if err := os.WriteFile(bad, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "rotaria.db")
+	if err := os.WriteFile(dest, []byte("current"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(ctx, bad, dest); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Restore = %v, want ErrCorrupt", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "current" {
+		t.Fatalf("database was replaced by a bad backup: %q", got)
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"limpan/rotaria-bot/entities"
+	"strings"
+	"time"
+)
+
+const chatColumns = `m.id, m.at, m.source, m.author, m.author_id, m.content, m.blocked`
+
+func (s *SQLStore) ArchiveChat(ctx context.Context, m *entities.ChatMessage) error {
+	if m.At.IsZero() {
+		m.At = time.Now()
+	}
+	id, err := s.insertID(ctx, `INSERT INTO chat_messages
+		(at, source, author, author_id, content, blocked) VALUES (?, ?, ?, ?, ?, ?)`,
+		m.At.Unix(), m.Source, m.Author, m.AuthorID, m.Content, m.Blocked)
+	if err != nil {
+		return err
+	}
+	m.ID = id
+	return nil
+}
+
+func (s *SQLStore) SearchChat(ctx context.Context, f ChatFilter) ([]entities.ChatMessage, error) {
+	var (
+		where []string
+		args  []any
+	)
+	q := `SELECT ` + chatColumns + ` FROM chat_messages m`
+	switch {
+	case strings.TrimSpace(f.Query) == "":
+	case s.Dialect == Postgres:
+		// 0008 keeps a tsvector of content in content_tsv; plainto_tsquery
+		// matches every word, like ftsQuery does for FTS5.
+		where = append(where, "m.content_tsv @@ plainto_tsquery('simple', ?)")
+		args = append(args, f.Query)
+	default:
+		q += ` JOIN chat_fts ON chat_fts.rowid = m.id`
+		where = append(where, "chat_fts MATCH ?")
+		args = append(args, ftsQuery(f.Query))
+	}
+	if f.Author != "" {
+		where = append(where, "("+s.Dialect.nocaseEq("m.author")+" OR m.author_id = ?)")
+		args = append(args, f.Author, f.Author)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "m.at >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY m.at DESC, m.id DESC LIMIT ? OFFSET ?`
+	args = append(args, f.limit(), f.Offset)
+
+	rows, err := s.query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.ChatMessage
+	for rows.Next() {
+		var (
+			m  entities.ChatMessage
+			at int64
+		)
+		if err := rows.Scan(&m.ID, &at, &m.Source, &m.Author, &m.AuthorID, &m.Content, &m.Blocked); err != nil {
+			return nil, err
+		}
+		m.At = time.Unix(at, 0)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) PruneChat(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.exec(ctx, `DELETE FROM chat_messages WHERE at < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ftsQuery turns free text into an FTS5 query that matches every word,
+// quoting each so operators and punctuation in user input are literal.
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
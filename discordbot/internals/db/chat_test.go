@@ -0,0 +1,21 @@
+package db
+
+import "testing"
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello world", `"hello" "world"`},
+		{"  spaced   out ", `"spaced" "out"`},
+		{`say "hi"`, `"say" """hi"""`},
+		{"NOT AND OR", `"NOT" "AND" "OR"`},
+		{"foo* -bar (baz) col:x", `"foo*" "-bar" "(baz)" "col:x"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ftsQuery(tt.in); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,100 +1,349 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"limpan/rotaria-bot/entities"
-	"log"
-
-	_ "modernc.org/sqlite"
+	"time"
 )
 
-type DbClient struct {
-	Conn *sql.DB
+// SQLStore is the Store on a database/sql connection: the bot's SQLite file
+// or a Postgres server. With SQLite, Conn is shared with the durable queues
+// in imq.
+type SQLStore struct {
+	Conn    *sql.DB
+	Dialect Dialect
 }
 
-var db DbClient
-
-func InitializeDatabase(databaseConfigPath string) DbClient {
-	var err error
-	db.Conn, err = sql.Open("sqlite", databaseConfigPath)
+// Open opens the database named by dsn (see ParseDSN) without touching its
+// schema.
+func Open(dsn string) (*SQLStore, error) {
+	dialect, driver, source := ParseDSN(dsn)
+	conn, err := sql.Open(driver, source)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		return nil, err
+	}
+	if dialect == SQLite {
+		// SQLite allows one writer; a single connection avoids SQLITE_BUSY
+		// between the whitelist code and the durable queues.
+		conn.SetMaxOpenConns(1)
+	} else {
+		conn.SetMaxOpenConns(8)
+		if err := conn.Ping(); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
+	return &SQLStore{Conn: conn, Dialect: dialect}, nil
+}
 
-	createTable := `CREATE TABLE IF NOT EXISTS whitelist (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		discord_id TEXT NOT NULL,
-		minecraft_username TEXT NOT NULL
-	);`
-	_, err = db.Conn.Exec(createTable)
+// OpenStore opens the database and applies pending migrations.
+func OpenStore(ctx context.Context, dsn string) (*SQLStore, error) {
+	s, err := Open(dsn)
 	if err != nil {
-		log.Fatalf("Failed to create whitelist table: %v", err)
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if _, err := MigrateUp(ctx, s, 0); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate database: %w", err)
 	}
+	return s, nil
+}
+
+func (s *SQLStore) Close() error { return s.Conn.Close() }
 
-	return db
+func (s *SQLStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.Conn.ExecContext(ctx, s.Dialect.rebind(query), args...)
 }
 
-func DatabaseStatus() {
-	if db.Conn == nil {
-		log.Println("Database connection is not established.")
-		return
-	}
+func (s *SQLStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.Conn.QueryContext(ctx, s.Dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.Conn.QueryRowContext(ctx, s.Dialect.rebind(query), args...)
+}
+
+// txn is a transaction that rebinds placeholders like SQLStore does.
+type txn struct {
+	tx      *sql.Tx
+	dialect Dialect
+}
+
+func (t *txn) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *txn) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *txn) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.tx.QueryRowContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) inTx(ctx context.Context, fn func(*txn) error) error {
+	return inTx(ctx, s.Conn, func(tx *sql.Tx) error {
+		return fn(&txn{tx: tx, dialect: s.Dialect})
+	})
+}
 
+// insertID runs an INSERT ... RETURNING id and returns the new id.
+func (s *SQLStore) insertID(ctx context.Context, query string, args ...any) (int64, error) {
+	var id int64
+	err := s.queryRow(ctx, query+` RETURNING id`, args...).Scan(&id)
+	return id, err
+}
+
+func (s *SQLStore) WhitelistCount(ctx context.Context) (int, error) {
 	var count int
-	err := db.Conn.QueryRow(`SELECT COUNT(*) FROM whitelist`).Scan(&count)
-	if err != nil {
-		log.Printf("Error checking database status: %v", err)
-		return
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM whitelist WHERE departed_at IS NULL`).Scan(&count)
+	return count, err
+}
+
+// AddWhitelistEntry checks for conflicts and inserts in one transaction; the
+// unique indexes back it up.
+func (s *SQLStore) AddWhitelistEntry(ctx context.Context, whitelistEntry entities.WhiteListEntry, maxAccounts int) error {
+	err := s.inTx(ctx, func(tx *txn) error {
+		if err := checkWhitelistConflict(ctx, tx, whitelistEntry, maxAccounts); err != nil {
+			return err
+		}
+		_, err := tx.exec(ctx, `INSERT INTO whitelist (discord_id, minecraft_username, minecraft_uuid, name_checked_at) VALUES (?, ?, ?, ?)`,
+			whitelistEntry.DiscordID, whitelistEntry.MinecraftUsername, nullString(whitelistEntry.MinecraftUUID), time.Now().Unix())
+		return err
+	})
+	if err != nil && isUniqueViolation(err) {
+		return fmt.Errorf("%w: %v", ErrUsernameClaimed, err)
 	}
+	return err
+}
 
-	log.Printf("Database status: %d entries in whitelist", count)
+func checkWhitelistConflict(ctx context.Context, tx *txn, entry entities.WhiteListEntry, maxAccounts int) error {
+	if err := lockMember(ctx, tx, entry.DiscordID); err != nil {
+		return fmt.Errorf("error locking whitelist member: %v", err)
+	}
+	if maxAccounts > 0 {
+		accounts, err := queryWhitelist(ctx, tx, `SELECT `+whitelistColumns+` FROM whitelist
+			WHERE discord_id = ? AND departed_at IS NULL ORDER BY id`, entry.DiscordID)
+		if err != nil {
+			return fmt.Errorf("error checking existing whitelist entry: %v", err)
+		}
+		if len(accounts) >= maxAccounts {
+			return &ConflictError{Err: ErrAccountLimit, Existing: accounts[0]}
+		}
+	}
+	existing, err := scanWhitelistEntry(tx.queryRow(ctx, `SELECT `+whitelistColumns+` FROM whitelist
+		WHERE (minecraft_uuid IS NOT NULL AND minecraft_uuid = ?) OR `+tx.dialect.nocaseEq("minecraft_username")+`
+		ORDER BY (minecraft_uuid = ?) IS TRUE DESC LIMIT 1`,
+		entry.MinecraftUUID, entry.MinecraftUsername, entry.MinecraftUUID))
+	if err != nil {
+		return fmt.Errorf("error checking existing whitelist entry: %v", err)
+	}
+	if existing != nil {
+		return &ConflictError{Err: ErrUsernameClaimed, Existing: *existing}
+	}
+	return nil
 }
 
-func Close() {
-	if db.Conn != nil {
-		db.Conn.Close()
+// lockMember holds off other whitelist writes for discordID until tx ends,
+// so the account count can't change between the check and the insert.
+// SQLite runs one writer at a time already. On Postgres a transaction-scoped
+// advisory lock is used rather than SELECT ... FOR UPDATE: a member's first
+// accounts have no rows to lock, and under READ COMMITTED a locked read
+// still misses rows another transaction has just inserted.
+func lockMember(ctx context.Context, tx *txn, discordID string) error {
+	if tx.dialect != Postgres {
+		return nil
 	}
+	_, err := tx.exec(ctx, `SELECT pg_advisory_xact_lock(hashtext(?))`, discordID)
+	return err
+}
+
+func (s *SQLStore) RemoveWhitelistEntry(ctx context.Context, id int) error {
+	_, err := s.exec(ctx, `DELETE FROM whitelist WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLStore) GetWhitelistEntries(ctx context.Context, discordId string) ([]entities.WhiteListEntry, error) {
+	return queryWhitelist(ctx, s, `SELECT `+whitelistColumns+` FROM whitelist
+		WHERE discord_id = ? AND departed_at IS NULL ORDER BY id`, discordId)
 }
 
-func AddWhitelistDatabaseEntry(whitelistEntry entities.WhiteListEntry) error {
-	if db.Conn == nil {
-		return sql.ErrConnDone
+func (s *SQLStore) GetWhitelistEntryByUUID(ctx context.Context, uuid string) (*entities.WhiteListEntry, error) {
+	row := s.queryRow(ctx, `SELECT `+whitelistColumns+` FROM whitelist WHERE minecraft_uuid = ?`, uuid)
+	return scanWhitelistEntry(row)
+}
+
+func (s *SQLStore) GetWhitelistEntryByUsername(ctx context.Context, minecraftUsername string) (*entities.WhiteListEntry, error) {
+	row := s.queryRow(ctx, `SELECT `+whitelistColumns+` FROM whitelist WHERE `+s.Dialect.nocaseEq("minecraft_username"), minecraftUsername)
+	return scanWhitelistEntry(row)
+}
+
+func (s *SQLStore) ListWhitelistEntries(ctx context.Context) ([]entities.WhiteListEntry, error) {
+	return queryWhitelist(ctx, s, `SELECT `+whitelistColumns+` FROM whitelist WHERE departed_at IS NULL ORDER BY id`)
+}
+
+func (s *SQLStore) ListStaleWhitelistEntries(ctx context.Context, before time.Time, limit int) ([]entities.WhiteListEntry, error) {
+	return queryWhitelist(ctx, s, `SELECT `+whitelistColumns+` FROM whitelist
+		WHERE name_checked_at IS NULL OR name_checked_at < ?
+		ORDER BY COALESCE(name_checked_at, 0), id LIMIT ?`, before.Unix(), limit)
+}
+
+func (s *SQLStore) DepartWhitelistEntries(ctx context.Context, discordID string, at time.Time) ([]entities.WhiteListEntry, error) {
+	var departed []entities.WhiteListEntry
+	err := s.inTx(ctx, func(tx *txn) error {
+		var err error
+		departed, err = queryWhitelist(ctx, tx, `SELECT `+whitelistColumns+` FROM whitelist
+			WHERE discord_id = ? AND departed_at IS NULL ORDER BY id`, discordID)
+		if err != nil || len(departed) == 0 {
+			return err
+		}
+		_, err = tx.exec(ctx, `UPDATE whitelist SET departed_at = ? WHERE discord_id = ? AND departed_at IS NULL`, at.Unix(), discordID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range departed {
+		departed[i].DepartedAt = time.Unix(at.Unix(), 0)
 	}
+	return departed, nil
+}
 
-	alreadyExists, err := GetWhitelistEntry(whitelistEntry.DiscordID) // Ensure the entry doesn't already exist
+func (s *SQLStore) RestoreWhitelistEntries(ctx context.Context, discordID string) ([]entities.WhiteListEntry, error) {
+	var restored []entities.WhiteListEntry
+	err := s.inTx(ctx, func(tx *txn) error {
+		var err error
+		restored, err = queryWhitelist(ctx, tx, `SELECT `+whitelistColumns+` FROM whitelist
+			WHERE discord_id = ? AND departed_at IS NOT NULL ORDER BY id`, discordID)
+		if err != nil || len(restored) == 0 {
+			return err
+		}
+		_, err = tx.exec(ctx, `UPDATE whitelist SET departed_at = NULL WHERE discord_id = ? AND departed_at IS NOT NULL`, discordID)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("error checking existing whitelist entry: %v", err)
+		return nil, err
 	}
-	if alreadyExists != nil {
-		return fmt.Errorf("whitelist entry already exists for Discord ID %s", whitelistEntry.DiscordID)
+	for i := range restored {
+		restored[i].DepartedAt = time.Time{}
 	}
+	return restored, nil
+}
 
-	_, err = db.Conn.Exec(`INSERT INTO whitelist (discord_id, minecraft_username) VALUES (?, ?)`, whitelistEntry.DiscordID, whitelistEntry.MinecraftUsername)
-	return err
+func (s *SQLStore) ListDepartedWhitelistEntries(ctx context.Context) ([]entities.WhiteListEntry, error) {
+	return queryWhitelist(ctx, s, `SELECT `+whitelistColumns+` FROM whitelist
+		WHERE departed_at IS NOT NULL ORDER BY departed_at, id`)
 }
 
-func RemoveWhitelistDatabaseEntry(id int) error {
-	if db.Conn == nil {
-		return sql.ErrConnDone
+func (s *SQLStore) PurgeDepartedWhitelistEntries(ctx context.Context, discordID string, before time.Time) ([]entities.WhiteListEntry, error) {
+	var purged []entities.WhiteListEntry
+	err := s.inTx(ctx, func(tx *txn) error {
+		var err error
+		purged, err = queryWhitelist(ctx, tx, `SELECT `+whitelistColumns+` FROM whitelist
+			WHERE discord_id = ? AND departed_at <= ? ORDER BY id`, discordID, before.Unix())
+		if err != nil || len(purged) == 0 {
+			return err
+		}
+		_, err = tx.exec(ctx, `DELETE FROM whitelist WHERE discord_id = ? AND departed_at <= ?`, discordID, before.Unix())
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, err := db.Conn.Exec(`DELETE FROM whitelist WHERE id = ?`, id)
-	return err
+	return purged, nil
+}
+
+func queryWhitelist(ctx context.Context, q querier, query string, args ...any) ([]entities.WhiteListEntry, error) {
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.WhiteListEntry
+	for rows.Next() {
+		entry, err := scanWhitelistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *entry)
+	}
+	return out, rows.Err()
 }
 
-func GetWhitelistEntry(discordId string) (*entities.WhiteListEntry, error) {
-	if db.Conn == nil {
-		return nil, sql.ErrConnDone
+func (s *SQLStore) UpdateWhitelistIdentity(ctx context.Context, id int, minecraftUsername, minecraftUUID string) error {
+	_, err := s.exec(ctx, `UPDATE whitelist SET minecraft_username = ?, minecraft_uuid = COALESCE(?, minecraft_uuid), name_checked_at = ? WHERE id = ?`,
+		minecraftUsername, nullString(minecraftUUID), time.Now().Unix(), id)
+	if err != nil && isUniqueViolation(err) {
+		return fmt.Errorf("%w: %v", ErrUsernameClaimed, err)
 	}
-	row := db.Conn.QueryRow(`SELECT id, discord_id, minecraft_username FROM whitelist WHERE discord_id = ?`, discordId)
+	return err
+}
+
+const whitelistColumns = `id, discord_id, minecraft_username, minecraft_uuid, departed_at`
 
-	var entry entities.WhiteListEntry
-	err := row.Scan(&entry.ID, &entry.DiscordID, &entry.MinecraftUsername)
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWhitelistEntry(row scanner) (*entities.WhiteListEntry, error) {
+	var (
+		entry    entities.WhiteListEntry
+		uuid     sql.NullString
+		departed sql.NullInt64
+	)
+	err := row.Scan(&entry.ID, &entry.DiscordID, &entry.MinecraftUsername, &uuid, &departed)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No entry found
 		}
 		return nil, err // Other error
 	}
+	entry.MinecraftUUID = uuid.String
+	if departed.Valid {
+		entry.DepartedAt = time.Unix(departed.Int64, 0)
+	}
 	return &entry, nil
 }
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *SQLStore) ListWhitelistConflicts(ctx context.Context) ([]entities.WhitelistConflict, error) {
+	rows, err := s.query(ctx, `SELECT id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at
+		FROM whitelist_conflicts ORDER BY moved_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.WhitelistConflict
+	for rows.Next() {
+		var (
+			c     entities.WhitelistConflict
+			uuid  sql.NullString
+			moved int64
+		)
+		if err := rows.Scan(&c.ID, &c.DiscordID, &c.MinecraftUsername, &uuid, &c.Reason, &moved); err != nil {
+			return nil, err
+		}
+		c.MinecraftUUID = uuid.String
+		c.MovedAt = time.Unix(moved, 0)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) DismissWhitelistConflict(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, `DELETE FROM whitelist_conflicts WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflictNotFound
+	}
+	return nil
+}
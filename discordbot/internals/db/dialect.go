@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect is the SQL flavour behind a SQLStore. Queries are written for
+// SQLite with ? placeholders; the few spots that differ ask the dialect.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// ParseDSN picks the dialect from the DSN's scheme: postgres:// and
+// postgresql:// URLs go to Postgres, anything else is a SQLite file (an
+// optional sqlite:// prefix is stripped). It returns the driver name and
+// the data source to hand to sql.Open.
+func ParseDSN(dsn string) (d Dialect, driver, source string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return Postgres, "pgx", dsn
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return SQLite, "sqlite", strings.TrimPrefix(dsn, "sqlite://")
+	}
+	return SQLite, "sqlite", dsn
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres. Question
+// marks inside string literals are left alone.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var (
+		b        strings.Builder
+		n        int
+		inString bool
+	)
+	b.Grow(len(query) + 8)
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// nocase wraps a column or placeholder for case-insensitive comparison,
+// grouping and ordering. The Postgres migrations index lower(...) to match.
+func (d Dialect) nocase(expr string) string {
+	if d == Postgres {
+		return "lower(" + expr + ")"
+	}
+	return expr + " COLLATE NOCASE"
+}
+
+// nocaseEq is a case-insensitive col = ?.
+func (d Dialect) nocaseEq(col string) string {
+	return d.nocase(col) + " = " + d.nocase("?")
+}
+
+// nocaseLike is a case-insensitive col LIKE ? with \ as the escape
+// character, for patterns built with escapeLike.
+func (d Dialect) nocaseLike(col string) string {
+	if d == Postgres {
+		return col + ` ILIKE ? ESCAPE '\'`
+	}
+	return col + ` LIKE ? ESCAPE '\' COLLATE NOCASE`
+}
+
+// greatest is the larger of two values; SQLite's scalar MAX.
+func (d Dialect) greatest(a, b string) string {
+	if d == Postgres {
+		return "GREATEST(" + a + ", " + b + ")"
+	}
+	return "MAX(" + a + ", " + b + ")"
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
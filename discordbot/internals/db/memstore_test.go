@@ -0,0 +1,807 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemStore is an in-memory Store for tests. It enforces the same uniqueness
+// rules as the SQL schema and returns the same errors; store_test.go runs
+// the same cases against it and SQLStore to keep it that way.
+type MemStore struct {
+	mu        sync.Mutex
+	nextID    int
+	whitelist map[int]*memWhitelistRow
+
+	nextAppID    int64
+	applications map[int64]*entities.Application
+
+	nextReportID int64
+	reports      map[int64]*entities.Report
+
+	audit []entities.AuditEntry
+
+	sessions []*memSession
+
+	chat       []entities.ChatMessage
+	nextChatID int64
+
+	statusSamples []entities.StatusSample
+	rollups       map[memRollupKey]entities.StatusRollup
+}
+
+type memRollupKey struct {
+	resolution time.Duration
+	bucket     int64
+}
+
+type memSession struct {
+	username, uuid         string
+	joined, lastSeen, left time.Time // left is zero while open
+	reason                 string
+}
+
+type memWhitelistRow struct {
+	entry     entities.WhiteListEntry
+	checkedAt time.Time
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		whitelist:    make(map[int]*memWhitelistRow),
+		applications: make(map[int64]*entities.Application),
+		reports:      make(map[int64]*entities.Report),
+		rollups:      make(map[memRollupKey]entities.StatusRollup),
+	}
+}
+
+func (m *MemStore) Close() error { return nil }
+
+func (m *MemStore) WhitelistCount(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, r := range m.whitelist {
+		if r.entry.DepartedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemStore) AddWhitelistEntry(_ context.Context, entry entities.WhiteListEntry, maxAccounts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if maxAccounts > 0 {
+		if accounts := m.accountsLocked(entry.DiscordID); len(accounts) >= maxAccounts {
+			return &ConflictError{Err: ErrAccountLimit, Existing: accounts[0]}
+		}
+	}
+	claimed := func(e entities.WhiteListEntry) bool {
+		return (entry.MinecraftUUID != "" && e.MinecraftUUID == entry.MinecraftUUID) ||
+			strings.EqualFold(e.MinecraftUsername, entry.MinecraftUsername)
+	}
+	if existing := m.findLocked(claimed); existing != nil {
+		return &ConflictError{Err: ErrUsernameClaimed, Existing: *existing}
+	}
+	m.nextID++
+	entry.ID = m.nextID
+	entry.DepartedAt = time.Time{}
+	m.whitelist[entry.ID] = &memWhitelistRow{entry: entry, checkedAt: time.Now()}
+	return nil
+}
+
+func (m *MemStore) RemoveWhitelistEntry(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.whitelist, id)
+	return nil
+}
+
+func (m *MemStore) GetWhitelistEntries(_ context.Context, discordID string) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.accountsLocked(discordID), nil
+}
+
+func (m *MemStore) accountsLocked(discordID string) []entities.WhiteListEntry {
+	var out []entities.WhiteListEntry
+	for _, r := range m.whitelist {
+		if r.entry.DiscordID == discordID && r.entry.DepartedAt.IsZero() {
+			out = append(out, r.entry)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (m *MemStore) GetWhitelistEntryByUUID(_ context.Context, uuid string) (*entities.WhiteListEntry, error) {
+	return m.find(func(e entities.WhiteListEntry) bool { return e.MinecraftUUID != "" && e.MinecraftUUID == uuid }), nil
+}
+
+func (m *MemStore) GetWhitelistEntryByUsername(_ context.Context, minecraftUsername string) (*entities.WhiteListEntry, error) {
+	return m.find(func(e entities.WhiteListEntry) bool { return strings.EqualFold(e.MinecraftUsername, minecraftUsername) }), nil
+}
+
+func (m *MemStore) ListWhitelistEntries(_ context.Context) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	out := make([]entities.WhiteListEntry, 0, len(m.whitelist))
+	for _, r := range m.whitelist {
+		if r.entry.DepartedAt.IsZero() {
+			out = append(out, r.entry)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *MemStore) ListStaleWhitelistEntries(_ context.Context, before time.Time, limit int) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	var rows []*memWhitelistRow
+	for _, r := range m.whitelist {
+		if r.checkedAt.Before(before) {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].checkedAt.Equal(rows[j].checkedAt) {
+			return rows[i].checkedAt.Before(rows[j].checkedAt)
+		}
+		return rows[i].entry.ID < rows[j].entry.ID
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	out := make([]entities.WhiteListEntry, len(rows))
+	for i, r := range rows {
+		out[i] = r.entry
+	}
+	m.mu.Unlock()
+	return out, nil
+}
+
+func (m *MemStore) UpdateWhitelistIdentity(_ context.Context, id int, minecraftUsername, minecraftUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.whitelist[id]
+	if !ok {
+		return nil
+	}
+	if existing := m.findLocked(func(e entities.WhiteListEntry) bool {
+		return e.ID != id && strings.EqualFold(e.MinecraftUsername, minecraftUsername)
+	}); existing != nil {
+		return &ConflictError{Err: ErrUsernameClaimed, Existing: *existing}
+	}
+	r.entry.MinecraftUsername = minecraftUsername
+	if minecraftUUID != "" {
+		r.entry.MinecraftUUID = minecraftUUID
+	}
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func (m *MemStore) DepartWhitelistEntries(_ context.Context, discordID string, at time.Time) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at = time.Unix(at.Unix(), 0) // what SQLite would store
+	var out []entities.WhiteListEntry
+	for _, r := range m.whitelist {
+		if r.entry.DiscordID == discordID && r.entry.DepartedAt.IsZero() {
+			r.entry.DepartedAt = at
+			out = append(out, r.entry)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *MemStore) RestoreWhitelistEntries(_ context.Context, discordID string) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []entities.WhiteListEntry
+	for _, r := range m.whitelist {
+		if r.entry.DiscordID == discordID && !r.entry.DepartedAt.IsZero() {
+			r.entry.DepartedAt = time.Time{}
+			out = append(out, r.entry)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *MemStore) ListDepartedWhitelistEntries(_ context.Context) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	var out []entities.WhiteListEntry
+	for _, r := range m.whitelist {
+		if !r.entry.DepartedAt.IsZero() {
+			out = append(out, r.entry)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].DepartedAt.Equal(out[j].DepartedAt) {
+			return out[i].DepartedAt.Before(out[j].DepartedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+func (m *MemStore) PurgeDepartedWhitelistEntries(_ context.Context, discordID string, before time.Time) ([]entities.WhiteListEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []entities.WhiteListEntry
+	for id, r := range m.whitelist {
+		if r.entry.DiscordID == discordID && !r.entry.DepartedAt.IsZero() && r.entry.DepartedAt.Unix() <= before.Unix() {
+			out = append(out, r.entry)
+			delete(m.whitelist, id)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// A MemStore is never migrated, so nothing is ever set aside.
+func (m *MemStore) ListWhitelistConflicts(_ context.Context) ([]entities.WhitelistConflict, error) {
+	return nil, nil
+}
+
+func (m *MemStore) DismissWhitelistConflict(_ context.Context, _ int) error {
+	return ErrConflictNotFound
+}
+
+func (m *MemStore) find(match func(entities.WhiteListEntry) bool) *entities.WhiteListEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findLocked(match)
+}
+
+// findLocked returns a copy of the lowest-ID entry that matches. Caller holds m.mu.
+func (m *MemStore) findLocked(match func(entities.WhiteListEntry) bool) *entities.WhiteListEntry {
+	var found *entities.WhiteListEntry
+	for _, r := range m.whitelist {
+		if match(r.entry) && (found == nil || r.entry.ID < found.ID) {
+			e := r.entry
+			found = &e
+		}
+	}
+	return found
+}
+
+func (m *MemStore) CreateApplication(_ context.Context, app *entities.Application) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range m.applications {
+		if a.DiscordID == app.DiscordID && a.Status == entities.ApplicationPending {
+			return ErrApplicationPending
+		}
+	}
+	m.nextAppID++
+	app.ID = m.nextAppID
+	app.Status = entities.ApplicationPending
+	app.CreatedAt = time.Now()
+	cp := *app
+	m.applications[app.ID] = &cp
+	return nil
+}
+
+func (m *MemStore) GetApplication(_ context.Context, id int64) (*entities.Application, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.applications[id]
+	if !ok {
+		return nil, nil
+	}
+	cp := *a
+	return &cp, nil
+}
+
+func (m *MemStore) SetApplicationMessage(_ context.Context, id int64, channelID, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.applications[id]
+	if !ok {
+		return ErrApplicationNotFound
+	}
+	a.ChannelID, a.MessageID = channelID, messageID
+	return nil
+}
+
+func (m *MemStore) DecideApplication(_ context.Context, id int64, status entities.ApplicationStatus, reviewerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.applications[id]
+	if !ok {
+		return ErrApplicationNotFound
+	}
+	if a.Status != entities.ApplicationPending {
+		return fmt.Errorf("%w (%s)", ErrApplicationClosed, a.Status)
+	}
+	a.Status, a.ReviewerID, a.DecidedAt = status, reviewerID, time.Now()
+	return nil
+}
+
+func (m *MemStore) ReopenApplication(_ context.Context, id int64, status entities.ApplicationStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.applications[id]
+	if !ok || a.Status != status {
+		return ErrApplicationClosed
+	}
+	for _, o := range m.applications {
+		if o.DiscordID == a.DiscordID && o.Status == entities.ApplicationPending {
+			return ErrApplicationPending
+		}
+	}
+	a.Status, a.ReviewerID, a.DecidedAt = entities.ApplicationPending, "", time.Time{}
+	return nil
+}
+
+func (m *MemStore) WithdrawApplications(_ context.Context, discordID string) ([]entities.Application, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []entities.Application
+	for _, a := range m.applications {
+		if a.DiscordID == discordID && a.Status == entities.ApplicationPending {
+			a.Status, a.DecidedAt = entities.ApplicationWithdrawn, time.Now()
+			out = append(out, *a)
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) SearchApplications(_ context.Context, f ApplicationFilter) ([]entities.Application, error) {
+	m.mu.Lock()
+	var out []entities.Application
+	for _, a := range m.applications {
+		if (f.DiscordID == "" || a.DiscordID == f.DiscordID) &&
+			(f.MinecraftUsername == "" || strings.Contains(strings.ToLower(a.MinecraftUsername), strings.ToLower(f.MinecraftUsername))) &&
+			(f.Status == "" || a.Status == f.Status) {
+			out = append(out, *a)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	if len(out) > f.limit() {
+		out = out[:f.limit()]
+	}
+	return out, nil
+}
+
+func (m *MemStore) CreateReport(_ context.Context, r *entities.Report) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextReportID++
+	r.ID = m.nextReportID
+	r.Status = entities.ReportOpen
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+	cp := *r
+	m.reports[r.ID] = &cp
+	return nil
+}
+
+func (m *MemStore) GetReport(_ context.Context, id int64) (*entities.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.reports[id]
+	if !ok {
+		return nil, nil
+	}
+	cp := *r
+	return &cp, nil
+}
+
+func (m *MemStore) SetReportMessage(_ context.Context, id int64, channelID, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.reports[id]
+	if !ok {
+		return ErrReportNotFound
+	}
+	r.ChannelID, r.MessageID = channelID, messageID
+	return nil
+}
+
+func (m *MemStore) AssignReport(_ context.Context, id int64, assigneeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.openReportLocked(id)
+	if err != nil {
+		return err
+	}
+	r.AssigneeID, r.UpdatedAt = assigneeID, time.Now()
+	return nil
+}
+
+func (m *MemStore) CloseReport(_ context.Context, id int64, status entities.ReportStatus, moderatorID, resolution string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.openReportLocked(id)
+	if err != nil {
+		return err
+	}
+	if r.AssigneeID == "" {
+		r.AssigneeID = moderatorID
+	}
+	now := time.Now()
+	r.Status, r.Resolution, r.UpdatedAt, r.ClosedAt = status, resolution, now, now
+	return nil
+}
+
+func (m *MemStore) openReportLocked(id int64) (*entities.Report, error) {
+	r, ok := m.reports[id]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+	if r.Status != entities.ReportOpen {
+		return nil, fmt.Errorf("%w (%s)", ErrReportClosed, r.Status)
+	}
+	return r, nil
+}
+
+func (m *MemStore) SearchReports(_ context.Context, f ReportFilter) ([]entities.Report, error) {
+	m.mu.Lock()
+	var out []entities.Report
+	for _, r := range m.reports {
+		if (f.ReporterID == "" || r.ReporterID == f.ReporterID) &&
+			(f.AssigneeID == "" || r.AssigneeID == f.AssigneeID) &&
+			(f.Subject == "" || strings.Contains(strings.ToLower(r.Subject), strings.ToLower(f.Subject))) &&
+			(f.Type == "" || r.Type == f.Type) &&
+			(f.Status == "" || r.Status == f.Status) {
+			out = append(out, *r)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	if len(out) > f.limit() {
+		out = out[:f.limit()]
+	}
+	return out, nil
+}
+
+func (m *MemStore) AppendAudit(_ context.Context, e *entities.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	e.At = e.At.Truncate(time.Second) // stored as Unix seconds, like SQLite
+	e.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, *e)
+	return nil
+}
+
+func (m *MemStore) SearchAudit(_ context.Context, f AuditFilter) ([]entities.AuditEntry, error) {
+	m.mu.Lock()
+	var out []entities.AuditEntry
+	for _, e := range m.audit {
+		if (f.UserID == "" || e.ActorID == f.UserID || e.TargetUser == f.UserID) &&
+			(f.Action == "" || e.Action == f.Action) &&
+			(f.Since.IsZero() || e.At.Unix() >= f.Since.Unix()) &&
+			(f.Until.IsZero() || e.At.Unix() < f.Until.Unix()) {
+			out = append(out, e)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].At.Equal(out[j].At) {
+			return out[i].At.After(out[j].At)
+		}
+		return out[i].ID > out[j].ID
+	})
+	if len(out) > f.limit() {
+		out = out[:f.limit()]
+	}
+	return out, nil
+}
+
+// openSessionLocked returns the player's open session. Caller holds m.mu.
+func (m *MemStore) openSessionLocked(username string) *memSession {
+	for _, ss := range m.sessions {
+		if ss.left.IsZero() && strings.EqualFold(ss.username, username) {
+			return ss
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) StartSession(_ context.Context, minecraftUsername, minecraftUUID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.openSessionLocked(minecraftUsername) != nil {
+		return nil
+	}
+	at = at.Truncate(time.Second)
+	m.sessions = append(m.sessions, &memSession{username: minecraftUsername, uuid: minecraftUUID, joined: at, lastSeen: at})
+	return nil
+}
+
+func (m *MemStore) EndSession(_ context.Context, minecraftUsername string, at time.Time, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ss := m.openSessionLocked(minecraftUsername); ss != nil {
+		ss.left, ss.reason = at.Truncate(time.Second), reason
+		if ss.left.Before(ss.joined) {
+			ss.left = ss.joined
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) TouchOpenSessions(_ context.Context, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ss := range m.sessions {
+		if ss.left.IsZero() {
+			ss.lastSeen = at.Truncate(time.Second)
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) EndOpenSessions(_ context.Context, reason string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, ss := range m.sessions {
+		if ss.left.IsZero() {
+			ss.left, ss.reason = ss.lastSeen, reason
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemStore) playtimes(since, now time.Time) map[string]*entities.Playtime {
+	since, now = since.Truncate(time.Second), now.Truncate(time.Second)
+	out := make(map[string]*entities.Playtime)
+	for _, ss := range m.sessions {
+		end := ss.left
+		if end.IsZero() {
+			end = now
+		}
+		if !end.After(since) {
+			continue
+		}
+		key := strings.ToLower(ss.username)
+		p := out[key]
+		if p == nil {
+			p = &entities.Playtime{MinecraftUsername: ss.username, FirstSeen: ss.joined}
+			out[key] = p
+		}
+		if ss.username > p.MinecraftUsername {
+			p.MinecraftUsername = ss.username
+		}
+		start := ss.joined
+		if start.Before(since) {
+			start = since
+		}
+		p.Total += end.Sub(start)
+		p.Sessions++
+		if ss.joined.Before(p.FirstSeen) {
+			p.FirstSeen = ss.joined
+		}
+		if end.After(p.LastSeen) {
+			p.LastSeen = end
+		}
+		p.Online = p.Online || ss.left.IsZero()
+	}
+	return out
+}
+
+func (m *MemStore) GetPlaytime(_ context.Context, minecraftUsername string, since, now time.Time) (*entities.Playtime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.playtimes(since, now)[strings.ToLower(minecraftUsername)], nil
+}
+
+func (m *MemStore) TopPlaytime(_ context.Context, since, now time.Time, limit int) ([]entities.Playtime, error) {
+	m.mu.Lock()
+	var out []entities.Playtime
+	for _, p := range m.playtimes(since, now) {
+		out = append(out, *p)
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].MinecraftUsername < out[j].MinecraftUsername
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *MemStore) ArchiveChat(_ context.Context, msg *entities.ChatMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg.At.IsZero() {
+		msg.At = time.Now()
+	}
+	msg.At = msg.At.Truncate(time.Second)
+	m.nextChatID++
+	msg.ID = m.nextChatID
+	m.chat = append(m.chat, *msg)
+	return nil
+}
+
+// SearchChat matches whole words case-insensitively, which is close to the
+// SQLite full-text search for plain words.
+func (m *MemStore) SearchChat(_ context.Context, f ChatFilter) ([]entities.ChatMessage, error) {
+	words := chatWords(f.Query)
+	m.mu.Lock()
+	var out []entities.ChatMessage
+	for _, msg := range m.chat {
+		if (f.Author == "" || strings.EqualFold(msg.Author, f.Author) || msg.AuthorID == f.Author) &&
+			(f.Since.IsZero() || msg.At.Unix() >= f.Since.Unix()) &&
+			containsWords(msg.Content, words) {
+			out = append(out, msg)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].At.Equal(out[j].At) {
+			return out[i].At.After(out[j].At)
+		}
+		return out[i].ID > out[j].ID
+	})
+	if f.Offset >= len(out) {
+		return nil, nil
+	}
+	out = out[f.Offset:]
+	if len(out) > f.limit() {
+		out = out[:f.limit()]
+	}
+	return out, nil
+}
+
+func chatWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func containsWords(content string, words []string) bool {
+	have := make(map[string]bool)
+	for _, w := range chatWords(content) {
+		have[w] = true
+	}
+	for _, w := range words {
+		if !have[w] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemStore) PruneChat(_ context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.chat[:0]
+	for _, msg := range m.chat {
+		if msg.At.Unix() >= before.Unix() {
+			kept = append(kept, msg)
+		}
+	}
+	n := len(m.chat) - len(kept)
+	m.chat = kept
+	return n, nil
+}
+
+var _ Store = (*MemStore)(nil)
+
+func (m *MemStore) RecordStatus(_ context.Context, sample entities.StatusSample) error {
+	if sample.At.IsZero() {
+		sample.At = time.Now()
+	}
+	sample.At = time.Unix(sample.At.Unix(), 0)
+	m.mu.Lock()
+	m.statusSamples = append(m.statusSamples, sample)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemStore) RollupStatus(_ context.Context, since time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hourFrom := floorUnix(since, hourSeconds)
+	hours := make(map[int64][]entities.StatusSample)
+	for _, sm := range m.statusSamples {
+		if sm.At.Unix() >= hourFrom {
+			b := floorUnix(sm.At, hourSeconds)
+			hours[b] = append(hours[b], sm)
+		}
+	}
+	for b, samples := range hours {
+		r := entities.StatusRollup{Bucket: time.Unix(b, 0), Resolution: entities.StatusHourly, Samples: len(samples),
+			TPSMin: samples[0].TPS, TPSMax: samples[0].TPS}
+		for _, sm := range samples {
+			r.TPSMin = min(r.TPSMin, sm.TPS)
+			r.TPSMax = max(r.TPSMax, sm.TPS)
+			r.TPSAvg += sm.TPS
+			r.PlayersAvg += float64(sm.Players)
+			r.PlayersMax = max(r.PlayersMax, sm.Players)
+		}
+		r.TPSAvg /= float64(len(samples))
+		r.PlayersAvg /= float64(len(samples))
+		m.putRollup(r)
+	}
+
+	dayFrom := floorUnix(since, daySeconds)
+	days := make(map[int64][]entities.StatusRollup)
+	for k, r := range m.rollups {
+		if k.resolution == entities.StatusHourly && k.bucket >= dayFrom {
+			b := floorUnix(r.Bucket, daySeconds)
+			days[b] = append(days[b], r)
+		}
+	}
+	for b, hs := range days {
+		r := entities.StatusRollup{Bucket: time.Unix(b, 0), Resolution: entities.StatusDaily,
+			TPSMin: hs[0].TPSMin, TPSMax: hs[0].TPSMax}
+		for _, h := range hs {
+			r.Samples += h.Samples
+			r.TPSMin = min(r.TPSMin, h.TPSMin)
+			r.TPSMax = max(r.TPSMax, h.TPSMax)
+			r.TPSAvg += h.TPSAvg * float64(h.Samples)
+			r.PlayersAvg += h.PlayersAvg * float64(h.Samples)
+			r.PlayersMax = max(r.PlayersMax, h.PlayersMax)
+		}
+		r.TPSAvg /= float64(r.Samples)
+		r.PlayersAvg /= float64(r.Samples)
+		m.putRollup(r)
+	}
+	return nil
+}
+
+// putRollup stores r unless its bucket already has more samples, like the
+// SQL upsert.
+func (m *MemStore) putRollup(r entities.StatusRollup) {
+	k := memRollupKey{r.Resolution, r.Bucket.Unix()}
+	if old, ok := m.rollups[k]; ok && old.Samples > r.Samples {
+		return
+	}
+	m.rollups[k] = r
+}
+
+func (m *MemStore) StatusRollups(_ context.Context, resolution time.Duration, since time.Time) ([]entities.StatusRollup, error) {
+	m.mu.Lock()
+	var out []entities.StatusRollup
+	for k, r := range m.rollups {
+		if k.resolution == resolution && k.bucket >= unixOrZero(since) {
+			out = append(out, r)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Bucket.Before(out[j].Bucket) })
+	return out, nil
+}
+
+func (m *MemStore) PruneStatus(_ context.Context, samplesBefore, hourlyBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.statusSamples[:0]
+	for _, sm := range m.statusSamples {
+		if sm.At.Unix() >= samplesBefore.Unix() {
+			kept = append(kept, sm)
+		}
+	}
+	n := len(m.statusSamples) - len(kept)
+	m.statusSamples = kept
+	for k := range m.rollups {
+		if k.resolution == entities.StatusHourly && k.bucket < hourlyBefore.Unix() {
+			delete(m.rollups, k)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql, with the same versions in every dialect. They are
+// applied in version order, each in its own transaction together with its
+// schema_migrations row, so a failed migration leaves nothing behind.
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and whether it has been applied.
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time // zero if pending
+}
+
+var ErrNoDownMigration = errors.New("db: migration has no down script")
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at BIGINT NOT NULL
+);`
+
+// Migrations returns the embedded migrations for d, oldest first.
+func Migrations(d Dialect) ([]Migration, error) {
+	root := "migrations/" + string(d)
+	entries, err := fs.ReadDir(migrationFiles, root)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		file := e.Name()
+		base, dir, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (dir != "up" && dir != "down") {
+			return nil, fmt.Errorf("db: bad migration file name %q", file)
+		}
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("db: bad migration version in %q", file)
+		}
+		body, err := migrationFiles.ReadFile(root + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if dir == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("db: migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func MigrationStatus(ctx context.Context, s *SQLStore) ([]MigrationState, error) {
+	all, err := Migrations(s.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationState, len(all))
+	for i, m := range all {
+		out[i] = MigrationState{Migration: m, AppliedAt: applied[m.Version]}
+	}
+	return out, nil
+}
+
+// MigrateUp applies up to steps pending migrations (all of them if steps <= 0)
+// and returns how many it applied.
+func MigrateUp(ctx context.Context, s *SQLStore, steps int) (int, error) {
+	states, err := MigrationStatus(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, st := range states {
+		if !st.AppliedAt.IsZero() {
+			continue
+		}
+		if steps > 0 && n >= steps {
+			break
+		}
+		err := s.inTx(ctx, func(tx *txn) error {
+			if _, err := tx.tx.ExecContext(ctx, st.Up); err != nil {
+				return err
+			}
+			_, err := tx.exec(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				st.Version, st.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %04d_%s: %w", st.Version, st.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", st.Version, st.Name)
+		n++
+	}
+	return n, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations (at least one).
+func MigrateDown(ctx context.Context, s *SQLStore, steps int) (int, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	states, err := MigrationStatus(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := len(states) - 1; i >= 0 && n < steps; i-- {
+		st := states[i]
+		if st.AppliedAt.IsZero() {
+			continue
+		}
+		if st.Down == "" {
+			return n, fmt.Errorf("migration %04d_%s: %w", st.Version, st.Name, ErrNoDownMigration)
+		}
+		err := s.inTx(ctx, func(tx *txn) error {
+			if _, err := tx.tx.ExecContext(ctx, st.Down); err != nil {
+				return err
+			}
+			_, err := tx.exec(ctx, `DELETE FROM schema_migrations WHERE version = ?`, st.Version)
+			return err
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %04d_%s: %w", st.Version, st.Name, err)
+		}
+		log.Printf("Rolled back migration %04d_%s", st.Version, st.Name)
+		n++
+	}
+	return n, nil
+}
+
+func appliedMigrations(ctx context.Context, s *SQLStore) (map[int]time.Time, error) {
+	if _, err := s.exec(ctx, schemaMigrationsTable); err != nil {
+		return nil, err
+	}
+	rows, err := s.query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			at      int64
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(at, 0)
+	}
+	return applied, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schemaOf describes the tables, columns and indexes in s, one per line.
+func schemaOf(t *testing.T, s *SQLStore) string {
+	t.Helper()
+	var q string
+	switch s.Dialect {
+	case SQLite:
+		q = `SELECT type || ' ' || name || ': ' || COALESCE(sql, '') FROM sqlite_master
+			WHERE name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations' ORDER BY type, name`
+	case Postgres:
+		q = `SELECT 'column ' || table_name || '.' || column_name || ': ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '')
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'
+			UNION ALL
+			SELECT 'index ' || indexname || ': ' || indexdef FROM pg_indexes
+			WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'
+			ORDER BY 1`
+	}
+	rows, err := s.Conn.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	for _, kind := range dialectKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := kind.open(t)
+			all, err := Migrations(s.Dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n, err := MigrateUp(ctx, s, 0); err != nil || n != len(all) {
+				t.Fatalf("first up applied %d of %d: %v", n, len(all), err)
+			}
+			want := schemaOf(t, s)
+			if n, err := MigrateDown(ctx, s, len(all)); err != nil || n != len(all) {
+				t.Fatalf("down rolled back %d of %d: %v", n, len(all), err)
+			}
+			if n, err := MigrateUp(ctx, s, 0); err != nil || n != len(all) {
+				t.Fatalf("second up applied %d of %d: %v", n, len(all), err)
+			}
+			if got := schemaOf(t, s); got != want {
+				t.Fatalf("schema after up, down, up:\n%s\n\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	lite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(m Migration) string { return fmt.Sprintf("%04d_%s", m.Version, m.Name) }
+	if len(lite) != len(pg) {
+		t.Fatalf("%d SQLite migrations, %d Postgres", len(lite), len(pg))
+	}
+	for i := range lite {
+		if name(lite[i]) != name(pg[i]) {
+			t.Errorf("migration %d is %s for SQLite, %s for Postgres", i, name(lite[i]), name(pg[i]))
+		}
+		if lite[i].Down == "" || pg[i].Down == "" {
+			t.Errorf("migration %s has no down script", name(lite[i]))
+		}
+	}
+}
+
+// A database from before the migrations already has a whitelist table; rolling
+// everything back must not take it with it.
+func TestMigrateDownKeepsPreMigrationWhitelist(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rotaria.db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`CREATE TABLE whitelist (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		discord_id TEXT NOT NULL,
+		minecraft_username TEXT NOT NULL
+	);
+	INSERT INTO whitelist (discord_id, minecraft_username) VALUES ('1', 'Steve')`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	all, _ := Migrations(SQLite)
+	if _, err := MigrateDown(ctx, s, len(all)); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := s.Conn.QueryRow(`SELECT minecraft_username FROM whitelist WHERE discord_id = '1'`).Scan(&name); err != nil {
+		t.Fatalf("whitelist after rolling back every migration: %v", err)
+	}
+	if name != "Steve" {
+		t.Fatalf("username = %q, want Steve", name)
+	}
+}
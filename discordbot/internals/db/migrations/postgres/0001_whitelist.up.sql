@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS whitelist (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	discord_id TEXT NOT NULL,
+	minecraft_username TEXT NOT NULL
+);
@@ -0,0 +1,34 @@
+-- Racing approvals could store the same row twice. Before the unique indexes
+-- make that impossible, every copy but the oldest is moved aside for staff
+-- to sort out (/whitelist-admin conflicts) rather than deleted.
+-- Case-insensitive uniqueness is on lower(...), which the store's queries
+-- use in place of SQLite's COLLATE NOCASE.
+CREATE TABLE IF NOT EXISTS whitelist_conflicts (
+	id BIGINT PRIMARY KEY, -- the row's id in whitelist
+	discord_id TEXT NOT NULL,
+	minecraft_username TEXT NOT NULL,
+	minecraft_uuid TEXT,
+	reason TEXT NOT NULL,
+	moved_at BIGINT NOT NULL
+);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Discord ID', EXTRACT(EPOCH FROM now())::BIGINT
+	FROM whitelist WHERE id NOT IN (SELECT MIN(id) FROM whitelist GROUP BY discord_id);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Minecraft username', EXTRACT(EPOCH FROM now())::BIGINT
+	FROM whitelist WHERE id NOT IN (SELECT MIN(id) FROM whitelist GROUP BY lower(minecraft_username));
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Minecraft UUID', EXTRACT(EPOCH FROM now())::BIGINT
+	FROM whitelist WHERE minecraft_uuid IS NOT NULL
+		AND id NOT IN (SELECT MIN(id) FROM whitelist WHERE minecraft_uuid IS NOT NULL GROUP BY minecraft_uuid);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+DROP INDEX IF EXISTS whitelist_minecraft_uuid;
+CREATE UNIQUE INDEX whitelist_discord_id ON whitelist (discord_id);
+CREATE UNIQUE INDEX whitelist_minecraft_username ON whitelist (lower(minecraft_username));
+CREATE UNIQUE INDEX whitelist_minecraft_uuid ON whitelist (minecraft_uuid) WHERE minecraft_uuid IS NOT NULL;
@@ -0,0 +1,19 @@
+CREATE TABLE applications (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	discord_id TEXT NOT NULL,
+	minecraft_username TEXT NOT NULL,
+	minecraft_uuid TEXT,
+	age TEXT NOT NULL DEFAULT '',
+	plan TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'approved', 'rejected', 'withdrawn')),
+	reviewer_id TEXT,
+	created_at BIGINT NOT NULL,
+	decided_at BIGINT,
+	channel_id TEXT,
+	message_id TEXT
+);
+CREATE INDEX applications_discord_id ON applications (discord_id, created_at);
+CREATE INDEX applications_status ON applications (status, created_at);
+-- One open application per member.
+CREATE UNIQUE INDEX applications_one_pending ON applications (discord_id) WHERE status = 'pending';
@@ -0,0 +1,26 @@
+CREATE TABLE audit_log (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	at BIGINT NOT NULL,
+	actor_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target_user TEXT NOT NULL DEFAULT '',
+	target TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX audit_log_at ON audit_log (at);
+CREATE INDEX audit_log_actor_id ON audit_log (actor_id, at);
+CREATE INDEX audit_log_target_user ON audit_log (target_user, at);
+CREATE INDEX audit_log_action ON audit_log (action, at);
+-- The log is append-only.
+CREATE FUNCTION audit_log_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,15 @@
+CREATE TABLE player_sessions (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	minecraft_username TEXT NOT NULL,
+	minecraft_uuid TEXT,
+	joined_at BIGINT NOT NULL,
+	-- While the session is open, last_seen_at is bumped periodically so it
+	-- can be ended at a sensible time after an outage or restart.
+	last_seen_at BIGINT NOT NULL,
+	left_at BIGINT,
+	end_reason TEXT
+);
+CREATE INDEX player_sessions_username ON player_sessions (lower(minecraft_username), joined_at);
+CREATE INDEX player_sessions_joined_at ON player_sessions (joined_at);
+-- At most one open session per player.
+CREATE UNIQUE INDEX player_sessions_open ON player_sessions (lower(minecraft_username)) WHERE left_at IS NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE chat_messages (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	at BIGINT NOT NULL,
+	source TEXT NOT NULL CHECK (source IN ('minecraft', 'discord')),
+	author TEXT NOT NULL,
+	author_id TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL,
+	blocked BOOLEAN NOT NULL DEFAULT FALSE,
+	-- Full-text index over content. The 'simple' configuration doesn't stem,
+	-- matching the FTS5 table on SQLite.
+	content_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED
+);
+CREATE INDEX chat_messages_at ON chat_messages (at);
+CREATE INDEX chat_messages_author ON chat_messages (lower(author), at);
+CREATE INDEX chat_messages_author_id ON chat_messages (author_id, at);
+CREATE INDEX chat_messages_content_tsv ON chat_messages USING GIN (content_tsv);
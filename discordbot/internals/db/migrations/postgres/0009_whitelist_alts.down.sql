@@ -0,0 +1,8 @@
+-- Keep each member's oldest account; the others are set aside in
+-- whitelist_conflicts for staff rather than deleted.
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'extra account', EXTRACT(EPOCH FROM now())::BIGINT
+	FROM whitelist WHERE id NOT IN (SELECT MIN(id) FROM whitelist GROUP BY discord_id);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+DROP INDEX IF EXISTS whitelist_discord_id;
+CREATE UNIQUE INDEX whitelist_discord_id ON whitelist (discord_id);
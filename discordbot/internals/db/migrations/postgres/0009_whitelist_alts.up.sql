@@ -0,0 +1,3 @@
+-- Members may link more than one account; the limit is enforced by the bot.
+DROP INDEX IF EXISTS whitelist_discord_id;
+CREATE INDEX whitelist_discord_id ON whitelist (discord_id);
@@ -0,0 +1,21 @@
+-- Raw status reports from the mod, kept for a few days.
+CREATE TABLE status_samples (
+	at BIGINT NOT NULL,
+	tps DOUBLE PRECISION NOT NULL,
+	players INTEGER NOT NULL
+);
+CREATE INDEX status_samples_at ON status_samples (at);
+
+-- Hourly (resolution 3600) and daily (86400) summaries of status_samples;
+-- bucket is the start of the period in Unix seconds.
+CREATE TABLE status_rollups (
+	resolution INTEGER NOT NULL,
+	bucket BIGINT NOT NULL,
+	samples INTEGER NOT NULL,
+	tps_min DOUBLE PRECISION NOT NULL,
+	tps_avg DOUBLE PRECISION NOT NULL,
+	tps_max DOUBLE PRECISION NOT NULL,
+	players_avg DOUBLE PRECISION NOT NULL,
+	players_max INTEGER NOT NULL,
+	PRIMARY KEY (resolution, bucket)
+);
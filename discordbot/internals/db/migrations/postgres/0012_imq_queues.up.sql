@@ -0,0 +1,3 @@
+-- The durable queues are always SQLite: with a Postgres store they live in
+-- QueueDatabasePath, which runs the SQLite migrations. Nothing to do here.
+SELECT 1;
@@ -0,0 +1,6 @@
+-- Baseline: the table InitializeDatabase used to create by hand.
+CREATE TABLE IF NOT EXISTS whitelist (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	discord_id TEXT NOT NULL,
+	minecraft_username TEXT NOT NULL
+);
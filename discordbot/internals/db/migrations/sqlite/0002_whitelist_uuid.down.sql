@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS whitelist_minecraft_uuid;
+ALTER TABLE whitelist DROP COLUMN name_checked_at;
+ALTER TABLE whitelist DROP COLUMN minecraft_uuid;
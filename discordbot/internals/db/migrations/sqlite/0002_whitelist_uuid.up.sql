@@ -0,0 +1,5 @@
+-- Mojang UUID (32 hex, no dashes) is the player's identity; the username is
+-- refreshed from it and may lag behind a rename until the next refresh.
+ALTER TABLE whitelist ADD COLUMN minecraft_uuid TEXT;
+ALTER TABLE whitelist ADD COLUMN name_checked_at INTEGER;
+CREATE INDEX IF NOT EXISTS whitelist_minecraft_uuid ON whitelist (minecraft_uuid);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS whitelist_minecraft_uuid;
+DROP INDEX IF EXISTS whitelist_minecraft_username;
+DROP INDEX IF EXISTS whitelist_discord_id;
+CREATE INDEX IF NOT EXISTS whitelist_minecraft_uuid ON whitelist (minecraft_uuid);
+-- Whatever staff haven't dismissed goes back where it came from.
+INSERT INTO whitelist (id, discord_id, minecraft_username, minecraft_uuid)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid FROM whitelist_conflicts;
+DROP TABLE whitelist_conflicts;
@@ -0,0 +1,32 @@
+-- Racing approvals could store the same row twice. Before the unique indexes
+-- make that impossible, every copy but the oldest is moved aside for staff
+-- to sort out (/whitelist-admin conflicts) rather than deleted.
+CREATE TABLE IF NOT EXISTS whitelist_conflicts (
+	id INTEGER PRIMARY KEY, -- the row's id in whitelist
+	discord_id TEXT NOT NULL,
+	minecraft_username TEXT NOT NULL,
+	minecraft_uuid TEXT,
+	reason TEXT NOT NULL,
+	moved_at INTEGER NOT NULL
+);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Discord ID', CAST(strftime('%s', 'now') AS INTEGER)
+	FROM whitelist WHERE id NOT IN (SELECT MIN(id) FROM whitelist GROUP BY discord_id);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Minecraft username', CAST(strftime('%s', 'now') AS INTEGER)
+	FROM whitelist WHERE id NOT IN (SELECT MIN(id) FROM whitelist GROUP BY minecraft_username COLLATE NOCASE);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+INSERT INTO whitelist_conflicts (id, discord_id, minecraft_username, minecraft_uuid, reason, moved_at)
+	SELECT id, discord_id, minecraft_username, minecraft_uuid, 'duplicate Minecraft UUID', CAST(strftime('%s', 'now') AS INTEGER)
+	FROM whitelist WHERE minecraft_uuid IS NOT NULL
+		AND id NOT IN (SELECT MIN(id) FROM whitelist WHERE minecraft_uuid IS NOT NULL GROUP BY minecraft_uuid);
+DELETE FROM whitelist WHERE id IN (SELECT id FROM whitelist_conflicts);
+
+DROP INDEX IF EXISTS whitelist_minecraft_uuid;
+CREATE UNIQUE INDEX whitelist_discord_id ON whitelist (discord_id);
+CREATE UNIQUE INDEX whitelist_minecraft_username ON whitelist (minecraft_username COLLATE NOCASE);
+CREATE UNIQUE INDEX whitelist_minecraft_uuid ON whitelist (minecraft_uuid) WHERE minecraft_uuid IS NOT NULL;
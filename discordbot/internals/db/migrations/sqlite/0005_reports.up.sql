@@ -0,0 +1,21 @@
+CREATE TABLE reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL,
+	reporter_id TEXT NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	details TEXT NOT NULL DEFAULT '',
+	evidence TEXT NOT NULL DEFAULT '',
+	context TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'open'
+		CHECK (status IN ('open', 'resolved', 'dismissed')),
+	assignee_id TEXT,
+	resolution TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	closed_at INTEGER,
+	channel_id TEXT,
+	message_id TEXT
+);
+CREATE INDEX reports_status ON reports (status, created_at);
+CREATE INDEX reports_reporter_id ON reports (reporter_id, created_at);
+CREATE INDEX reports_subject ON reports (subject COLLATE NOCASE);
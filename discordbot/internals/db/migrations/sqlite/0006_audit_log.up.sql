@@ -0,0 +1,23 @@
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	at INTEGER NOT NULL,
+	actor_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target_user TEXT NOT NULL DEFAULT '',
+	target TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX audit_log_at ON audit_log (at);
+CREATE INDEX audit_log_actor_id ON audit_log (actor_id, at);
+CREATE INDEX audit_log_target_user ON audit_log (target_user, at);
+CREATE INDEX audit_log_action ON audit_log (action, at);
+-- The log is append-only.
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
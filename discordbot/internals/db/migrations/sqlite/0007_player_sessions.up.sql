@@ -0,0 +1,15 @@
+CREATE TABLE player_sessions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	minecraft_username TEXT NOT NULL,
+	minecraft_uuid TEXT,
+	joined_at INTEGER NOT NULL,
+	-- While the session is open, last_seen_at is bumped periodically so it
+	-- can be ended at a sensible time after an outage or restart.
+	last_seen_at INTEGER NOT NULL,
+	left_at INTEGER,
+	end_reason TEXT
+);
+CREATE INDEX player_sessions_username ON player_sessions (minecraft_username COLLATE NOCASE, joined_at);
+CREATE INDEX player_sessions_joined_at ON player_sessions (joined_at);
+-- At most one open session per player.
+CREATE UNIQUE INDEX player_sessions_open ON player_sessions (minecraft_username COLLATE NOCASE) WHERE left_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_fts;
+DROP TABLE IF EXISTS chat_messages;
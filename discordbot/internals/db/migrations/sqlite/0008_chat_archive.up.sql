@@ -0,0 +1,27 @@
+CREATE TABLE chat_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	at INTEGER NOT NULL,
+	source TEXT NOT NULL CHECK (source IN ('minecraft', 'discord')),
+	author TEXT NOT NULL,
+	author_id TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL,
+	blocked INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX chat_messages_at ON chat_messages (at);
+CREATE INDEX chat_messages_author ON chat_messages (author COLLATE NOCASE, at);
+CREATE INDEX chat_messages_author_id ON chat_messages (author_id, at);
+
+-- Full-text index over chat_messages, kept in step by the triggers below.
+CREATE VIRTUAL TABLE chat_fts USING fts5(
+	content,
+	content = 'chat_messages',
+	content_rowid = 'id'
+);
+CREATE TRIGGER chat_messages_ai AFTER INSERT ON chat_messages
+BEGIN
+	INSERT INTO chat_fts (rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER chat_messages_ad AFTER DELETE ON chat_messages
+BEGIN
+	INSERT INTO chat_fts (chat_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
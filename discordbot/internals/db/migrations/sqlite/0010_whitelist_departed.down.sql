@@ -0,0 +1,4 @@
+-- Without the grace period, departed members would have been deleted already.
+DELETE FROM whitelist WHERE departed_at IS NOT NULL;
+DROP INDEX IF EXISTS whitelist_departed_at;
+ALTER TABLE whitelist DROP COLUMN departed_at;
@@ -0,0 +1,4 @@
+-- Members who leave Discord keep their rows for a grace period so a rejoin
+-- can restore them; departed_at is when they left, NULL while they're here.
+ALTER TABLE whitelist ADD COLUMN departed_at INTEGER;
+CREATE INDEX whitelist_departed_at ON whitelist (departed_at) WHERE departed_at IS NOT NULL;
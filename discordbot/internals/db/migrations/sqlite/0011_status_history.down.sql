@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS status_rollups;
+DROP TABLE IF EXISTS status_samples;
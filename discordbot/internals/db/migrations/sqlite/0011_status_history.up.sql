@@ -0,0 +1,21 @@
+-- Raw status reports from the mod, kept for a few days.
+CREATE TABLE status_samples (
+	at INTEGER NOT NULL,
+	tps REAL NOT NULL,
+	players INTEGER NOT NULL
+);
+CREATE INDEX status_samples_at ON status_samples (at);
+
+-- Hourly (resolution 3600) and daily (86400) summaries of status_samples;
+-- bucket is the start of the period in Unix seconds.
+CREATE TABLE status_rollups (
+	resolution INTEGER NOT NULL,
+	bucket INTEGER NOT NULL,
+	samples INTEGER NOT NULL,
+	tps_min REAL NOT NULL,
+	tps_avg REAL NOT NULL,
+	tps_max REAL NOT NULL,
+	players_avg REAL NOT NULL,
+	players_max INTEGER NOT NULL,
+	PRIMARY KEY (resolution, bucket)
+);
@@ -0,0 +1,3 @@
+-- The queue tables may predate this migration (imq used to create them) and
+-- hold commands that were never sent, so rolling back leaves them alone.
+SELECT 1;
@@ -0,0 +1,26 @@
+-- Durable queues (internals/imq). Several named queues share these tables.
+-- Earlier builds created them from imq itself, hence IF NOT EXISTS.
+CREATE TABLE IF NOT EXISTS imq_messages (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	queue TEXT NOT NULL,
+	id TEXT NOT NULL,
+	body BLOB,
+	headers TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	enqueued_at INTEGER NOT NULL,
+	visible_at INTEGER NOT NULL DEFAULT 0,
+	lease TEXT,
+	last_error TEXT,
+	priority INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS imq_messages_ready ON imq_messages (queue, visible_at, seq);
+CREATE INDEX IF NOT EXISTS imq_messages_id ON imq_messages (queue, id);
+CREATE INDEX IF NOT EXISTS imq_messages_priority ON imq_messages (queue, priority DESC, seq);
+
+-- IDs acked within a queue's dedupe window.
+CREATE TABLE IF NOT EXISTS imq_dedupe (
+	queue TEXT NOT NULL,
+	id TEXT NOT NULL,
+	expires_at INTEGER NOT NULL,
+	PRIMARY KEY (queue, id)
+);
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"strings"
+	"time"
+)
+
+const reportColumns = `id, type, reporter_id, subject, details, evidence, context, status,
+	assignee_id, resolution, created_at, updated_at, closed_at, channel_id, message_id`
+
+func scanReport(row scanner) (*entities.Report, error) {
+	var (
+		r                        entities.Report
+		assignee, channel, msgID sql.NullString
+		createdAt, updatedAt     int64
+		closedAt                 sql.NullInt64
+	)
+	err := row.Scan(&r.ID, &r.Type, &r.ReporterID, &r.Subject, &r.Details, &r.Evidence, &r.Context, &r.Status,
+		&assignee, &r.Resolution, &createdAt, &updatedAt, &closedAt, &channel, &msgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r.AssigneeID = assignee.String
+	r.CreatedAt = time.Unix(createdAt, 0)
+	r.UpdatedAt = time.Unix(updatedAt, 0)
+	if closedAt.Valid {
+		r.ClosedAt = time.Unix(closedAt.Int64, 0)
+	}
+	r.ChannelID = channel.String
+	r.MessageID = msgID.String
+	return &r, nil
+}
+
+func (s *SQLStore) CreateReport(ctx context.Context, r *entities.Report) error {
+	r.Status = entities.ReportOpen
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+	id, err := s.insertID(ctx, `INSERT INTO reports
+		(type, reporter_id, subject, details, evidence, context, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Type, r.ReporterID, r.Subject, r.Details, r.Evidence, r.Context, r.Status, r.CreatedAt.Unix(), r.UpdatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	r.ID = id
+	return nil
+}
+
+func (s *SQLStore) GetReport(ctx context.Context, id int64) (*entities.Report, error) {
+	return scanReport(s.queryRow(ctx, `SELECT `+reportColumns+` FROM reports WHERE id = ?`, id))
+}
+
+func (s *SQLStore) SetReportMessage(ctx context.Context, id int64, channelID, messageID string) error {
+	res, err := s.exec(ctx, `UPDATE reports SET channel_id = ?, message_id = ? WHERE id = ?`, channelID, messageID, id)
+	return requireRow(res, err, ErrReportNotFound)
+}
+
+func (s *SQLStore) AssignReport(ctx context.Context, id int64, assigneeID string) error {
+	res, err := s.exec(ctx, `UPDATE reports SET assignee_id = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		nullString(assigneeID), time.Now().Unix(), id, entities.ReportOpen)
+	return s.requireOpenReport(ctx, res, err, id)
+}
+
+func (s *SQLStore) CloseReport(ctx context.Context, id int64, status entities.ReportStatus, moderatorID, resolution string) error {
+	now := time.Now().Unix()
+	res, err := s.exec(ctx, `UPDATE reports
+		SET status = ?, resolution = ?, assignee_id = COALESCE(assignee_id, ?), updated_at = ?, closed_at = ?
+		WHERE id = ? AND status = ?`,
+		status, resolution, nullString(moderatorID), now, now, id, entities.ReportOpen)
+	return s.requireOpenReport(ctx, res, err, id)
+}
+
+// requireOpenReport checks the result of an UPDATE that only matches an open
+// report. When it matched nothing, the report is looked up to tell a missing
+// report from a closed one.
+func (s *SQLStore) requireOpenReport(ctx context.Context, res sql.Result, err error, id int64) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var current entities.ReportStatus
+	err = s.queryRow(ctx, `SELECT status FROM reports WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReportNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", ErrReportClosed, current)
+}
+
+func (s *SQLStore) SearchReports(ctx context.Context, f ReportFilter) ([]entities.Report, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.ReporterID != "" {
+		where = append(where, "reporter_id = ?")
+		args = append(args, f.ReporterID)
+	}
+	if f.AssigneeID != "" {
+		where = append(where, "assignee_id = ?")
+		args = append(args, f.AssigneeID)
+	}
+	if f.Subject != "" {
+		where = append(where, s.Dialect.nocaseLike("subject"))
+		args = append(args, "%"+escapeLike(f.Subject)+"%")
+	}
+	if f.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, f.Type)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	q := `SELECT ` + reportColumns + ` FROM reports`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, f.limit())
+
+	rows, err := s.query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.Report
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
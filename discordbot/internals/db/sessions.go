@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"limpan/rotaria-bot/entities"
+	"time"
+)
+
+func (s *SQLStore) StartSession(ctx context.Context, minecraftUsername, minecraftUUID string, at time.Time) error {
+	// player_sessions_open allows one open session per player; a repeated
+	// join is a no-op.
+	_, err := s.exec(ctx, `INSERT INTO player_sessions
+		(minecraft_username, minecraft_uuid, joined_at, last_seen_at)
+		VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		minecraftUsername, nullString(minecraftUUID), at.Unix(), at.Unix())
+	return err
+}
+
+func (s *SQLStore) EndSession(ctx context.Context, minecraftUsername string, at time.Time, reason string) error {
+	_, err := s.exec(ctx, `UPDATE player_sessions SET left_at = `+s.Dialect.greatest("?", "joined_at")+`, end_reason = ?
+		WHERE `+s.Dialect.nocaseEq("minecraft_username")+` AND left_at IS NULL`,
+		at.Unix(), reason, minecraftUsername)
+	return err
+}
+
+func (s *SQLStore) TouchOpenSessions(ctx context.Context, at time.Time) error {
+	_, err := s.exec(ctx, `UPDATE player_sessions SET last_seen_at = ? WHERE left_at IS NULL`, at.Unix())
+	return err
+}
+
+func (s *SQLStore) EndOpenSessions(ctx context.Context, reason string) (int, error) {
+	res, err := s.exec(ctx, `UPDATE player_sessions SET left_at = last_seen_at, end_reason = ? WHERE left_at IS NULL`, reason)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// playtimeSelect aggregates sessions overlapping [since, now); its five
+// leading parameters are now, since, now, now, since.
+func (s *SQLStore) playtimeSelect() string {
+	return `SELECT MAX(minecraft_username), COUNT(*),
+	CAST(SUM(COALESCE(left_at, ?) - ` + s.Dialect.greatest("joined_at", "?") + `) AS BIGINT), MIN(joined_at),
+	MAX(COALESCE(left_at, ?)), MAX(CASE WHEN left_at IS NULL THEN 1 ELSE 0 END)
+	FROM player_sessions WHERE COALESCE(left_at, ?) > ?`
+}
+
+func (s *SQLStore) GetPlaytime(ctx context.Context, minecraftUsername string, since, now time.Time) (*entities.Playtime, error) {
+	n, from := now.Unix(), unixOrZero(since)
+	rows, err := s.query(ctx, s.playtimeSelect()+` AND `+s.Dialect.nocaseEq("minecraft_username"),
+		n, from, n, n, from, minecraftUsername)
+	if err != nil {
+		return nil, err
+	}
+	out, err := scanPlaytimes(rows)
+	if err != nil || len(out) == 0 {
+		return nil, err
+	}
+	return &out[0], nil
+}
+
+func (s *SQLStore) TopPlaytime(ctx context.Context, since, now time.Time, limit int) ([]entities.Playtime, error) {
+	n, from := now.Unix(), unixOrZero(since)
+	rows, err := s.query(ctx, s.playtimeSelect()+`
+		GROUP BY `+s.Dialect.nocase("minecraft_username")+` ORDER BY 3 DESC, 1 LIMIT ?`,
+		n, from, n, n, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPlaytimes(rows)
+}
+
+func scanPlaytimes(rows *sql.Rows) ([]entities.Playtime, error) {
+	defer rows.Close()
+	var out []entities.Playtime
+	for rows.Next() {
+		var (
+			p                          entities.Playtime
+			name                       sql.NullString
+			total, first, last, online sql.NullInt64
+		)
+		if err := rows.Scan(&name, &p.Sessions, &total, &first, &last, &online); err != nil {
+			return nil, err
+		}
+		if p.Sessions == 0 {
+			continue // the ungrouped aggregate over no rows
+		}
+		p.MinecraftUsername = name.String
+		p.Total = time.Duration(total.Int64) * time.Second
+		p.FirstSeen = time.Unix(first.Int64, 0)
+		p.LastSeen = time.Unix(last.Int64, 0)
+		p.Online = online.Int64 == 1
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
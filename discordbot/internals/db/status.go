@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"time"
+)
+
+const (
+	hourSeconds = int64(entities.StatusHourly / time.Second)
+	daySeconds  = int64(entities.StatusDaily / time.Second)
+)
+
+// rollupUpsert overwrites a bucket that was rolled up before it was complete,
+// but never with fewer samples: the oldest bucket redone after its inputs
+// were partly pruned (e.g. the first run after a restart) keeps what it had.
+const rollupUpsert = ` ON CONFLICT (resolution, bucket) DO UPDATE SET
+	samples = excluded.samples, tps_min = excluded.tps_min, tps_avg = excluded.tps_avg,
+	tps_max = excluded.tps_max, players_avg = excluded.players_avg, players_max = excluded.players_max
+	WHERE excluded.samples >= status_rollups.samples`
+
+func (s *SQLStore) RecordStatus(ctx context.Context, sample entities.StatusSample) error {
+	if sample.At.IsZero() {
+		sample.At = time.Now()
+	}
+	_, err := s.exec(ctx, `INSERT INTO status_samples (at, tps, players) VALUES (?, ?, ?)`,
+		sample.At.Unix(), sample.TPS, sample.Players)
+	return err
+}
+
+func (s *SQLStore) RollupStatus(ctx context.Context, since time.Time) error {
+	hourFrom := floorUnix(since, hourSeconds)
+	dayFrom := floorUnix(since, daySeconds)
+	return s.inTx(ctx, func(tx *txn) error {
+		// SQLite needs the WHERE to tell the upsert's ON from a join's.
+		_, err := tx.exec(ctx, fmt.Sprintf(`INSERT INTO status_rollups
+			(resolution, bucket, samples, tps_min, tps_avg, tps_max, players_avg, players_max)
+			SELECT %[1]d, at / %[1]d * %[1]d, COUNT(*), MIN(tps), AVG(tps), MAX(tps),
+				CAST(AVG(players) AS DOUBLE PRECISION), MAX(players)
+			FROM status_samples WHERE at >= ?
+			GROUP BY at / %[1]d * %[1]d`, hourSeconds)+rollupUpsert, hourFrom)
+		if err != nil {
+			return fmt.Errorf("roll up hours: %w", err)
+		}
+		_, err = tx.exec(ctx, fmt.Sprintf(`INSERT INTO status_rollups
+			(resolution, bucket, samples, tps_min, tps_avg, tps_max, players_avg, players_max)
+			SELECT %[1]d, bucket / %[1]d * %[1]d, SUM(samples), MIN(tps_min),
+				SUM(tps_avg * samples) / SUM(samples), MAX(tps_max),
+				SUM(players_avg * samples) / SUM(samples), MAX(players_max)
+			FROM status_rollups WHERE resolution = %[2]d AND bucket >= ?
+			GROUP BY bucket / %[1]d * %[1]d`, daySeconds, hourSeconds)+rollupUpsert, dayFrom)
+		if err != nil {
+			return fmt.Errorf("roll up days: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) StatusRollups(ctx context.Context, resolution time.Duration, since time.Time) ([]entities.StatusRollup, error) {
+	rows, err := s.query(ctx, `SELECT bucket, samples, tps_min, tps_avg, tps_max, players_avg, players_max
+		FROM status_rollups WHERE resolution = ? AND bucket >= ? ORDER BY bucket`,
+		int64(resolution/time.Second), unixOrZero(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []entities.StatusRollup
+	for rows.Next() {
+		var (
+			r      entities.StatusRollup
+			bucket int64
+		)
+		if err := rows.Scan(&bucket, &r.Samples, &r.TPSMin, &r.TPSAvg, &r.TPSMax, &r.PlayersAvg, &r.PlayersMax); err != nil {
+			return nil, err
+		}
+		r.Bucket = time.Unix(bucket, 0)
+		r.Resolution = resolution
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) PruneStatus(ctx context.Context, samplesBefore, hourlyBefore time.Time) (int, error) {
+	var n int64
+	err := s.inTx(ctx, func(tx *txn) error {
+		res, err := tx.exec(ctx, `DELETE FROM status_samples WHERE at < ?`, samplesBefore.Unix())
+		if err != nil {
+			return err
+		}
+		samples, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		res, err = tx.exec(ctx, `DELETE FROM status_rollups WHERE resolution = ? AND bucket < ?`, hourSeconds, hourlyBefore.Unix())
+		if err != nil {
+			return err
+		}
+		hours, err := res.RowsAffected()
+		n = samples + hours
+		return err
+	})
+	return int(n), err
+}
+
+// floorUnix rounds t down to a multiple of step seconds since the epoch.
+func floorUnix(t time.Time, step int64) int64 {
+	u := t.Unix()
+	return u - u%step
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"time"
+)
+
+// Store is everything the bot persists, implemented by SQLStore (and, in
+// the tests, by MemStore). Each area has its own interface so callers can
+// ask for less.
+type Store interface {
+	WhitelistStore
+	ApplicationStore
+	ReportStore
+	AuditStore
+	SessionStore
+	ChatStore
+	StatusStore
+	Close() error
+}
+
+var _ Store = (*SQLStore)(nil)
+
+type WhitelistStore interface {
+	// AddWhitelistEntry fails with a *ConflictError if the Discord user
+	// already has maxAccounts entries (0 means no limit) or the Minecraft
+	// account is already whitelisted.
+	AddWhitelistEntry(ctx context.Context, entry entities.WhiteListEntry, maxAccounts int) error
+	RemoveWhitelistEntry(ctx context.Context, id int) error
+	// GetWhitelistEntries returns a member's accounts, oldest first. Departed
+	// entries are left out.
+	GetWhitelistEntries(ctx context.Context, discordID string) ([]entities.WhiteListEntry, error)
+	// The Get methods return nil, nil when nothing matches. They include
+	// departed entries, which still hold their names.
+	GetWhitelistEntryByUUID(ctx context.Context, uuid string) (*entities.WhiteListEntry, error)
+	GetWhitelistEntryByUsername(ctx context.Context, minecraftUsername string) (*entities.WhiteListEntry, error)
+	// ListWhitelistEntries returns every entry that isn't departed, oldest
+	// first.
+	ListWhitelistEntries(ctx context.Context) ([]entities.WhiteListEntry, error)
+	// ListStaleWhitelistEntries returns entries whose name hasn't been checked
+	// against Mojang since before, oldest check first.
+	ListStaleWhitelistEntries(ctx context.Context, before time.Time, limit int) ([]entities.WhiteListEntry, error)
+	// UpdateWhitelistIdentity records the current name (and the UUID, for
+	// rows that predate it) and marks the row as freshly checked.
+	UpdateWhitelistIdentity(ctx context.Context, id int, minecraftUsername, minecraftUUID string) error
+	// WhitelistCount counts the entries that aren't departed.
+	WhitelistCount(ctx context.Context) (int, error)
+
+	// DepartWhitelistEntries marks the member's accounts as departed at at
+	// and returns them. Already departed entries keep their time.
+	DepartWhitelistEntries(ctx context.Context, discordID string, at time.Time) ([]entities.WhiteListEntry, error)
+	// RestoreWhitelistEntries undoes DepartWhitelistEntries and returns the
+	// entries it brought back.
+	RestoreWhitelistEntries(ctx context.Context, discordID string) ([]entities.WhiteListEntry, error)
+	// ListDepartedWhitelistEntries returns every departed entry, earliest
+	// departure first.
+	ListDepartedWhitelistEntries(ctx context.Context) ([]entities.WhiteListEntry, error)
+	// PurgeDepartedWhitelistEntries deletes the member's entries that
+	// departed at or before before and returns them.
+	PurgeDepartedWhitelistEntries(ctx context.Context, discordID string, before time.Time) ([]entities.WhiteListEntry, error)
+
+	// ListWhitelistConflicts returns the rows migrations set aside, oldest
+	// first.
+	ListWhitelistConflicts(ctx context.Context) ([]entities.WhitelistConflict, error)
+	// DismissWhitelistConflict forgets a set-aside row. It fails with
+	// ErrConflictNotFound if there is no such row.
+	DismissWhitelistConflict(ctx context.Context, id int) error
+}
+
+type ApplicationStore interface {
+	// CreateApplication stores app as pending and sets its ID and CreatedAt.
+	// It fails with ErrApplicationPending if the member already has one open.
+	CreateApplication(ctx context.Context, app *entities.Application) error
+	// GetApplication returns nil, nil when there is no such application.
+	GetApplication(ctx context.Context, id int64) (*entities.Application, error)
+	SetApplicationMessage(ctx context.Context, id int64, channelID, messageID string) error
+	// DecideApplication moves a pending application to status. It fails with
+	// ErrApplicationClosed if someone else already decided it.
+	DecideApplication(ctx context.Context, id int64, status entities.ApplicationStatus, reviewerID string) error
+	// ReopenApplication moves an application decided as status back to
+	// pending, for when the step after DecideApplication fails. It fails with
+	// ErrApplicationPending if the member has opened another one since.
+	ReopenApplication(ctx context.Context, id int64, status entities.ApplicationStatus) error
+	// WithdrawApplications closes the member's pending applications and
+	// returns them.
+	WithdrawApplications(ctx context.Context, discordID string) ([]entities.Application, error)
+	// SearchApplications returns matching applications, newest first.
+	SearchApplications(ctx context.Context, f ApplicationFilter) ([]entities.Application, error)
+}
+
+// ApplicationFilter narrows SearchApplications; zero fields match everything.
+type ApplicationFilter struct {
+	DiscordID         string
+	MinecraftUsername string // case-insensitive substring
+	Status            entities.ApplicationStatus
+	Limit             int // default 25
+}
+
+func (f ApplicationFilter) limit() int {
+	if f.Limit <= 0 {
+		return 25
+	}
+	return f.Limit
+}
+
+type ReportStore interface {
+	// CreateReport stores r as open and sets its ID and timestamps.
+	CreateReport(ctx context.Context, r *entities.Report) error
+	// GetReport returns nil, nil when there is no such report.
+	GetReport(ctx context.Context, id int64) (*entities.Report, error)
+	SetReportMessage(ctx context.Context, id int64, channelID, messageID string) error
+	// AssignReport hands an open report to a staff member. It fails with
+	// ErrReportClosed once the report has been closed.
+	AssignReport(ctx context.Context, id int64, assigneeID string) error
+	// CloseReport resolves or dismisses an open report with a note. The
+	// closer becomes the assignee if nobody had claimed it. It fails with
+	// ErrReportClosed if someone else already closed it.
+	CloseReport(ctx context.Context, id int64, status entities.ReportStatus, moderatorID, resolution string) error
+	// SearchReports returns matching reports, newest first.
+	SearchReports(ctx context.Context, f ReportFilter) ([]entities.Report, error)
+}
+
+// ReportFilter narrows SearchReports; zero fields match everything.
+type ReportFilter struct {
+	ReporterID string
+	AssigneeID string
+	Subject    string // case-insensitive substring
+	Type       string
+	Status     entities.ReportStatus
+	Limit      int // default 25
+}
+
+func (f ReportFilter) limit() int {
+	if f.Limit <= 0 {
+		return 25
+	}
+	return f.Limit
+}
+
+// AuditStore is append-only: entries are never changed or removed.
+type AuditStore interface {
+	// AppendAudit stores e and sets its ID, and At if it was zero.
+	AppendAudit(ctx context.Context, e *entities.AuditEntry) error
+	// SearchAudit returns matching entries, newest first.
+	SearchAudit(ctx context.Context, f AuditFilter) ([]entities.AuditEntry, error)
+}
+
+// AuditFilter narrows SearchAudit; zero fields match everything.
+type AuditFilter struct {
+	UserID string // matches the actor or the target user
+	Action string
+	Since  time.Time // inclusive
+	Until  time.Time // exclusive
+	Limit  int       // default 25
+}
+
+func (f AuditFilter) limit() int {
+	if f.Limit <= 0 {
+		return 25
+	}
+	return f.Limit
+}
+
+// SessionStore tracks when players are on the server. Players are keyed by
+// name, case-insensitively, since that is all join and leave events carry.
+type SessionStore interface {
+	// StartSession opens a session unless the player already has one open.
+	StartSession(ctx context.Context, minecraftUsername, minecraftUUID string, at time.Time) error
+	// EndSession closes the player's open session, if any.
+	EndSession(ctx context.Context, minecraftUsername string, at time.Time, reason string) error
+	// TouchOpenSessions records that every open session was still live at at.
+	TouchOpenSessions(ctx context.Context, at time.Time) error
+	// EndOpenSessions closes every open session at the last time it was
+	// seen live and returns how many it closed.
+	EndOpenSessions(ctx context.Context, reason string) (int, error)
+	// GetPlaytime sums the player's time since since, counting an open
+	// session up to now. It returns nil, nil if they weren't on in that
+	// period; pass a zero since for all time.
+	GetPlaytime(ctx context.Context, minecraftUsername string, since, now time.Time) (*entities.Playtime, error)
+	// TopPlaytime returns the players with the most time since since.
+	TopPlaytime(ctx context.Context, since, now time.Time, limit int) ([]entities.Playtime, error)
+}
+
+type ChatStore interface {
+	// ArchiveChat stores m and sets its ID, and At if it was zero.
+	ArchiveChat(ctx context.Context, m *entities.ChatMessage) error
+	// SearchChat returns matching messages, newest first.
+	SearchChat(ctx context.Context, f ChatFilter) ([]entities.ChatMessage, error)
+	// PruneChat deletes messages older than before and returns how many.
+	PruneChat(ctx context.Context, before time.Time) (int, error)
+}
+
+// StatusStore keeps the server's TPS and player count over time: raw
+// samples for a few days, and hourly and daily rollups of them for longer.
+type StatusStore interface {
+	RecordStatus(ctx context.Context, sample entities.StatusSample) error
+	// RollupStatus recomputes the hourly rollups from the samples since since
+	// (rounded down to the hour), and the daily ones from those hours.
+	// Buckets that are still filling are rolled up as far as they go.
+	RollupStatus(ctx context.Context, since time.Time) error
+	// StatusRollups returns the rollups at resolution (entities.StatusHourly
+	// or entities.StatusDaily) whose buckets start at or after since, oldest
+	// first.
+	StatusRollups(ctx context.Context, resolution time.Duration, since time.Time) ([]entities.StatusRollup, error)
+	// PruneStatus deletes samples older than samplesBefore and hourly rollups
+	// older than hourlyBefore, and returns how many rows went. Daily rollups
+	// are kept.
+	PruneStatus(ctx context.Context, samplesBefore, hourlyBefore time.Time) (int, error)
+}
+
+// ChatFilter narrows SearchChat; zero fields match everything.
+type ChatFilter struct {
+	Query  string // words that must all appear, in any order
+	Author string // name (case-insensitive) or Discord ID / UUID
+	Since  time.Time
+	Limit  int // default 25
+	Offset int
+}
+
+func (f ChatFilter) limit() int {
+	if f.Limit <= 0 {
+		return 25
+	}
+	return f.Limit
+}
+
+var (
+	ErrReportNotFound = errors.New("db: report not found")
+	ErrReportClosed   = errors.New("db: report has already been closed")
+)
+
+var (
+	ErrApplicationNotFound = errors.New("db: application not found")
+	ErrApplicationPending  = errors.New("db: member already has a pending application")
+	ErrApplicationClosed   = errors.New("db: application has already been decided")
+)
+
+var (
+	ErrAccountLimit     = errors.New("db: discord user has reached the account limit")
+	ErrUsernameClaimed  = errors.New("db: minecraft account is already whitelisted")
+	ErrConflictNotFound = errors.New("db: whitelist conflict not found")
+)
+
+// ErrAlreadyWhitelisted is what ErrAccountLimit was called when members could
+// only have one account.
+var ErrAlreadyWhitelisted = ErrAccountLimit
+
+// ConflictError is returned when a whitelist write clashes with an existing
+// row. It wraps ErrAccountLimit (Existing is the member's oldest account) or
+// ErrUsernameClaimed.
+type ConflictError struct {
+	Err      error
+	Existing entities.WhiteListEntry
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s is held by Discord ID %s", e.Err, e.Existing.MinecraftUsername, e.Existing.DiscordID)
+}
+
+func (e *ConflictError) Unwrap() error { return e.Err }
@@ -0,0 +1,60 @@
+package imq
+
+import (
+	"context"
+	"time"
+)
+
+// Clock lets tests drive scheduled delivery and visibility timeouts without
+// sleeping. Queues use RealClock when none is set.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+type realTimer struct{ t *time.Timer }
+
+// RealClock is the wall clock.
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+
+func (t realTimer) Stop() bool { return t.t.Stop() }
+
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// withDeadline is context.WithDeadline on clock. On the real clock the
+// deadline is visible through ctx.Deadline; on any other clock ctx is
+// cancelled with cause context.DeadlineExceeded when the clock reaches it.
+func withDeadline(ctx context.Context, clock Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	cctx, cancel := context.WithCancelCause(ctx)
+	t := clock.NewTimer(deadline.Sub(clock.Now()))
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.C():
+			cancel(context.DeadlineExceeded)
+		case <-cctx.Done():
+		}
+	}()
+	return cctx, func() { cancel(context.Canceled) }
+}
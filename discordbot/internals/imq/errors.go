@@ -0,0 +1,53 @@
+package imq
+
+import (
+	"errors"
+	"limpan/rotaria-bot/internals/utils"
+	"time"
+)
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type transientError struct{ err error }
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that will never succeed on retry, e.g. the
+// server rejecting a player that doesn't exist. The Worker dead-letters the
+// message right away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Transient marks err as caused by an outage rather than the message itself.
+// The Worker requeues with jittered backoff and doesn't count the attempt.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+func IsTransient(err error) bool {
+	var t *transientError
+	return errors.As(err, &t)
+}
+
+// jitter spreads d by ±frac.
+func jitter(d time.Duration, frac float64) time.Duration {
+	spread := float64(d) * frac
+	r := float64(utils.RandUint32())/float64(^uint32(0))*2 - 1 // [-1, 1]
+	return d + time.Duration(r*spread)
+}
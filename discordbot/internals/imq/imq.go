@@ -3,8 +3,8 @@ package imq
 import (
 	"context"
 	"errors"
-	"limpan/rotaria-bot/internals/utils"
-	"sync"
+	"log"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,95 +12,150 @@ import (
 type Message struct {
 	ID         string
 	Body       []byte
-	Attempts   int
+	Attempts   int // deliveries so far, maintained by the queue
 	EnqueuedAt time.Time
 	Headers    map[string]string
+	LastError  string // error from the most recent failed attempt
+	// Priority puts urgent messages (kicks, bans) ahead of bulk ones. Higher
+	// is delivered first; equal priorities keep publish order.
+	Priority int
 }
 
-// Queue is a minimal in-memory queue with a single FIFO channel.
-// It is intentionally simple so you can later swap it for NATS/RabbitMQ/etc.
-type Queue struct {
-	ch     chan Message
-	closed bool
-	mu     sync.Mutex
+// Queue is what a Worker consumes from. Implementations: MemQueue (in-memory)
+// and SQLiteQueue (durable).
+//
+// Publishing a message whose ID is still in the queue is a no-op. Queues with
+// a DedupeWindow also ignore IDs that were acked within that window.
+type Queue interface {
+	Publish(ctx context.Context, m Message) error
+	// PublishAt holds m back until at; PublishAfter until d from now.
+	PublishAt(ctx context.Context, m Message, at time.Time) error
+	PublishAfter(ctx context.Context, m Message, d time.Duration) error
+	// Consume returns a receive-only channel that will close when ctx is done or the queue is closed.
+	Consume(ctx context.Context) <-chan Delivery
+	Len() int
+	Close()
 }
 
-func New(capacity int) *Queue {
-	if capacity <= 0 {
-		capacity = 1024
-	}
-	return &Queue{ch: make(chan Message, capacity)}
+// Inspector is implemented by queues whose contents can be listed and edited,
+// which is what dead-letter handling needs.
+type Inspector interface {
+	// Peek returns up to limit messages, oldest first, without leasing them.
+	Peek(ctx context.Context, limit int) ([]Message, error)
+	// Take removes and returns the message with the given ID.
+	Take(ctx context.Context, id string) (Message, error)
+	// Purge removes every message that is not currently leased.
+	Purge(ctx context.Context) (int, error)
 }
 
-var ErrClosed = errors.New("imq: queue closed")
+var (
+	ErrClosed       = errors.New("imq: queue closed")
+	ErrLeaseExpired = errors.New("imq: lease expired")
+	ErrNotFound     = errors.New("imq: message not found")
+)
 
-func (q *Queue) Publish(ctx context.Context, m Message) error {
-	q.mu.Lock()
-	if q.closed {
-		q.mu.Unlock()
-		return ErrClosed
-	}
-	q.mu.Unlock()
-	if m.ID == "" {
-		m.ID = utils.NewID()
-	}
-	m.EnqueuedAt = time.Now()
-	select {
-	case q.ch <- m:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+// DefaultVisibilityTimeout is how long a delivery stays invisible to other
+// consumers before it is handed out again.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// Delivery is a leased message. The consumer must Ack or Nack it before
+// Deadline; otherwise the message becomes visible again and is redelivered.
+type Delivery struct {
+	Message
+	Deadline time.Time
+
+	lease string
+	q     leaser
 }
 
-// Consume returns a receive-only channel that will close when ctx is done or the queue is closed.
-func (q *Queue) Consume(ctx context.Context) <-chan Message {
-	out := make(chan Message)
-	go func() {
-		defer close(out)
-		for {
-			select {
-			case m, ok := <-q.ch:
-				if !ok {
-					return
-				}
-				select {
-				case out <- m:
-				case <-ctx.Done():
-					return
-				}
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-	return out
+type leaser interface {
+	ack(ctx context.Context, d Delivery) error
+	// nack and requeue make the message visible again at at (zero: now).
+	nack(ctx context.Context, d Delivery, at time.Time) error
+	requeue(ctx context.Context, d Delivery, at time.Time) error
+	extend(ctx context.Context, d Delivery, by time.Duration) (time.Time, error)
+	now() time.Time
 }
 
-func (q *Queue) Close() {
-	q.mu.Lock()
-	if !q.closed {
-		q.closed = true
-		close(q.ch)
-	}
-	q.mu.Unlock()
+// Ack removes the message from the queue. It returns ErrLeaseExpired if the
+// lease ran out and the message may already have been redelivered.
+func (d Delivery) Ack(ctx context.Context) error { return d.q.ack(ctx, d) }
+
+// Nack releases the lease; the message becomes visible again after requeueAfter.
+func (d Delivery) Nack(ctx context.Context, requeueAfter time.Duration) error {
+	return d.q.nack(ctx, d, d.q.now().Add(requeueAfter))
 }
 
-func (q *Queue) Len() int { return len(q.ch) }
+// Extend keeps the lease for another by, for consumers that hold a message
+// longer than the visibility timeout. It returns the delivery with its new
+// Deadline, or ErrLeaseExpired if the message was handed out again.
+func (d Delivery) Extend(ctx context.Context, by time.Duration) (Delivery, error) {
+	if by <= 0 {
+		return d, nil
+	}
+	deadline, err := d.q.extend(ctx, d, by)
+	if err != nil {
+		return d, err
+	}
+	d.Deadline = deadline
+	return d, nil
+}
 
-// Worker pulls from Q and calls Handle. On error it retries with backoff, then optionally dead-letters.
+// Worker pulls from Q and calls Handle. On error the delivery is nacked with
+// backoff; after MaxRetries it is optionally dead-lettered. Errors wrapped with
+// Permanent skip the retries, and Transient ones are retried with jittered
+// backoff without counting against MaxRetries.
+//
+// At most Concurrency handlers run at once. With OrderKey set, messages that
+// share that header value are handled one at a time in delivery order, and a
+// message waiting on a retry holds back the ones queued behind it: they go
+// back to the queue until the retry is due, so they don't sit on leases.
+//
+// With Merge set, messages already waiting behind the one about to be handled
+// (same OrderKey) can be folded into it, so Handle sees one message for the
+// lot. They succeed, are retried or are dead-lettered together.
 type Worker struct {
-	Q          *Queue
-	Handle     func(context.Context, Message) error
-	MaxRetries int                             // default 3
-	Backoff    func(attempt int) time.Duration // default: exponential up to 10s
-	DLQ        *Queue                          // optional
+	Q           Queue
+	Handle      func(context.Context, Message) error
+	MaxRetries  int                             // default 3
+	Backoff     func(attempt int) time.Duration // default: exponential up to 10s
+	DLQ         Queue                           // optional
+	Clock       Clock                           // default RealClock; should match the queue's
+	Concurrency int                             // default 1
+	OrderKey    string                          // optional header, e.g. "minecraft_username"
+
+	// Ready, if set, is checked before each handler call; an error counts as
+	// transient so messages wait out an outage instead of burning attempts.
+	Ready            func(context.Context) error
+	TransientBackoff func(streak int) time.Duration // default: jittered exponential up to 30s
+
+	// Merge, if set, returns batch with next folded in, or false if next
+	// should wait for the following call. It needs OrderKey; Concurrency
+	// bounds how many messages are read ahead to merge.
+	Merge func(batch, next Message) (Message, bool)
+
+	stopConsume context.CancelFunc
+	done        chan struct{}
+	outage      atomic.Int32 // consecutive transient failures
 }
 
 func (w *Worker) setDefaults() {
 	if w.MaxRetries == 0 {
 		w.MaxRetries = 3
 	}
+	if w.Concurrency <= 0 {
+		w.Concurrency = 1
+	}
+	if w.TransientBackoff == nil {
+		w.TransientBackoff = func(streak int) time.Duration {
+			d := time.Duration(1<<int(min(streak-1, 5))) * time.Second // 1..32s
+			if d > 30*time.Second {
+				d = 30 * time.Second
+			}
+			// ±20% so a queue full of requeued messages doesn't stampede the bridge
+			return jitter(d, 0.2)
+		}
+	}
 	if w.Backoff == nil {
 		w.Backoff = func(attempt int) time.Duration {
 			if attempt < 1 {
@@ -115,48 +170,252 @@ func (w *Worker) setDefaults() {
 	}
 }
 
+// retryGrace is how long after its due time a key waits for its retry to be
+// delivered before giving up on it (it was purged, or a higher priority
+// message for the key keeps coming first).
+const retryGrace = time.Second
+
+// keyState is the per-key backlog for ordered handling.
+type keyState struct {
+	pending    []Delivery
+	running    bool
+	retryID    string    // message we nacked and are waiting to see again
+	retryAt    time.Time // when it becomes visible again
+	retryUntil time.Time // give up waiting after this
+}
+
+type result struct {
+	key     string
+	ds      []Delivery // what the handler was called for, in order
+	retry   bool
+	retryAt time.Time
+}
+
 func (w *Worker) Start(ctx context.Context) {
 	w.setDefaults()
-	msgs := w.Q.Consume(ctx)
-	go func() {
-		for {
-			select {
-			case m, ok := <-msgs:
+	cctx, cancel := context.WithCancel(ctx)
+	w.stopConsume = cancel
+	w.done = make(chan struct{})
+	go w.dispatch(ctx, cctx, w.Q.Consume(cctx))
+}
+
+// Stop stops taking new deliveries and waits for running handlers to finish
+// or for ctx to expire. Deliveries that were received but not started are
+// released back to the queue.
+func (w *Worker) Stop(ctx context.Context) error {
+	if w.stopConsume == nil {
+		return nil
+	}
+	w.stopConsume()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Worker) dispatch(ctx, cctx context.Context, deliveries <-chan Delivery) {
+	defer close(w.done)
+	clock := clockOrReal(w.Clock)
+	keys := make(map[string]*keyState)
+	results := make(chan result)
+	running, active := 0, 0 // active = running + pending
+
+	keyOf := func(d Delivery) string {
+		if w.OrderKey != "" {
+			if k, ok := d.Headers[w.OrderKey]; ok && k != "" {
+				return "k:" + k
+			}
+		}
+		return "id:" + d.ID // unordered: every message is its own key
+	}
+	blocked := func(ks *keyState) bool { return ks.retryID != "" }
+	// holdBack hands d back to the queue until its key's retry is due. It
+	// becomes visible together with the retry, which comes first by publish
+	// order, so nothing published after d can overtake it.
+	holdBack := func(ks *keyState, d Delivery) {
+		w.settle(d.q.requeue(context.Background(), d, ks.retryAt), d)
+	}
+
+	route := func(d Delivery) {
+		k := keyOf(d)
+		ks := keys[k]
+		if ks == nil {
+			ks = &keyState{}
+			keys[k] = ks
+		}
+		if blocked(ks) {
+			if d.ID != ks.retryID && !clock.Now().After(ks.retryUntil) {
+				holdBack(ks, d)
+				return
+			}
+			ks.retryID = ""
+		}
+		// A lease that expired while held here comes back with a new lease.
+		for i := range ks.pending {
+			if ks.pending[i].ID == d.ID {
+				ks.pending[i] = d
+				return
+			}
+		}
+		ks.pending = append(ks.pending, d)
+		active++
+	}
+
+	startReady := func() {
+		for k, ks := range keys {
+			if running >= w.Concurrency {
+				return
+			}
+			if ks.running || blocked(ks) || len(ks.pending) == 0 {
+				continue
+			}
+			ds, m := ks.pending[:1], ks.pending[0].Message
+			for w.Merge != nil && len(ds) < len(ks.pending) {
+				merged, ok := w.Merge(m, ks.pending[len(ds)].Message)
 				if !ok {
-					return
+					break
 				}
-				go w.process(ctx, m)
-			case <-ctx.Done():
-				return
+				ds, m = ks.pending[:len(ds)+1], merged
+			}
+			ks.pending = ks.pending[len(ds):]
+			ks.running = true
+			running++
+			active -= len(ds) - 1 // one running slot for the lot
+			go func(k string, ds []Delivery, m Message) {
+				retry, at := w.process(ctx, ds, m)
+				results <- result{key: k, ds: ds, retry: retry, retryAt: at}
+			}(k, ds, m)
+		}
+	}
+
+	finish := func(r result) {
+		running--
+		active--
+		ks := keys[r.key]
+		ks.running = false
+		if r.retry && w.OrderKey != "" {
+			ks.retryID = r.ds[0].ID
+			ks.retryAt = r.retryAt
+			ks.retryUntil = ks.retryAt.Add(retryGrace)
+			for _, d := range ks.pending {
+				holdBack(ks, d)
 			}
+			active -= len(ks.pending)
+			ks.pending = nil
 		}
-	}()
+		if !ks.running && len(ks.pending) == 0 && !blocked(ks) {
+			delete(keys, r.key)
+		}
+	}
+
+	for deliveries != nil || running > 0 {
+		in := deliveries
+		if active >= w.Concurrency {
+			in = nil
+		}
+		select {
+		case d, ok := <-in:
+			if !ok {
+				deliveries = nil
+				break
+			}
+			route(d)
+		case r := <-results:
+			finish(r)
+		case <-cctx.Done():
+			deliveries = nil
+		}
+		if deliveries == nil {
+			// stopping: hand back everything that hasn't started
+			for k, ks := range keys {
+				for _, d := range ks.pending {
+					_ = d.q.requeue(context.Background(), d, time.Time{})
+				}
+				ks.pending = nil
+				if !ks.running {
+					delete(keys, k)
+				}
+			}
+			continue
+		}
+		startReady()
+	}
 }
 
-func (w *Worker) process(ctx context.Context, m Message) {
-	attempt := m.Attempts
-	for {
-		cctx, cancel := context.WithCancel(ctx)
-		err := w.Handle(cctx, m)
-		cancel()
-		if err == nil {
-			return
+// process runs the handler once for m, which stands for ds, and settles
+// them. It reports whether they were nacked for another attempt, and when
+// they come back.
+func (w *Worker) process(ctx context.Context, ds []Delivery, m Message) (bool, time.Time) {
+	// Give up before the first lease does, so a slow handler isn't run twice
+	// and there is still time to settle the deliveries.
+	clock := clockOrReal(w.Clock)
+	deadline, attempts := ds[0].Deadline, 0
+	for _, d := range ds {
+		if d.Deadline.Before(deadline) {
+			deadline = d.Deadline
+		}
+		attempts = max(attempts, d.Attempts)
+	}
+	margin := deadline.Sub(clock.Now()) / 20
+	if margin > time.Second {
+		margin = time.Second
+	}
+	cctx, cancel := withDeadline(ctx, clock, deadline.Add(-margin))
+	var err error
+	if w.Ready != nil {
+		if rerr := w.Ready(cctx); rerr != nil {
+			err = Transient(rerr)
+		}
+	}
+	if err == nil {
+		err = w.Handle(cctx, m)
+	}
+	cancel()
+	if err == nil {
+		w.outage.Store(0)
+		for _, d := range ds {
+			w.settle(d.Ack(context.Background()), d)
 		}
-		attempt++
-		if attempt > w.MaxRetries {
+		return false, time.Time{}
+	}
+	for i := range ds {
+		ds[i].LastError = err.Error()
+	}
+	if IsTransient(err) && !IsPermanent(err) {
+		at := clock.Now().Add(w.TransientBackoff(int(w.outage.Add(1))))
+		for _, d := range ds {
+			w.settle(d.q.requeue(context.Background(), d, at), d)
+		}
+		return true, at
+	}
+	if IsPermanent(err) || attempts > w.MaxRetries {
+		for _, d := range ds {
 			if w.DLQ != nil {
-				_ = w.DLQ.Publish(context.Background(), Message{ID: m.ID, Body: m.Body, Attempts: attempt, Headers: m.Headers})
+				dead := Message{ID: d.ID, Body: d.Body, Attempts: d.Attempts, Headers: d.Headers, LastError: d.LastError, Priority: d.Priority}
+				if err := w.DLQ.Publish(context.Background(), dead); err != nil {
+					log.Printf("imq: dead-letter %s failed: %v", d.ID, err)
+				}
 			}
-			return
-		}
-		// retry after backoff
-		t := time.NewTimer(w.Backoff(attempt))
-		select {
-		case <-t.C:
-		case <-ctx.Done():
-			t.Stop()
-			return
+			w.settle(d.Ack(context.Background()), d)
 		}
+		return false, time.Time{}
+	}
+	// Shutting down: hand them straight back for the next run.
+	at := clock.Now()
+	if ctx.Err() == nil {
+		at = at.Add(w.Backoff(attempts))
+	}
+	for _, d := range ds {
+		w.settle(d.q.nack(context.Background(), d, at), d)
+	}
+	return true, at
+}
+
+func (w *Worker) settle(err error, d Delivery) {
+	if err != nil {
+		log.Printf("imq: settle %s (attempt %d) failed: %v", d.ID, d.Attempts, err)
 	}
 }
 
@@ -167,11 +426,26 @@ func min(a, b int) int {
 	return b
 }
 
-// Example wiring:
-//   q := imq.New(0)
-//   w := &imq.Worker{Q: q, Handle: func(ctx context.Context, m imq.Message) error {
-//       _, err := client.Send(ctx, m.Body)
-//       return err
-//   }}
-//   w.Start(ctx)
-//   _ = q.Publish(ctx, imq.Message{Body: []byte("/say hi")})
+// Example wiring, as the bot does for bridge commands:
+//
+//	q, _ := imq.NewSQLite(conn, "bridge")
+//	dlq, _ := imq.NewSQLite(conn, "bridge-dlq")
+//	w := &imq.Worker{
+//		Q:           q,
+//		DLQ:         dlq,
+//		Concurrency: 4,
+//		OrderKey:    "minecraft_uuid",
+//		Ready: func(context.Context) error {
+//			if !client.Status().Connected {
+//				return tcpbridge.ErrUnavailable
+//			}
+//			return nil
+//		},
+//		Handle: func(ctx context.Context, m imq.Message) error {
+//			_, err := client.Send(ctx, m.Body)
+//			return err // wrap with imq.Permanent to skip the retries
+//		},
+//	}
+//	w.Start(ctx)
+//	defer w.Stop(context.Background())
+//	_ = q.Publish(ctx, imq.Message{ID: "say-1", Body: []byte("say hi"), Priority: 10})
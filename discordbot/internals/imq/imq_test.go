@@ -0,0 +1,294 @@
+package imq
+
+import (
+	"context"
+	"database/sql"
+	"limpan/rotaria-bot/internals/db"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+	fired bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.fired = true
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock on and fires every timer that has come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			live = append(live, t)
+			continue
+		}
+		t.fired = true
+		t.c <- c.now
+	}
+	c.timers = live
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, o := range c.timers {
+		if o == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	return !t.fired
+}
+
+type testQueue interface {
+	Queue
+	Inspector
+}
+
+// queueKinds opens each Queue implementation on clock with the given
+// visibility timeout and dedupe window.
+var queueKinds = []struct {
+	name string
+	open func(t *testing.T, clock Clock, vt, dedupe time.Duration) testQueue
+}{
+	{"mem", func(t *testing.T, clock Clock, vt, dedupe time.Duration) testQueue {
+		q := New(0)
+		q.Clock, q.VisibilityTimeout, q.DedupeWindow = clock, vt, dedupe
+		t.Cleanup(q.Close)
+		return q
+	}},
+	{"sqlite", func(t *testing.T, clock Clock, vt, dedupe time.Duration) testQueue {
+		q, err := NewSQLite(openTestDB(t), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Clock, q.VisibilityTimeout, q.DedupeWindow = clock, vt, dedupe
+		q.PollInterval = 10 * time.Millisecond
+		t.Cleanup(q.Close)
+		return q
+	}},
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	s, err := db.OpenStore(context.Background(), filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.Conn
+}
+
+// receive takes one value, failing the test after a few seconds.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	var v T
+	select {
+	case got, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		v = got
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting")
+	}
+	return v
+}
+
+// leaseOne leases the next message and stops consuming, so nothing else is
+// leased behind the test's back.
+func leaseOne(t *testing.T, q Queue) Delivery {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := q.Consume(ctx)
+	d := receive(t, ch)
+	cancel()
+	for range ch {
+	}
+	return d
+}
+
+func TestScheduledPublishOnClock(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			clock := newFakeClock()
+			q := kind.open(t, clock, 24*time.Hour, 0) // no lease runs out while stepping
+			if err := q.PublishAt(ctx, Message{ID: "at"}, clock.Now().Add(2*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.PublishAfter(ctx, Message{ID: "after"}, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.PublishAt(ctx, Message{ID: "past"}, clock.Now().Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			ch := q.Consume(ctx)
+			past := receive(t, ch)
+			if past.ID != "past" {
+				t.Fatalf("first delivery = %s, want the one already due", past.ID)
+			}
+			if err := past.Ack(ctx); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case d := <-ch:
+				t.Fatalf("%s delivered before the clock reached it", d.ID)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			start := clock.Now()
+			for _, want := range []struct {
+				id    string
+				after time.Duration
+			}{{"after", time.Hour}, {"at", 2 * time.Hour}} {
+				d := advanceUntil(t, clock, 10*time.Minute, ch)
+				if d.ID != want.id {
+					t.Fatalf("delivered %s, want %s", d.ID, want.id)
+				}
+				if waited := clock.Now().Sub(start); waited < want.after {
+					t.Fatalf("%s delivered after %v, want %v", d.ID, waited, want.after)
+				}
+				if err := d.Ack(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestExpiredLeaseCountsAsQueued(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := newFakeClock()
+			q := kind.open(t, clock, time.Minute, 0)
+			if err := q.Publish(ctx, Message{ID: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			leaseOne(t, q)
+			if n := q.Len(); n != 0 {
+				t.Fatalf("Len with a live lease = %d, want 0", n)
+			}
+			if n, err := q.Purge(ctx); err != nil || n != 0 {
+				t.Fatalf("Purge with a live lease = %d, %v; want 0", n, err)
+			}
+
+			clock.Advance(2 * time.Minute)
+			if n := q.Len(); n != 1 {
+				t.Fatalf("Len after the lease expired = %d, want 1", n)
+			}
+			if n, err := q.Purge(ctx); err != nil || n != 1 {
+				t.Fatalf("Purge after the lease expired = %d, %v; want 1", n, err)
+			}
+			if n := q.Len(); n != 0 {
+				t.Fatalf("Len after Purge = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestPriorityBeforePublishOrder(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			q := kind.open(t, newFakeClock(), time.Minute, 0)
+			for _, m := range []Message{
+				{ID: "low-1"}, {ID: "high-1", Priority: 10}, {ID: "low-2"},
+				{ID: "mid-1", Priority: 5}, {ID: "high-2", Priority: 10}, {ID: "low-3"},
+			} {
+				if err := q.Publish(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ch := q.Consume(ctx)
+			for _, want := range []string{"high-1", "high-2", "mid-1", "low-1", "low-2", "low-3"} {
+				if d := receive(t, ch); d.ID != want {
+					t.Fatalf("got %s, want %s", d.ID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupeWindowSuppressesRepublish(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := newFakeClock()
+			q := kind.open(t, clock, time.Minute, time.Hour)
+			publish := func(id string) {
+				t.Helper()
+				if err := q.Publish(ctx, Message{ID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// An ID that is still queued is never added twice.
+			publish("a")
+			publish("a")
+			if n := q.Len(); n != 1 {
+				t.Fatalf("Len after publishing a pending ID again = %d, want 1", n)
+			}
+
+			if err := leaseOne(t, q).Ack(ctx); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(59 * time.Minute)
+			publish("a")
+			if n := q.Len(); n != 0 {
+				t.Fatalf("Len after republishing inside the window = %d, want 0", n)
+			}
+			publish("b")
+			if n := q.Len(); n != 1 {
+				t.Fatalf("Len after publishing a new ID = %d, want 1", n)
+			}
+
+			clock.Advance(2 * time.Minute)
+			publish("a")
+			if n := q.Len(); n != 2 {
+				t.Fatalf("Len after republishing once the window passed = %d, want 2", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package imq
+
+import (
+	"container/heap"
+	"context"
+	"limpan/rotaria-bot/internals/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memItem struct {
+	seq   uint64
+	msg   Message
+	lease string // non-empty while in flight
+	gen   uint64 // bumped on every state change; stale timers are skipped
+}
+
+// readyHeap orders visible messages by priority, then publish order.
+type readyHeap []*memItem
+
+func (h readyHeap) Len() int { return len(h) }
+func (h readyHeap) Less(i, j int) bool {
+	if h[i].msg.Priority != h[j].msg.Priority {
+		return h[i].msg.Priority > h[j].msg.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h readyHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *readyHeap) Push(x any)   { *h = append(*h, x.(*memItem)) }
+func (h *readyHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
+
+// timedEntry makes an item visible at a point in time: scheduled publishes,
+// delayed nacks and lease expiry all go through the same min-heap.
+type timedEntry struct {
+	at  time.Time
+	it  *memItem
+	gen uint64
+}
+
+type timedHeap []timedEntry
+
+func (h timedHeap) Len() int { return len(h) }
+func (h timedHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].it.seq < h[j].it.seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h timedHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timedHeap) Push(x any)   { *h = append(*h, x.(timedEntry)) }
+func (h *timedHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// MemQueue is a minimal in-memory queue. Messages are handed out by priority,
+// then in publish order, and leased until acked, nacked or their visibility
+// timeout expires.
+// It is intentionally simple so you can later swap it for NATS/RabbitMQ/etc.
+type MemQueue struct {
+	VisibilityTimeout time.Duration // default DefaultVisibilityTimeout
+	Clock             Clock         // default RealClock
+	DedupeWindow      time.Duration // how long acked IDs are remembered; 0 = not at all
+
+	mu       sync.Mutex
+	capacity int
+	seq      uint64
+	items    map[string]*memItem // every message not yet acked, by ID
+	leased   int
+	ready    readyHeap
+	timed    timedHeap
+	closed   bool
+	done     chan struct{}
+	notify   chan struct{}        // something became visible or was scheduled
+	space    chan struct{}        // something was acked
+	recent   map[string]time.Time // acked ID -> forget after
+	expiry   []dedupeEntry        // recent in ack order, for pruning
+}
+
+type dedupeEntry struct {
+	id    string
+	until time.Time
+}
+
+func New(capacity int) *MemQueue {
+	if capacity <= 0 {
+		capacity = 1024
+	}
+	return &MemQueue{
+		capacity: capacity,
+		items:    make(map[string]*memItem),
+		recent:   make(map[string]time.Time),
+		done:     make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (q *MemQueue) Publish(ctx context.Context, m Message) error {
+	return q.PublishAt(ctx, m, time.Time{})
+}
+
+func (q *MemQueue) PublishAfter(ctx context.Context, m Message, d time.Duration) error {
+	return q.PublishAt(ctx, m, clockOrReal(q.Clock).Now().Add(d))
+}
+
+// PublishAt enqueues m so that it is not delivered before at. A zero or past
+// time means immediately.
+func (q *MemQueue) PublishAt(ctx context.Context, m Message, at time.Time) error {
+	if m.ID == "" {
+		m.ID = utils.NewID()
+	}
+	m.EnqueuedAt = clockOrReal(q.Clock).Now()
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if q.duplicate(m.ID, m.EnqueuedAt) {
+			q.mu.Unlock()
+			return nil
+		}
+		if len(q.items) < q.capacity {
+			q.seq++
+			it := &memItem{seq: q.seq, msg: m}
+			q.items[m.ID] = it
+			q.schedule(it, at, m.EnqueuedAt)
+			q.mu.Unlock()
+			signal(q.notify)
+			return nil
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.space:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.done:
+			return ErrClosed
+		}
+	}
+}
+
+// duplicate reports whether id is pending or was acked within DedupeWindow.
+// Caller holds q.mu.
+func (q *MemQueue) duplicate(id string, now time.Time) bool {
+	if _, ok := q.items[id]; ok {
+		return true
+	}
+	for len(q.expiry) > 0 && !q.expiry[0].until.After(now) {
+		e := q.expiry[0]
+		q.expiry = q.expiry[1:]
+		if q.recent[e.id].Equal(e.until) {
+			delete(q.recent, e.id)
+		}
+	}
+	until, ok := q.recent[id]
+	return ok && until.After(now)
+}
+
+// schedule makes it visible at at, or right away if that has passed.
+func (q *MemQueue) schedule(it *memItem, at, now time.Time) {
+	it.gen++
+	if at.After(now) {
+		heap.Push(&q.timed, timedEntry{at: at, it: it, gen: it.gen})
+		return
+	}
+	heap.Push(&q.ready, it)
+}
+
+// promote moves everything due by now to the ready heap, releasing expired
+// leases on the way. Caller holds q.mu.
+func (q *MemQueue) promote(now time.Time) {
+	for len(q.timed) > 0 && !q.timed[0].at.After(now) {
+		e := heap.Pop(&q.timed).(timedEntry)
+		if e.gen != e.it.gen {
+			continue // acked, nacked or rescheduled since
+		}
+		if e.it.lease != "" {
+			e.it.lease = "" // visibility timeout expired
+			q.leased--
+		}
+		heap.Push(&q.ready, e.it)
+	}
+}
+
+// next leases the first visible message, or reports when to look again.
+func (q *MemQueue) next(now time.Time) (d Delivery, ok bool, wake time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.promote(now)
+	if len(q.ready) == 0 {
+		wake = -1
+		if len(q.timed) > 0 {
+			wake = q.timed[0].at.Sub(now)
+		}
+		return Delivery{}, false, wake
+	}
+
+	vt := q.VisibilityTimeout
+	if vt <= 0 {
+		vt = DefaultVisibilityTimeout
+	}
+	it := heap.Pop(&q.ready).(*memItem)
+	it.lease = utils.NewID()
+	it.msg.Attempts++
+	q.leased++
+	q.schedule(it, now.Add(vt), now)
+	return Delivery{Message: it.msg, Deadline: now.Add(vt), lease: it.lease, q: q}, true, 0
+}
+
+// Consume returns a receive-only channel that will close when ctx is done or the queue is closed.
+func (q *MemQueue) Consume(ctx context.Context) <-chan Delivery {
+	out := make(chan Delivery)
+	clock := clockOrReal(q.Clock)
+	go func() {
+		defer close(out)
+		for {
+			d, ok, wake := q.next(clock.Now())
+			if ok {
+				select {
+				case out <- d:
+					continue
+				case <-ctx.Done():
+					_ = q.requeue(context.Background(), d, time.Time{})
+					return
+				case <-q.done:
+					return
+				}
+			}
+			if !q.wait(ctx, clock, wake) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// wait blocks until something may have become visible. wake < 0 means no
+// timed wake-up is needed.
+func (q *MemQueue) wait(ctx context.Context, clock Clock, wake time.Duration) bool {
+	var timer <-chan time.Time
+	if wake >= 0 {
+		t := clock.NewTimer(wake)
+		defer t.Stop()
+		timer = t.C()
+	}
+	select {
+	case <-q.notify:
+	case <-timer:
+	case <-ctx.Done():
+		return false
+	case <-q.done:
+		return false
+	}
+	return true
+}
+
+// leasedItem returns the item if d still holds its lease. Caller holds q.mu.
+func (q *MemQueue) leasedItem(d Delivery) *memItem {
+	it, ok := q.items[d.ID]
+	if !ok || it.lease == "" || it.lease != d.lease {
+		return nil
+	}
+	return it
+}
+
+func (q *MemQueue) ack(_ context.Context, d Delivery) error {
+	q.mu.Lock()
+	it := q.leasedItem(d)
+	if it == nil {
+		q.mu.Unlock()
+		return ErrLeaseExpired
+	}
+	it.gen++
+	q.leased--
+	delete(q.items, d.ID)
+	if q.DedupeWindow > 0 {
+		until := clockOrReal(q.Clock).Now().Add(q.DedupeWindow)
+		q.recent[d.ID] = until
+		q.expiry = append(q.expiry, dedupeEntry{d.ID, until})
+	}
+	q.mu.Unlock()
+	signal(q.space)
+	return nil
+}
+
+func (q *MemQueue) nack(_ context.Context, d Delivery, at time.Time) error {
+	q.mu.Lock()
+	it := q.leasedItem(d)
+	if it == nil {
+		q.mu.Unlock()
+		return ErrLeaseExpired
+	}
+	it.lease = ""
+	it.msg.LastError = d.LastError
+	q.leased--
+	q.schedule(it, at, q.now())
+	q.mu.Unlock()
+	signal(q.notify)
+	return nil
+}
+
+// requeue is nack without counting the delivery as an attempt: used for
+// deliveries that never reached a handler and for transient failures.
+func (q *MemQueue) requeue(_ context.Context, d Delivery, at time.Time) error {
+	q.mu.Lock()
+	it := q.leasedItem(d)
+	if it == nil {
+		q.mu.Unlock()
+		return ErrLeaseExpired
+	}
+	it.lease = ""
+	it.msg.Attempts--
+	it.msg.LastError = d.LastError
+	q.leased--
+	q.schedule(it, at, q.now())
+	q.mu.Unlock()
+	signal(q.notify)
+	return nil
+}
+
+func (q *MemQueue) now() time.Time { return clockOrReal(q.Clock).Now() }
+
+func (q *MemQueue) extend(_ context.Context, d Delivery, by time.Duration) (time.Time, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	it := q.leasedItem(d)
+	if it == nil {
+		return time.Time{}, ErrLeaseExpired
+	}
+	now := clockOrReal(q.Clock).Now()
+	q.schedule(it, now.Add(by), now) // the old expiry is skipped as stale
+	return now.Add(by), nil
+}
+
+func (q *MemQueue) Peek(_ context.Context, limit int) ([]Message, error) {
+	q.mu.Lock()
+	its := make([]memItem, 0, len(q.items))
+	for _, it := range q.items {
+		its = append(its, memItem{seq: it.seq, msg: it.msg})
+	}
+	q.mu.Unlock()
+	sort.Slice(its, func(i, j int) bool { return its[i].seq < its[j].seq })
+	if limit > 0 && len(its) > limit {
+		its = its[:limit]
+	}
+	out := make([]Message, len(its))
+	for i, it := range its {
+		out[i] = it.msg
+	}
+	return out, nil
+}
+
+func (q *MemQueue) Take(_ context.Context, id string) (Message, error) {
+	q.mu.Lock()
+	it, ok := q.items[id]
+	if !ok {
+		q.mu.Unlock()
+		return Message{}, ErrNotFound
+	}
+	q.remove(it)
+	q.mu.Unlock()
+	signal(q.space)
+	return it.msg, nil
+}
+
+func (q *MemQueue) Purge(_ context.Context) (int, error) {
+	q.mu.Lock()
+	q.promote(clockOrReal(q.Clock).Now())
+	n := 0
+	for _, it := range q.items {
+		if it.lease == "" {
+			q.remove(it)
+			n++
+		}
+	}
+	q.mu.Unlock()
+	signal(q.space)
+	return n, nil
+}
+
+// remove drops it from the queue; heap entries are skipped lazily. Caller holds q.mu.
+func (q *MemQueue) remove(it *memItem) {
+	it.gen++
+	if it.lease != "" {
+		it.lease = ""
+		q.leased--
+	}
+	delete(q.items, it.msg.ID)
+	for i, r := range q.ready {
+		if r == it {
+			heap.Remove(&q.ready, i)
+			break
+		}
+	}
+}
+
+func (q *MemQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	q.mu.Unlock()
+}
+
+// Len reports messages that are not currently leased, including scheduled ones.
+func (q *MemQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.promote(clockOrReal(q.Clock).Now())
+	return len(q.items) - q.leased
+}
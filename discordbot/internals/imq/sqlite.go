@@ -0,0 +1,391 @@
+package imq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/internals/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+// SQLiteQueue is a durable Queue stored in the bot's SQLite database. Several
+// named queues share one table. A message stays in the table until it is
+// acked; a lease that is never settled (crash, kill -9) simply expires and the
+// message is handed out again.
+type SQLiteQueue struct {
+	db   *sql.DB
+	name string
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	notify chan struct{}
+
+	PollInterval      time.Duration // fallback poll when no local Publish wakes us, default 1s
+	VisibilityTimeout time.Duration // default DefaultVisibilityTimeout
+	Clock             Clock         // default RealClock
+	DedupeWindow      time.Duration // how long acked IDs are remembered; 0 = not at all
+}
+
+// NewSQLite opens the queue called name on db. The tables come from the
+// bot's migrations (db.OpenStore); NewSQLite only checks that they are there.
+func NewSQLite(db *sql.DB, name string) (*SQLiteQueue, error) {
+	if db == nil {
+		return nil, sql.ErrConnDone
+	}
+	if _, err := db.Exec(`SELECT seq, priority, last_error FROM imq_messages LIMIT 0`); err != nil {
+		return nil, fmt.Errorf("imq: queue tables missing, open the database with db.OpenStore: %w", err)
+	}
+	return &SQLiteQueue{
+		db:                db,
+		name:              name,
+		done:              make(chan struct{}),
+		notify:            make(chan struct{}, 1),
+		PollInterval:      time.Second,
+		VisibilityTimeout: DefaultVisibilityTimeout,
+	}, nil
+}
+
+func (q *SQLiteQueue) Name() string { return q.name }
+
+func (q *SQLiteQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *SQLiteQueue) Publish(ctx context.Context, m Message) error {
+	return q.PublishAt(ctx, m, time.Time{})
+}
+
+func (q *SQLiteQueue) PublishAfter(ctx context.Context, m Message, d time.Duration) error {
+	return q.PublishAt(ctx, m, clockOrReal(q.Clock).Now().Add(d))
+}
+
+// PublishAt stores m with visible_at = at, so it survives restarts while it
+// waits. A zero or past time means immediately. It does nothing if m.ID is
+// already queued or was acked within DedupeWindow.
+func (q *SQLiteQueue) PublishAt(ctx context.Context, m Message, at time.Time) error {
+	if q.isClosed() {
+		return ErrClosed
+	}
+	if m.ID == "" {
+		m.ID = utils.NewID()
+	}
+	m.EnqueuedAt = clockOrReal(q.Clock).Now()
+	var visible int64
+	if at.After(m.EnqueuedAt) {
+		visible = at.UnixNano()
+	}
+	headers, err := json.Marshal(m.Headers)
+	if err != nil {
+		return err
+	}
+	// One statement, so two publishers racing on the same ID can't both insert.
+	res, err := q.db.ExecContext(ctx,
+		`INSERT INTO imq_messages (queue, id, body, headers, attempts, enqueued_at, visible_at, last_error, priority)
+		 SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?
+		 WHERE NOT EXISTS (SELECT 1 FROM imq_messages WHERE queue = ? AND id = ?)
+		   AND NOT EXISTS (SELECT 1 FROM imq_dedupe WHERE queue = ? AND id = ? AND expires_at > ?)`,
+		q.name, m.ID, m.Body, string(headers), m.Attempts, m.EnqueuedAt.UnixNano(), visible, nullString(m.LastError), m.Priority,
+		q.name, m.ID,
+		q.name, m.ID, m.EnqueuedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		signal(q.notify)
+	}
+	return nil
+}
+
+// lease marks the oldest visible message as in flight and returns it.
+func (q *SQLiteQueue) lease(ctx context.Context) (*Delivery, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		seq int64
+		d   Delivery
+	)
+	now := clockOrReal(q.Clock).Now()
+	row := tx.QueryRowContext(ctx,
+		`SELECT `+messageColumns+` FROM imq_messages
+		 WHERE queue = ? AND visible_at <= ? ORDER BY priority DESC, seq LIMIT 1`, q.name, now.UnixNano())
+	d.Message, seq, err = scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vt := q.VisibilityTimeout
+	if vt <= 0 {
+		vt = DefaultVisibilityTimeout
+	}
+	d.Attempts++
+	d.Deadline = now.Add(vt)
+	d.lease = utils.NewID()
+	d.q = q
+	_, err = tx.ExecContext(ctx, `UPDATE imq_messages SET visible_at = ?, lease = ?, attempts = ? WHERE seq = ?`,
+		d.Deadline.UnixNano(), d.lease, d.Attempts, seq)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+const messageColumns = `seq, id, body, headers, attempts, enqueued_at, last_error, priority`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row scanner) (Message, int64, error) {
+	var (
+		m          Message
+		seq        int64
+		headers    sql.NullString
+		enqueuedAt int64
+		lastError  sql.NullString
+	)
+	if err := row.Scan(&seq, &m.ID, &m.Body, &headers, &m.Attempts, &enqueuedAt, &lastError, &m.Priority); err != nil {
+		return Message{}, 0, err
+	}
+	m.EnqueuedAt = time.Unix(0, enqueuedAt)
+	m.LastError = lastError.String
+	if headers.Valid && headers.String != "" {
+		_ = json.Unmarshal([]byte(headers.String), &m.Headers)
+	}
+	return m, seq, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nextDue reports how long until the next scheduled or leased message
+// becomes visible, capped at PollInterval so other writers are noticed.
+func (q *SQLiteQueue) nextDue(ctx context.Context, now time.Time) time.Duration {
+	wait := q.PollInterval
+	if wait <= 0 {
+		wait = time.Second
+	}
+	var due sql.NullInt64
+	err := q.db.QueryRowContext(ctx, `SELECT MIN(visible_at) FROM imq_messages WHERE queue = ? AND visible_at > ?`,
+		q.name, now.UnixNano()).Scan(&due)
+	if err == nil && due.Valid {
+		if d := time.Unix(0, due.Int64).Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Consume returns a receive-only channel that will close when ctx is done or the queue is closed.
+func (q *SQLiteQueue) Consume(ctx context.Context) <-chan Delivery {
+	out := make(chan Delivery)
+	clock := clockOrReal(q.Clock)
+	go func() {
+		defer close(out)
+		for {
+			d, err := q.lease(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("imq: %s: lease failed: %v", q.name, err)
+			}
+			if d != nil {
+				select {
+				case out <- *d:
+					continue
+				case <-ctx.Done():
+					_ = q.requeue(context.Background(), *d, time.Time{})
+					return
+				case <-q.done:
+					_ = q.requeue(context.Background(), *d, time.Time{})
+					return
+				}
+			}
+			t := clock.NewTimer(q.nextDue(ctx, clock.Now()))
+			select {
+			case <-q.notify:
+			case <-t.C():
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-q.done:
+				t.Stop()
+				return
+			}
+			t.Stop()
+		}
+	}()
+	return out
+}
+
+func (q *SQLiteQueue) ack(ctx context.Context, d Delivery) error {
+	if q.DedupeWindow <= 0 {
+		res, err := q.db.ExecContext(ctx, `DELETE FROM imq_messages WHERE queue = ? AND id = ? AND lease = ?`, q.name, d.ID, d.lease)
+		return leaseResult(res, err)
+	}
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM imq_messages WHERE queue = ? AND id = ? AND lease = ?`, q.name, d.ID, d.lease)
+	if err := leaseResult(res, err); err != nil {
+		return err
+	}
+	now := clockOrReal(q.Clock).Now()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM imq_dedupe WHERE queue = ? AND expires_at <= ?`, q.name, now.UnixNano()); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO imq_dedupe (queue, id, expires_at) VALUES (?, ?, ?)`,
+		q.name, d.ID, now.Add(q.DedupeWindow).UnixNano())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (q *SQLiteQueue) nack(ctx context.Context, d Delivery, at time.Time) error {
+	visible := q.visibleAt(at)
+	res, err := q.db.ExecContext(ctx, `UPDATE imq_messages SET visible_at = ?, lease = NULL, last_error = ? WHERE queue = ? AND id = ? AND lease = ?`,
+		visible, nullString(d.LastError), q.name, d.ID, d.lease)
+	if err == nil {
+		signal(q.notify)
+	}
+	return leaseResult(res, err)
+}
+
+// requeue is nack without counting the delivery as an attempt: used for
+// deliveries that never reached a handler and for transient failures.
+func (q *SQLiteQueue) requeue(ctx context.Context, d Delivery, at time.Time) error {
+	visible := q.visibleAt(at)
+	res, err := q.db.ExecContext(ctx,
+		`UPDATE imq_messages SET visible_at = ?, lease = NULL, attempts = attempts - 1, last_error = ?
+		 WHERE queue = ? AND id = ? AND lease = ?`,
+		visible, nullString(d.LastError), q.name, d.ID, d.lease)
+	if err == nil {
+		signal(q.notify)
+	}
+	return leaseResult(res, err)
+}
+
+// visibleAt is the visible_at value for a message due at at: 0 if it is
+// due already.
+func (q *SQLiteQueue) visibleAt(at time.Time) int64 {
+	if at.After(q.now()) {
+		return at.UnixNano()
+	}
+	return 0
+}
+
+func (q *SQLiteQueue) now() time.Time { return clockOrReal(q.Clock).Now() }
+
+func (q *SQLiteQueue) extend(ctx context.Context, d Delivery, by time.Duration) (time.Time, error) {
+	deadline := clockOrReal(q.Clock).Now().Add(by)
+	res, err := q.db.ExecContext(ctx, `UPDATE imq_messages SET visible_at = ? WHERE queue = ? AND id = ? AND lease = ?`,
+		deadline.UnixNano(), q.name, d.ID, d.lease)
+	if err := leaseResult(res, err); err != nil {
+		return time.Time{}, err
+	}
+	return deadline, nil
+}
+
+func leaseResult(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// notLeased matches messages with no lease or one that has run out; a lease
+// keeps its deadline in visible_at.
+const notLeased = `(lease IS NULL OR visible_at <= ?)`
+
+// Len reports messages that are not currently leased.
+func (q *SQLiteQueue) Len() int {
+	var n int
+	err := q.db.QueryRow(`SELECT COUNT(*) FROM imq_messages WHERE queue = ? AND `+notLeased,
+		q.name, clockOrReal(q.Clock).Now().UnixNano()).Scan(&n)
+	if err != nil {
+		log.Printf("imq: %s: len failed: %v", q.name, err)
+	}
+	return n
+}
+
+func (q *SQLiteQueue) Peek(ctx context.Context, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.db.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM imq_messages WHERE queue = ? ORDER BY seq LIMIT ?`, q.name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Message
+	for rows.Next() {
+		m, _, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (q *SQLiteQueue) Take(ctx context.Context, id string) (Message, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+	m, seq, err := scanMessage(tx.QueryRowContext(ctx,
+		`SELECT `+messageColumns+` FROM imq_messages WHERE queue = ? AND id = ? ORDER BY seq LIMIT 1`, q.name, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrNotFound
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM imq_messages WHERE seq = ?`, seq); err != nil {
+		return Message{}, err
+	}
+	return m, tx.Commit()
+}
+
+func (q *SQLiteQueue) Purge(ctx context.Context) (int, error) {
+	res, err := q.db.ExecContext(ctx, `DELETE FROM imq_messages WHERE queue = ? AND `+notLeased,
+		q.name, clockOrReal(q.Clock).Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// Close stops consumers. Stored messages are kept for the next run; the
+// underlying database is owned by the caller.
+func (q *SQLiteQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	q.mu.Unlock()
+}
@@ -0,0 +1,439 @@
+package imq
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// advanceUntil steps clock until ch yields, giving the queue's goroutines a
+// moment to react between steps.
+func advanceUntil[T any](t *testing.T, clock *fakeClock, step time.Duration, ch <-chan T) T {
+	t.Helper()
+	for i := 0; i < 2000; i++ {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(2 * time.Millisecond):
+			clock.Advance(step)
+		}
+	}
+	t.Fatal("nothing happened after advancing the clock")
+	var zero T
+	return zero
+}
+
+func startWorker(t *testing.T, w *Worker) {
+	w.Start(context.Background())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := w.Stop(ctx); err != nil {
+			t.Errorf("stop worker: %v", err)
+		}
+	})
+}
+
+func TestLeaseExpiresOnClock(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			clock := newFakeClock()
+			q := kind.open(t, clock, time.Minute, 0)
+			if err := q.Publish(ctx, Message{ID: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			ch := q.Consume(ctx)
+			first := receive(t, ch)
+			if want := clock.Now().Add(time.Minute); !first.Deadline.Equal(want) {
+				t.Fatalf("deadline = %v, want %v", first.Deadline, want)
+			}
+
+			start := clock.Now()
+			second := advanceUntil(t, clock, 5*time.Second, ch)
+			if waited := clock.Now().Sub(start); waited < time.Minute {
+				t.Fatalf("redelivered after %v, before the lease ran out", waited)
+			}
+			if second.ID != "a" || second.Attempts != 2 {
+				t.Fatalf("redelivery = %s attempt %d, want a attempt 2", second.ID, second.Attempts)
+			}
+			if err := first.Ack(ctx); !errors.Is(err, ErrLeaseExpired) {
+				t.Fatalf("ack of the expired lease = %v, want ErrLeaseExpired", err)
+			}
+			if err := second.Ack(ctx); err != nil {
+				t.Fatalf("ack of the new lease: %v", err)
+			}
+		})
+	}
+}
+
+func TestWorkerHandlerDeadlineFollowsClock(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			clock := newFakeClock()
+			q := kind.open(t, clock, time.Minute, 0)
+			causes := make(chan error, 4)
+			attempts := make(chan int, 4)
+			startWorker(t, &Worker{
+				Q:     q,
+				Clock: clock,
+				Handle: func(ctx context.Context, m Message) error {
+					if m.Attempts > 1 {
+						attempts <- m.Attempts
+						return nil
+					}
+					<-ctx.Done()
+					causes <- context.Cause(ctx)
+					return ctx.Err()
+				},
+			})
+			if err := q.Publish(context.Background(), Message{ID: "slow"}); err != nil {
+				t.Fatal(err)
+			}
+			start := clock.Now()
+			cause := advanceUntil(t, clock, time.Second, causes)
+			if !errors.Is(cause, context.DeadlineExceeded) {
+				t.Fatalf("handler context ended with %v, want DeadlineExceeded", cause)
+			}
+			// Cancelled a little before the lease runs out, so the nack
+			// still holds the lease.
+			if waited := clock.Now().Sub(start); waited < 50*time.Second || waited >= time.Minute {
+				t.Fatalf("handler cancelled after %v, want just under the 1m lease", waited)
+			}
+			// The nack held the lease, so the retry is attempt 2 after the
+			// worker's backoff rather than a redelivery of an expired lease.
+			if n := advanceUntil(t, clock, time.Second, attempts); n != 2 {
+				t.Fatalf("retry was attempt %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestWorkerRetriesOnClock(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			clock := newFakeClock()
+			// The lease outlasts the steps advanceUntil takes, so only the
+			// backoff decides when the retry comes.
+			q := kind.open(t, clock, time.Hour, 0)
+			calls := make(chan int, 4)
+			startWorker(t, &Worker{
+				Q:       q,
+				Clock:   clock,
+				Backoff: func(int) time.Duration { return 10 * time.Minute },
+				Handle: func(ctx context.Context, m Message) error {
+					calls <- m.Attempts
+					if m.Attempts == 1 {
+						return errors.New("try again")
+					}
+					return nil
+				},
+			})
+			if err := q.Publish(context.Background(), Message{ID: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			if n := receive(t, (<-chan int)(calls)); n != 1 {
+				t.Fatalf("first call was attempt %d", n)
+			}
+
+			// Without the clock moving the retry never comes.
+			select {
+			case n := <-calls:
+				t.Fatalf("attempt %d ran before the backoff", n)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			start := clock.Now()
+			if n := advanceUntil(t, clock, 30*time.Second, calls); n != 2 {
+				t.Fatalf("retry was attempt %d, want 2", n)
+			}
+			if waited := clock.Now().Sub(start); waited < 10*time.Minute {
+				t.Fatalf("retried after %v, want the 10m backoff", waited)
+			}
+			for deadline := time.Now().Add(5 * time.Second); q.Len() != 0; time.Sleep(5 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("queue still holds %d messages after the retry succeeded", q.Len())
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerOrderKeyKeepsFIFO(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			q := kind.open(t, RealClock, time.Minute, 0)
+			const perKey = 15
+			keys := []string{"alex", "steve", "notch", "jeb"}
+
+			var (
+				mu          sync.Mutex
+				seen        = make(map[string][]int)
+				running     = make(map[string]int)
+				inFlight    int
+				maxInFlight int
+				failed      bool
+				done        = make(chan struct{})
+				total       int
+			)
+			startWorker(t, &Worker{
+				Q:           q,
+				Concurrency: 4,
+				OrderKey:    "player",
+				Backoff:     func(int) time.Duration { return 20 * time.Millisecond },
+				Handle: func(ctx context.Context, m Message) error {
+					key := m.Headers["player"]
+					n, _ := strconv.Atoi(m.Headers["n"])
+					mu.Lock()
+					running[key]++
+					inFlight++
+					if running[key] > 1 {
+						t.Errorf("%s: %d handlers running at once", key, running[key])
+					}
+					maxInFlight = max(maxInFlight, inFlight)
+					// One failure part way through: the messages behind it
+					// must wait for its retry.
+					fail := key == "steve" && n == 5 && !failed
+					failed = failed || fail
+					mu.Unlock()
+
+					time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+
+					mu.Lock()
+					defer mu.Unlock()
+					running[key]--
+					inFlight--
+					if fail {
+						return errors.New("try again")
+					}
+					seen[key] = append(seen[key], n)
+					if total++; total == perKey*len(keys) {
+						close(done)
+					}
+					return nil
+				},
+			})
+
+			ctx := context.Background()
+			for n := 0; n < perKey; n++ {
+				for _, key := range keys {
+					err := q.Publish(ctx, Message{Headers: map[string]string{"player": key, "n": strconv.Itoa(n)}})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				mu.Lock()
+				defer mu.Unlock()
+				t.Fatalf("only %d of %d messages handled", total, perKey*len(keys))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, key := range keys {
+				for i, n := range seen[key] {
+					if n != i {
+						t.Fatalf("%s handled in order %v", key, seen[key])
+					}
+				}
+			}
+			if maxInFlight < 2 {
+				t.Errorf("at most %d handlers ran at once; want different keys in parallel", maxInFlight)
+			}
+		})
+	}
+}
+
+func TestWorkerHoldsBackBlockedKeyWithoutLeasing(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			// Leases much shorter than the retry backoff: messages held
+			// behind the retry must not sit on leases while they wait.
+			q := kind.open(t, RealClock, 200*time.Millisecond, 0)
+			const followers = 100
+
+			var (
+				mu       sync.Mutex
+				steve    []Message
+				alexDone bool
+				early    bool // alex was handled before steve's retry
+				done     = make(chan struct{})
+			)
+			startWorker(t, &Worker{
+				Q:           q,
+				Concurrency: 4,
+				OrderKey:    "player",
+				Backoff:     func(int) time.Duration { return 600 * time.Millisecond },
+				Handle: func(ctx context.Context, m Message) error {
+					mu.Lock()
+					defer mu.Unlock()
+					if m.Headers["player"] == "alex" {
+						alexDone = true
+						return nil
+					}
+					if m.Headers["n"] == "0" && m.Attempts == 1 {
+						return errors.New("try again")
+					}
+					if m.Headers["n"] == "0" {
+						early = alexDone
+					}
+					steve = append(steve, m)
+					if len(steve) == followers+1 {
+						close(done)
+					}
+					return nil
+				},
+			})
+
+			ctx := context.Background()
+			publish := func(player string, n int) {
+				err := q.Publish(ctx, Message{Headers: map[string]string{"player": player, "n": strconv.Itoa(n)}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			publish("steve", 0)
+			time.Sleep(50 * time.Millisecond) // let it fail
+			for n := 1; n <= followers; n++ {
+				publish("steve", n)
+			}
+			publish("alex", 0)
+
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				mu.Lock()
+				defer mu.Unlock()
+				t.Fatalf("only %d of %d messages for steve handled", len(steve), followers+1)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !early {
+				t.Error("alex waited for steve's retry")
+			}
+			for i, m := range steve {
+				if n, _ := strconv.Atoi(m.Headers["n"]); n != i {
+					t.Fatalf("message %d of steve handled as number %d", n, i)
+				}
+				want := 1
+				if i == 0 {
+					want = 2
+				}
+				if m.Attempts != want {
+					t.Errorf("message %d handled on attempt %d, want %d (its lease expired while held)", i, m.Attempts, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerMergeSettlesTogether(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			q := kind.open(t, RealClock, time.Minute, 0)
+			dlq := kind.open(t, RealClock, time.Minute, 0)
+			release := make(chan struct{})
+			handled := make(chan string, 8)
+			startWorker(t, &Worker{
+				Q:           q,
+				DLQ:         dlq,
+				Concurrency: 8,
+				OrderKey:    "channel",
+				// Up to three bodies per call.
+				Merge: func(batch, next Message) (Message, bool) {
+					if strings.Count(string(batch.Body), ",") >= 2 {
+						return batch, false
+					}
+					batch.Body = append(append(batch.Body, ','), next.Body...)
+					return batch, true
+				},
+				Handle: func(ctx context.Context, m Message) error {
+					handled <- string(m.Body)
+					if string(m.Body) == "a" {
+						<-release // let the rest queue up behind it
+					}
+					if strings.Contains(string(m.Body), "e") {
+						return Permanent(errors.New("refused"))
+					}
+					return nil
+				},
+			})
+
+			ctx := context.Background()
+			for _, body := range []string{"a", "b", "c", "d", "e", "f"} {
+				if err := q.Publish(ctx, Message{ID: body, Body: []byte(body), Headers: map[string]string{"channel": "chat"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := receive(t, handled); got != "a" {
+				t.Fatalf("first call for %q, want a", got)
+			}
+			time.Sleep(100 * time.Millisecond) // let b..f be read ahead
+			close(release)
+			for _, want := range []string{"b,c,d", "e,f"} {
+				if got := receive(t, handled); got != want {
+					t.Fatalf("handled %q, want %q", got, want)
+				}
+			}
+
+			// e and f failed together, so both are dead-lettered.
+			var dead []Message
+			for i := 0; i < 200 && len(dead) < 2; i++ {
+				time.Sleep(5 * time.Millisecond)
+				dead, _ = dlq.(Inspector).Peek(ctx, 0)
+			}
+			if len(dead) != 2 || dead[0].ID != "e" || dead[1].ID != "f" || dead[1].LastError != "refused" {
+				t.Fatalf("dead letters = %+v, want e and f", dead)
+			}
+			if n := q.Len(); n != 0 {
+				t.Fatalf("%d messages left in the queue", n)
+			}
+		})
+	}
+}
+
+func TestExtendKeepsLease(t *testing.T) {
+	for _, kind := range queueKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := newFakeClock()
+			q := kind.open(t, clock, time.Minute, 0)
+			if err := q.Publish(ctx, Message{ID: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			d := leaseOne(t, q)
+
+			clock.Advance(50 * time.Second)
+			d, err := d.Extend(ctx, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := clock.Now().Add(time.Minute); !d.Deadline.Equal(want) {
+				t.Fatalf("deadline = %v, want %v", d.Deadline, want)
+			}
+			clock.Advance(30 * time.Second) // past the original lease
+			if n := q.Len(); n != 0 {
+				t.Fatalf("Len with an extended lease = %d, want 0", n)
+			}
+
+			clock.Advance(time.Minute)
+			again := leaseOne(t, q)
+			if _, err := d.Extend(ctx, time.Minute); !errors.Is(err, ErrLeaseExpired) {
+				t.Fatalf("extending a lease that ran out = %v, want ErrLeaseExpired", err)
+			}
+			if err := again.Ack(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package tcpbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"limpan/rotaria-bot/entities"
+	"math"
+	"strings"
+)
+
+// Frame is the codec-neutral form of a protocol message. Body stays raw bytes
+// so binary codecs can carry map renders, skins, etc. without base64.
+type Frame struct {
+	Type   string
+	ID     string
+	Topic  entities.Topic
+	Msg    string
+	Body   []byte
+	Codecs []string // HELLO offer
+	Codec  string   // HELLO answer
+}
+
+// Codec turns frames into bytes on the wire and back.
+type Codec interface {
+	Name() string
+	Encode(f Frame) ([]byte, error)
+	// Decode reads exactly one frame. Errors wrapping ErrBadFrame mean the
+	// frame was skipped and the stream is still usable.
+	Decode(r *bufio.Reader) (Frame, error)
+}
+
+const (
+	CodecNDJSON  = "ndjson"
+	CodecMsgpack = "msgpack"
+
+	maxFrameSize = 16 << 20
+)
+
+var errTruncated = errors.New("tcpbridge: truncated frame")
+
+var codecs = map[string]Codec{
+	CodecNDJSON:  ndjsonCodec{},
+	CodecMsgpack: msgpackCodec{},
+}
+
+func lookupCodec(name string) (Codec, bool) {
+	c, ok := codecs[strings.ToLower(name)]
+	return c, ok
+}
+
+// ---------------- NDJSON ----------------
+
+type ndjsonCodec struct{}
+
+func (ndjsonCodec) Name() string { return CodecNDJSON }
+
+func (ndjsonCodec) Encode(f Frame) ([]byte, error) {
+	b, err := json.Marshal(message{
+		Type:   f.Type,
+		ID:     f.ID,
+		Body:   string(f.Body),
+		Topic:  f.Topic,
+		Msg:    f.Msg,
+		Codecs: f.Codecs,
+		Codec:  f.Codec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (ndjsonCodec) Decode(r *bufio.Reader) (Frame, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return Frame{}, err
+		}
+		str := strings.TrimSpace(string(line))
+		if str == "" {
+			continue
+		}
+		var m message
+		if err := json.Unmarshal([]byte(str), &m); err != nil {
+			return Frame{}, fmt.Errorf("%w: %q: %v", ErrBadFrame, str, err)
+		}
+		return Frame{
+			Type:   m.Type,
+			ID:     m.ID,
+			Topic:  m.Topic,
+			Msg:    m.Msg,
+			Body:   []byte(m.Body),
+			Codecs: m.Codecs,
+			Codec:  m.Codec,
+		}, nil
+	}
+}
+
+// ---------------- MessagePack ----------------
+//
+// [uint32 big-endian length][msgpack map]
+// Keys are the NDJSON field names; "body" is encoded as bin, everything else
+// as str, and "codecs" as an array of str.
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+func (msgpackCodec) Encode(f Frame) ([]byte, error) {
+	n := 0
+	for _, set := range []bool{f.Type != "", f.ID != "", f.Topic != "", f.Msg != "", f.Body != nil, len(f.Codecs) > 0, f.Codec != ""} {
+		if set {
+			n++
+		}
+	}
+	b := make([]byte, 4, 32+len(f.Body))
+	b = append(b, 0x80|byte(n)) // fixmap
+	if f.Type != "" {
+		b = mpStr(mpStr(b, "type"), f.Type)
+	}
+	if f.ID != "" {
+		b = mpStr(mpStr(b, "id"), f.ID)
+	}
+	if f.Topic != "" {
+		b = mpStr(mpStr(b, "topic"), string(f.Topic))
+	}
+	if f.Msg != "" {
+		b = mpStr(mpStr(b, "msg"), f.Msg)
+	}
+	if f.Body != nil {
+		b = mpBin(mpStr(b, "body"), f.Body)
+	}
+	if len(f.Codecs) > 0 {
+		b = mpStr(b, "codecs")
+		if len(f.Codecs) > 15 {
+			return nil, fmt.Errorf("%w: too many codecs", ErrBadFrame)
+		}
+		b = append(b, 0x90|byte(len(f.Codecs))) // fixarray
+		for _, c := range f.Codecs {
+			b = mpStr(b, c)
+		}
+	}
+	if f.Codec != "" {
+		b = mpStr(mpStr(b, "codec"), f.Codec)
+	}
+	if len(b)-4 > maxFrameSize {
+		return nil, fmt.Errorf("%w: frame too large (%d bytes)", ErrBadFrame, len(b)-4)
+	}
+	binary.BigEndian.PutUint32(b[:4], uint32(len(b)-4))
+	return b, nil
+}
+
+func (msgpackCodec) Decode(r *bufio.Reader) (Frame, error) {
+	// Peek so a read timeout between frames consumes nothing.
+	hdr, err := r.Peek(4)
+	if err != nil {
+		return Frame{}, err
+	}
+	size := binary.BigEndian.Uint32(hdr)
+	_, _ = r.Discard(4)
+	if size > maxFrameSize {
+		return Frame{}, fmt.Errorf("%w: frame of %d bytes", errTruncated, size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		// Mid-frame failures leave the stream misaligned; force a reconnect.
+		return Frame{}, fmt.Errorf("%w: %v", errTruncated, err)
+	}
+	f, err := mpDecodeFrame(buf)
+	if err != nil {
+		return Frame{}, fmt.Errorf("%w: %v", ErrBadFrame, err)
+	}
+	return f, nil
+}
+
+func mpStr(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+func mpBin(b []byte, p []byte) []byte {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xc6)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, p...)
+}
+
+type mpReader struct {
+	b   []byte
+	off int
+}
+
+func (r *mpReader) next(n int) ([]byte, error) {
+	if n < 0 || r.off+n > len(r.b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	p := r.b[r.off : r.off+n]
+	r.off += n
+	return p, nil
+}
+
+func (r *mpReader) length(tag, base byte) (int, error) {
+	switch tag - base {
+	case 0:
+		p, err := r.next(1)
+		if err != nil {
+			return 0, err
+		}
+		return int(p[0]), nil
+	case 1:
+		p, err := r.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return int(binary.BigEndian.Uint16(p)), nil
+	case 2:
+		p, err := r.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return int(binary.BigEndian.Uint32(p)), nil
+	}
+	return 0, fmt.Errorf("unexpected tag 0x%02x", tag)
+}
+
+// bytes reads a str or bin value.
+func (r *mpReader) bytes() ([]byte, error) {
+	p, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	tag := p[0]
+	var n int
+	switch {
+	case tag&0xe0 == 0xa0:
+		n = int(tag & 0x1f)
+	case tag >= 0xd9 && tag <= 0xdb:
+		n, err = r.length(tag, 0xd9)
+	case tag >= 0xc4 && tag <= 0xc6:
+		n, err = r.length(tag, 0xc4)
+	case tag == 0xc0: // nil
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("expected str/bin, got 0x%02x", tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.next(n)
+}
+
+func mpDecodeFrame(buf []byte) (Frame, error) {
+	r := &mpReader{b: buf}
+	p, err := r.next(1)
+	if err != nil {
+		return Frame{}, err
+	}
+	var n int
+	switch tag := p[0]; {
+	case tag&0xf0 == 0x80:
+		n = int(tag & 0x0f)
+	case tag == 0xde:
+		q, err := r.next(2)
+		if err != nil {
+			return Frame{}, err
+		}
+		n = int(binary.BigEndian.Uint16(q))
+	default:
+		return Frame{}, fmt.Errorf("expected map, got 0x%02x", tag)
+	}
+
+	var f Frame
+	for i := 0; i < n; i++ {
+		k, err := r.bytes()
+		if err != nil {
+			return Frame{}, err
+		}
+		if string(k) == "codecs" {
+			p, err := r.next(1)
+			if err != nil {
+				return Frame{}, err
+			}
+			if p[0]&0xf0 != 0x90 {
+				return Frame{}, fmt.Errorf("expected fixarray, got 0x%02x", p[0])
+			}
+			for j := 0; j < int(p[0]&0x0f); j++ {
+				v, err := r.bytes()
+				if err != nil {
+					return Frame{}, err
+				}
+				f.Codecs = append(f.Codecs, string(v))
+			}
+			continue
+		}
+		v, err := r.bytes()
+		if err != nil {
+			return Frame{}, err
+		}
+		switch string(k) {
+		case "type":
+			f.Type = string(v)
+		case "id":
+			f.ID = string(v)
+		case "topic":
+			f.Topic = entities.Topic(v)
+		case "msg":
+			f.Msg = string(v)
+		case "body":
+			f.Body = append([]byte(nil), v...)
+		case "codec":
+			f.Codec = string(v)
+		default:
+			// ignore unknown keys
+		}
+	}
+	return f, nil
+}
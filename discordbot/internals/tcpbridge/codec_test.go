@@ -0,0 +1,209 @@
+package tcpbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var codecFrames = []Frame{
+	{Type: "PING"},
+	{Type: "HELLO", Codecs: []string{CodecMsgpack, CodecNDJSON}},
+	{Type: "HELLO", Codec: CodecMsgpack},
+	{Type: "CMD", ID: "42", Body: []byte("whitelist add Notch")},
+	{Type: "ERR", ID: "42", Msg: MsgServerNotReady},
+	{Type: "EVT", Topic: "chat", Body: bytes.Repeat([]byte("<Notch> hello "), 40)},
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, name := range []string{CodecNDJSON, CodecMsgpack} {
+		codec, _ := lookupCodec(name)
+		var wire []byte
+		for _, f := range codecFrames {
+			b, err := codec.Encode(f)
+			if err != nil {
+				t.Fatalf("%s: encode %s: %v", name, f.Type, err)
+			}
+			wire = append(wire, b...)
+		}
+		br := bufio.NewReader(bytes.NewReader(wire))
+		for _, want := range codecFrames {
+			got, err := codec.Decode(br)
+			if err != nil {
+				t.Fatalf("%s: decode %s: %v", name, want.Type, err)
+			}
+			if got.Type != want.Type || got.ID != want.ID || got.Topic != want.Topic || got.Msg != want.Msg ||
+				got.Codec != want.Codec || !bytes.Equal(got.Body, want.Body) || len(got.Codecs) != len(want.Codecs) {
+				t.Fatalf("%s: got %+v, want %+v", name, got, want)
+			}
+		}
+	}
+}
+
+// The mod's encoder (FrameCodec.java) writes the same bytes; keep them in
+// step.
+func TestMsgpackWireFormat(t *testing.T) {
+	got, err := msgpackCodec{}.Encode(Frame{Type: "CMD", ID: "1", Body: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x00, 0x00, 0x00, 0x18, // length
+		0x83,                                          // fixmap, 3 entries
+		0xa4, 't', 'y', 'p', 'e', 0xa3, 'C', 'M', 'D', // "type": "CMD"
+		0xa2, 'i', 'd', 0xa1, '1', // "id": "1"
+		0xa4, 'b', 'o', 'd', 'y', 0xc4, 0x02, 'h', 'i', // "body": bin "hi"
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got % x\nwant % x", got, want)
+	}
+}
+
+func TestMsgpackBadFrameKeepsStream(t *testing.T) {
+	var wire []byte
+	wire = append(wire, 0, 0, 0, 2, 0x91, 0xc0) // an array, not a map
+	ok, _ := msgpackCodec{}.Encode(Frame{Type: "PONG"})
+	wire = append(wire, ok...)
+	br := bufio.NewReader(bytes.NewReader(wire))
+	if _, err := (msgpackCodec{}).Decode(br); !errors.Is(err, ErrBadFrame) {
+		t.Fatalf("first frame: err = %v, want ErrBadFrame", err)
+	}
+	f, err := msgpackCodec{}.Decode(br)
+	if err != nil || f.Type != "PONG" {
+		t.Fatalf("second frame = %+v, %v", f, err)
+	}
+}
+
+// fakeMod answers the way the connector mod does: HELLO picks the first
+// offered codec it knows, then both directions switch.
+func fakeMod(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	codec := Codec(ndjsonCodec{})
+	for {
+		f, err := codec.Decode(br)
+		if err != nil {
+			return
+		}
+		var out Frame
+		switch f.Type {
+		case "HELLO":
+			chosen := Codec(ndjsonCodec{})
+			for _, name := range f.Codecs {
+				if c, ok := lookupCodec(name); ok {
+					chosen = c
+					break
+				}
+			}
+			b, _ := ndjsonCodec{}.Encode(Frame{Type: "HELLO", Codec: chosen.Name()})
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+			codec = chosen
+			continue
+		case "PING":
+			out = Frame{Type: "PONG"}
+		case "CMD":
+			out = Frame{Type: "RES", ID: f.ID, Body: append([]byte("done: "), f.Body...)}
+		default:
+			continue
+		}
+		b, err := codec.Encode(out)
+		if err != nil {
+			t.Errorf("fake mod encode: %v", err)
+			return
+		}
+		if _, err := conn.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+func TestHandshakeNegotiatesMsgpack(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go fakeMod(t, ln)
+
+	// A handshake that falls through to the timeout would take this long.
+	c := New(ln.Addr().String(), Options{Codecs: []string{CodecMsgpack}, HandshakeTimeout: 5 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		_ = c.Close()
+	}()
+	start := time.Now()
+	c.Start(ctx)
+
+	for !c.Status().Connected {
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("client did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("handshake took %v; the HELLO answer was not seen", time.Since(start))
+	}
+	if got := c.Status().Codec; got != CodecMsgpack {
+		t.Fatalf("codec = %q, want %q", got, CodecMsgpack)
+	}
+	res, err := c.Send(ctx, []byte("say hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "done: say hi" {
+		t.Fatalf("response = %q", res)
+	}
+}
+
+func benchmarkCodec(b *testing.B, codec Codec) {
+	frames := []Frame{
+		{Type: "EVT", Topic: "chat", Body: []byte("<Notch> anyone up for the nether tonight?")},
+		{Type: "CMD", ID: "0123456789abcdef", Body: []byte("whitelist add Notch")},
+		{Type: "EVT", Topic: "status", Body: bytes.Repeat([]byte{0, 1, 2, 3, 0xff}, 820)},
+	}
+	var wire bytes.Buffer
+	for _, f := range frames {
+		buf, err := codec.Encode(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		wire.Write(buf)
+	}
+	b.SetBytes(int64(wire.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	r := bytes.NewReader(nil)
+	br := bufio.NewReader(r)
+	for i := 0; i < b.N; i++ {
+		var out bytes.Buffer
+		for _, f := range frames {
+			buf, err := codec.Encode(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			out.Write(buf)
+		}
+		r.Reset(out.Bytes())
+		br.Reset(r)
+		for range frames {
+			if _, err := codec.Decode(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCodecNDJSON(b *testing.B)  { benchmarkCodec(b, ndjsonCodec{}) }
+func BenchmarkCodecMsgpack(b *testing.B) { benchmarkCodec(b, msgpackCodec{}) }
@@ -0,0 +1,559 @@
+package tcpbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"limpan/rotaria-bot/entities"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Spill segment record layout (little endian):
+// [uint16 topic len][topic][uint32 body len][body]
+
+var ErrSpillFull = errors.New("tcpbridge: spill log full")
+
+type SpillOptions struct {
+	Dir          string // required: directory holding the segment files
+	Buffer       int    // in-memory slots before spilling, default 4096
+	SegmentBytes int64  // rotate segments at this size, default 4 MiB
+	MaxBytes     int64  // cap for all segments on disk, default 256 MiB
+	WriteQueue   int    // events waiting for the disk writer before drops, default 4096
+}
+
+func (o *SpillOptions) setDefaults() {
+	if o.Buffer <= 0 {
+		o.Buffer = 4096
+	}
+	if o.WriteQueue <= 0 {
+		o.WriteQueue = 4096
+	}
+	if o.SegmentBytes <= 0 {
+		o.SegmentBytes = 4 << 20
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 256 << 20
+	}
+	if o.SegmentBytes > o.MaxBytes {
+		o.SegmentBytes = o.MaxBytes
+	}
+}
+
+type SpillStats struct {
+	Spilled   uint64 // events written to disk
+	Dropped   uint64 // events lost to the disk cap, a full write queue or a disk error
+	Pending   int    // events waiting in memory + on disk
+	DiskBytes int64  // bytes currently held by segments
+	Spilling  bool
+}
+
+type segment struct {
+	seq  int
+	path string
+	size int64 // bytes flushed and readable; only whole records
+	recs int   // records in those bytes
+}
+
+// SpillSubscriber is a subscriber that never blocks the reader. When its
+// in-memory buffer is full, events queue for a writer goroutine that appends
+// them to an on-disk segment log; pump feeds them back, in order, once the
+// consumer catches up. Only the writer touches the write head and only pump
+// reads segments, so disk I/O never happens under mu, and push never waits
+// on the disk.
+type SpillSubscriber struct {
+	id  int64
+	opt SpillOptions
+
+	mu       sync.Mutex
+	cond     *sync.Cond // wakes pump
+	wcond    *sync.Cond // wakes the writer
+	mem      []Event
+	inbox    []Event // waiting for the writer
+	writing  int     // events the writer has taken but not yet published
+	spilling bool
+	closed   bool
+
+	segs    []*segment // oldest first; last one is the write head
+	onDisk  int        // published records not yet read
+	diskLen int64      // published bytes held by segs
+
+	// Owned by the writer while writing > 0; otherwise guarded by mu.
+	w       *os.File
+	bw      *bufio.Writer
+	nextSeq int
+
+	// Owned by pump.
+	r    *os.File
+	br   *bufio.Reader
+	rSeg *segment
+	rOff int64
+
+	spilled atomic.Uint64
+	dropped atomic.Uint64
+
+	out        chan Event
+	done       chan struct{}
+	writerDone chan struct{}
+}
+
+// SubscribeSpill registers a disk-backed subscriber. Leftover segments in
+// opt.Dir from a previous run are discarded.
+func (c *Client) SubscribeSpill(opt SpillOptions) (*SpillSubscriber, error) {
+	if opt.Dir == "" {
+		return nil, errors.New("tcpbridge: spill dir required")
+	}
+	opt.setDefaults()
+	if err := os.MkdirAll(opt.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	old, _ := filepath.Glob(filepath.Join(opt.Dir, "seg-*.log"))
+	for _, p := range old {
+		_ = os.Remove(p)
+	}
+
+	s := &SpillSubscriber{
+		id:         c.subSeq.Add(1),
+		opt:        opt,
+		out:        make(chan Event),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wcond = sync.NewCond(&s.mu)
+
+	c.subsMu.Lock()
+	if c.spills == nil {
+		c.spills = make(map[int64]*SpillSubscriber)
+	}
+	c.spills[s.id] = s
+	c.subsMu.Unlock()
+
+	go s.writer()
+	go s.pump()
+	go func() {
+		<-s.done
+		c.subsMu.Lock()
+		delete(c.spills, s.id)
+		c.subsMu.Unlock()
+	}()
+	return s, nil
+}
+
+func (s *SpillSubscriber) ID() int64 { return s.id }
+
+// Events is closed once the subscriber is cancelled.
+func (s *SpillSubscriber) Events() <-chan Event { return s.out }
+
+func (s *SpillSubscriber) Stats() SpillStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpillStats{
+		Spilled:   s.spilled.Load(),
+		Dropped:   s.dropped.Load(),
+		Pending:   len(s.mem) + len(s.inbox) + s.writing + s.onDisk,
+		DiskBytes: s.diskLen,
+		Spilling:  s.spilling,
+	}
+}
+
+// Cancel stops delivery and removes any segments left on disk.
+func (s *SpillSubscriber) Cancel() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	s.wcond.Broadcast()
+	s.mu.Unlock()
+}
+
+// push is called by the bridge reader; it only ever touches memory.
+func (s *SpillSubscriber) push(evt Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	// Once spilling, everything goes through the disk until it drains so
+	// order holds.
+	if !s.spilling && len(s.mem) < s.opt.Buffer {
+		s.mem = append(s.mem, evt)
+		s.cond.Signal()
+		return
+	}
+	if !s.spilling {
+		s.spilling = true
+		log.Printf("tcpbridge: subscriber %d spilling to %s", s.id, s.opt.Dir)
+	}
+	// If the disk can't keep up either, drop rather than stall the reader.
+	if len(s.inbox) >= s.opt.WriteQueue {
+		s.noteDropped(1, ErrSpillFull)
+		return
+	}
+	s.inbox = append(s.inbox, evt)
+	s.wcond.Signal()
+}
+
+func (s *SpillSubscriber) noteDropped(k int, err error) {
+	n := s.dropped.Add(uint64(k))
+	if n == uint64(k) || n/1000 != (n-uint64(k))/1000 {
+		log.Printf("tcpbridge: spill dropped %d events: %v", n, err)
+	}
+}
+
+// writer appends queued events to the log in batches. A record only
+// becomes visible to pump once it has been flushed.
+func (s *SpillSubscriber) writer() {
+	defer close(s.writerDone)
+	for {
+		s.mu.Lock()
+		for !s.closed && len(s.inbox) == 0 {
+			s.wcond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		batch := s.inbox
+		s.inbox = nil
+		s.writing = len(batch)
+		budget := s.opt.MaxBytes - s.diskLen
+		var head *segment
+		if len(s.segs) > 0 && s.w != nil {
+			head = s.segs[len(s.segs)-1]
+		}
+		s.mu.Unlock()
+
+		var (
+			recs    int   // written to head since the last publish
+			bytes   int64 // ditto
+			dropped int
+			lastErr error
+		)
+		publish := func() error {
+			err := s.bw.Flush()
+			s.mu.Lock()
+			if err == nil {
+				head.size += bytes
+				head.recs += recs
+				s.diskLen += bytes
+				s.onDisk += recs
+				s.spilled.Add(uint64(recs))
+				s.cond.Signal()
+			} else {
+				dropped += recs
+			}
+			s.writing -= recs
+			s.mu.Unlock()
+			recs, bytes = 0, 0
+			if err != nil {
+				s.abandonHead()
+				head = nil
+			}
+			return err
+		}
+		for _, evt := range batch {
+			n := recordSize(evt)
+			if n > budget {
+				dropped++
+				lastErr = ErrSpillFull
+				continue
+			}
+			if head == nil || head.size+bytes+n > s.opt.SegmentBytes {
+				if head != nil {
+					if err := publish(); err != nil {
+						lastErr = err
+					}
+				}
+				var err error
+				if head, err = s.rotate(); err != nil {
+					dropped++
+					lastErr = err
+					continue
+				}
+			}
+			if err := writeRecord(s.bw, evt); err != nil {
+				// Whatever was buffered since the last flush is suspect.
+				dropped += recs + 1
+				lastErr = err
+				s.mu.Lock()
+				s.writing -= recs
+				s.mu.Unlock()
+				s.abandonHead()
+				head, recs, bytes = nil, 0, 0
+				continue
+			}
+			recs++
+			bytes += n
+			budget -= n
+		}
+		if head != nil && recs > 0 {
+			if err := publish(); err != nil {
+				lastErr = err
+			}
+		}
+		s.mu.Lock()
+		s.writing = 0
+		if dropped > 0 {
+			s.noteDropped(dropped, lastErr)
+		}
+		// Nothing to read back after all, e.g. everything was dropped.
+		s.cond.Signal()
+		s.mu.Unlock()
+	}
+}
+
+// abandonHead gives up on the write head after a failed write; its tail is
+// unusable, so the next record starts a fresh segment. Called by the writer
+// only.
+func (s *SpillSubscriber) abandonHead() {
+	s.mu.Lock()
+	f := s.w
+	s.w, s.bw = nil, nil
+	s.mu.Unlock()
+	if f != nil {
+		_ = f.Close()
+	}
+}
+
+func recordSize(evt Event) int64 {
+	return int64(2 + len(evt.Topic) + 4 + len(evt.Body))
+}
+
+func writeRecord(w *bufio.Writer, evt Event) error {
+	topic := []byte(evt.Topic)
+	if len(topic) > 0xFFFF {
+		return ErrBadFrame
+	}
+	var hdr [4]byte
+	binary.LittleEndian.PutUint16(hdr[:2], uint16(len(topic)))
+	if _, err := w.Write(hdr[:2]); err != nil {
+		return err
+	}
+	if _, err := w.Write(topic); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(hdr[:], uint32(len(evt.Body)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(evt.Body)
+	return err
+}
+
+// rotate closes the write head and starts a new segment. Called by the
+// writer only.
+func (s *SpillSubscriber) rotate() (*segment, error) {
+	if s.w != nil {
+		if err := s.w.Close(); err != nil {
+			log.Printf("tcpbridge: closing spill segment: %v", err)
+		}
+	}
+	seg := &segment{seq: s.nextSeq, path: filepath.Join(s.opt.Dir, fmt.Sprintf("seg-%08d.log", s.nextSeq))}
+	s.nextSeq++
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.w, s.bw = nil, nil
+		return nil, err
+	}
+	s.segs = append(s.segs, seg)
+	s.w = f
+	s.bw = bufio.NewWriterSize(f, 64<<10)
+	return seg, nil
+}
+
+// readNext returns the next published record. Called by pump without mu
+// held, when s.onDisk > 0.
+func (s *SpillSubscriber) readNext() (Event, error) {
+	for {
+		s.mu.Lock()
+		head := s.segs[0]
+		size, more := head.size, len(s.segs) > 1
+		s.mu.Unlock()
+
+		if s.rSeg != head || s.r == nil {
+			if s.r != nil {
+				_ = s.r.Close()
+			}
+			off := int64(0)
+			if s.rSeg == head {
+				off = s.rOff // resuming after skipUnreadable
+			}
+			f, err := os.Open(head.path)
+			if err == nil && off > 0 {
+				_, err = f.Seek(off, io.SeekStart)
+			}
+			if err != nil {
+				if f != nil {
+					_ = f.Close()
+				}
+				s.r, s.br, s.rSeg = nil, nil, nil
+				return Event{}, err
+			}
+			s.r, s.br, s.rSeg, s.rOff = f, bufio.NewReaderSize(f, 64<<10), head, off
+		}
+		if s.rOff < size {
+			break
+		}
+		if !more {
+			return Event{}, io.ErrUnexpectedEOF
+		}
+		// Fully consumed and no longer written to.
+		_ = s.r.Close()
+		s.r, s.br, s.rSeg = nil, nil, nil
+		if err := os.Remove(head.path); err != nil {
+			log.Printf("tcpbridge: removing spill segment: %v", err)
+		}
+		s.mu.Lock()
+		s.diskLen -= head.size
+		s.segs = s.segs[1:]
+		s.mu.Unlock()
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(s.br, hdr[:2]); err != nil {
+		return Event{}, err
+	}
+	topic := make([]byte, binary.LittleEndian.Uint16(hdr[:2]))
+	if _, err := io.ReadFull(s.br, topic); err != nil {
+		return Event{}, err
+	}
+	if _, err := io.ReadFull(s.br, hdr[:]); err != nil {
+		return Event{}, err
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(s.br, body); err != nil {
+		return Event{}, err
+	}
+	s.rOff += int64(2 + len(topic) + 4 + len(body))
+	return Event{Topic: entities.Topic(topic), Body: body}, nil
+}
+
+// detachDiskLocked hands the segment files to the caller for removal once
+// the log has drained and the writer is idle.
+func (s *SpillSubscriber) detachDiskLocked() (w *os.File, segs []*segment) {
+	w, segs = s.w, s.segs
+	s.w, s.bw = nil, nil
+	s.segs = nil
+	s.diskLen = 0
+	s.onDisk = 0
+	return w, segs
+}
+
+func (s *SpillSubscriber) removeDisk(w *os.File, segs []*segment) {
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r, s.br, s.rSeg = nil, nil, nil
+	}
+	if w != nil {
+		_ = w.Close()
+	}
+	for _, seg := range segs {
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("tcpbridge: removing spill segment: %v", err)
+		}
+	}
+}
+
+func (s *SpillSubscriber) pump() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		for !s.closed && len(s.mem) == 0 && s.onDisk == 0 && !s.drainedLocked() {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mem, s.inbox = nil, nil
+			s.mu.Unlock()
+			<-s.writerDone
+			s.mu.Lock()
+			w, segs := s.detachDiskLocked()
+			s.mu.Unlock()
+			s.removeDisk(w, segs)
+			return
+		}
+		if len(s.mem) > 0 {
+			evt := s.mem[0]
+			s.mem[0] = Event{}
+			s.mem = s.mem[1:]
+			s.mu.Unlock()
+			s.send(evt)
+			continue
+		}
+		if s.onDisk == 0 {
+			// drainedLocked: back to memory-only delivery.
+			w, segs := s.detachDiskLocked()
+			s.spilling = false
+			s.mu.Unlock()
+			s.removeDisk(w, segs)
+			log.Printf("tcpbridge: subscriber %d drained spill log (spilled=%d dropped=%d)",
+				s.id, s.spilled.Load(), s.dropped.Load())
+			continue
+		}
+		s.mu.Unlock()
+
+		evt, err := s.readNext()
+		if err != nil {
+			s.skipUnreadable(err)
+			continue
+		}
+		s.mu.Lock()
+		s.onDisk--
+		s.mu.Unlock()
+		s.send(evt)
+	}
+}
+
+// drainedLocked reports whether a spill is over: everything written has
+// been read and nothing more is on its way to the disk.
+func (s *SpillSubscriber) drainedLocked() bool {
+	return s.spilling && s.onDisk == 0 && len(s.inbox) == 0 && s.writing == 0
+}
+
+// skipUnreadable gives up on every published record after a read error; a
+// corrupt or truncated log cannot be resumed. Reading carries on from the
+// write head's current end, which is a record boundary.
+func (s *SpillSubscriber) skipUnreadable(err error) {
+	s.mu.Lock()
+	lost := s.onDisk
+	s.onDisk = 0
+	var old []*segment
+	if n := len(s.segs); n > 0 {
+		old = s.segs[:n-1]
+		head := s.segs[n-1]
+		for _, seg := range old {
+			s.diskLen -= seg.size
+		}
+		s.segs = []*segment{head}
+		s.rSeg, s.rOff = head, head.size
+	}
+	s.noteDropped(lost, err)
+	s.mu.Unlock()
+
+	log.Printf("tcpbridge: spill log unreadable, dropping %d events: %v", lost, err)
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r, s.br = nil, nil
+	}
+	for _, seg := range old {
+		_ = os.Remove(seg.path)
+	}
+}
+
+func (s *SpillSubscriber) send(evt Event) {
+	select {
+	case s.out <- evt:
+	case <-s.done:
+	}
+}
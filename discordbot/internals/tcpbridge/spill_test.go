@@ -0,0 +1,105 @@
+package tcpbridge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpillKeepsOrderThroughDisk(t *testing.T) {
+	dir := t.TempDir()
+	c := &Client{}
+	s, err := c.SubscribeSpill(SpillOptions{Dir: dir, Buffer: 8, SegmentBytes: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cancel()
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		s.push(Event{Topic: "chat", Body: []byte(fmt.Sprintf("m%d", i))})
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case evt := <-s.Events():
+			if want := fmt.Sprintf("m%d", i); string(evt.Body) != want || evt.Topic != "chat" {
+				t.Fatalf("event %d = %s %q, want %q", i, evt.Topic, evt.Body, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d; stats %+v", i, s.Stats())
+		}
+	}
+
+	// Once drained the subscriber goes back to memory and cleans up.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st := s.Stats()
+		segs, _ := filepath.Glob(filepath.Join(dir, "seg-*.log"))
+		if !st.Spilling && st.Pending == 0 && len(segs) == 0 {
+			if st.Spilled == 0 || st.Dropped != 0 {
+				t.Fatalf("stats %+v", st)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not drained: %+v, %d segments", st, len(segs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpillDropsWhenDiskFull(t *testing.T) {
+	c := &Client{}
+	s, err := c.SubscribeSpill(SpillOptions{Dir: t.TempDir(), Buffer: 4, MaxBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cancel()
+	for i := 0; i < 100; i++ {
+		s.push(Event{Topic: "chat", Body: []byte("0123456789")})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Stats().Dropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing dropped: %+v", s.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Whatever was kept still arrives.
+	got := 0
+	for {
+		select {
+		case <-s.Events():
+			got++
+			continue
+		case <-time.After(200 * time.Millisecond):
+		}
+		break
+	}
+	st := s.Stats()
+	if got == 0 || uint64(got)+st.Dropped != 100 {
+		t.Fatalf("got %d, stats %+v", got, st)
+	}
+}
+
+func TestSpillCancelRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	c := &Client{}
+	s, err := c.SubscribeSpill(SpillOptions{Dir: dir, Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		s.push(Event{Topic: "chat", Body: []byte("x")})
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.Cancel()
+	for range s.Events() {
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("%d files left in spill dir", len(entries))
+	}
+}
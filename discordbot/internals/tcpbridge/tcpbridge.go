@@ -5,24 +5,29 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"limpan/rotaria-bot/entities"
 	"log"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // NDJSON protocol (one JSON object per line):
+// {"type":"HELLO","codecs":["msgpack","ndjson"]}  (client offer)
+// {"type":"HELLO","codec":"msgpack"}               (server choice)
 // {"type":"PING"}
 // {"type":"PONG"}
 // {"type":"CMD","id":"<id>","body":"<utf8>"}
 // {"type":"RES","id":"<id>","body":"<utf8>"}
 // {"type":"ERR","id":"<id>","msg":"<utf8>"}
 // {"type":"EVT","topic":"<topic>","body":"<utf8>"}
+//
+// The HELLO exchange is only sent when Options.Codecs offers something other
+// than NDJSON. Both sides switch codec right after the server's HELLO; a
+// server that never answers keeps the connection on NDJSON.
 
 var (
 	ErrUnavailable = errors.New("tcpbridge: connection unavailable")
@@ -32,6 +37,22 @@ var (
 	ErrBadFrame    = errors.New("tcpbridge: bad frame")
 )
 
+// MsgServerNotReady is what the mod answers while the Minecraft server is
+// still starting or already stopping.
+const MsgServerNotReady = "server not ready"
+
+// RemoteError is an ERR frame from the mod: the command reached the server
+// and was refused or failed there.
+type RemoteError struct {
+	Msg string
+}
+
+func (e *RemoteError) Error() string { return e.Msg }
+
+// NotReady reports whether the server wasn't running the command yet, which
+// is worth retrying once it is up.
+func (e *RemoteError) NotReady() bool { return e.Msg == MsgServerNotReady }
+
 type Options struct {
 	DialTimeout         time.Duration
 	ReadTimeout         time.Duration
@@ -43,6 +64,11 @@ type Options struct {
 
 	BreakerFailures int
 	BreakerOpenFor  time.Duration
+
+	// Codecs offered at connect in preference order, e.g. {"msgpack"}.
+	// Empty means plain NDJSON with no handshake.
+	Codecs           []string
+	HandshakeTimeout time.Duration
 }
 
 func (o *Options) setDefaults() {
@@ -73,6 +99,9 @@ func (o *Options) setDefaults() {
 	if o.BreakerOpenFor == 0 {
 		o.BreakerOpenFor = 10 * time.Second
 	}
+	if o.HandshakeTimeout == 0 {
+		o.HandshakeTimeout = 2 * time.Second
+	}
 }
 
 type BreakerState int
@@ -83,11 +112,28 @@ const (
 	BreakerHalfOpen
 )
 
+func (b BreakerState) String() string {
+	switch b {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(b))
+}
+
 type Status struct {
 	Connected     bool
 	BreakerState  BreakerState
 	LastHeartbeat time.Time
 	QueueLen      int
+	Codec         string
+
+	// Subscriber delivery counters since start.
+	EventsSpilled uint64
+	EventsDropped uint64
 }
 
 type Event struct {
@@ -100,28 +146,34 @@ type response struct {
 	err  error
 }
 
+// message is the NDJSON wire form of a Frame.
 type message struct {
-	Type  string         `json:"type"`
-	ID    string         `json:"id,omitempty"`
-	Body  string         `json:"body,omitempty"`
-	Topic entities.Topic `json:"topic,omitempty"`
-	Msg   string         `json:"msg,omitempty"`
+	Type   string         `json:"type"`
+	ID     string         `json:"id,omitempty"`
+	Body   string         `json:"body,omitempty"`
+	Topic  entities.Topic `json:"topic,omitempty"`
+	Msg    string         `json:"msg,omitempty"`
+	Codecs []string       `json:"codecs,omitempty"`
+	Codec  string         `json:"codec,omitempty"`
 }
 
 type Client struct {
 	addr string
 	opt  Options
 
-	mu   sync.RWMutex
-	conn net.Conn
-	wq   chan []byte
+	mu    sync.RWMutex
+	conn  net.Conn
+	codec Codec
+	wq    chan Frame
 
 	pendingMu sync.Mutex
 	pending   map[string]chan response
 
 	subsMu sync.RWMutex
 	subs   map[int64]chan Event
+	spills map[int64]*SpillSubscriber
 	subSeq atomic.Int64
+	evDrop atomic.Uint64
 
 	healthy    atomic.Bool
 	lastPongNS atomic.Int64
@@ -141,7 +193,7 @@ func New(addr string, opt Options) *Client {
 	c := &Client{
 		addr:    addr,
 		opt:     opt,
-		wq:      make(chan []byte, 128),
+		wq:      make(chan Frame, 128),
 		pending: make(map[string]chan response),
 	}
 	c.lastPongNS.Store(time.Now().UnixNano())
@@ -167,9 +219,17 @@ func (c *Client) Start(ctx context.Context) {
 				sleepWithJitter(&backoff, c.opt.ReconnectMaxBackoff, ctx)
 				continue
 			}
-			c.setConn(conn)
+			br := bufio.NewReader(conn)
+			codec, err := c.handshake(conn, br)
+			if err != nil {
+				log.Println("tcpbridge: handshake failed, ", err)
+				_ = conn.Close()
+				sleepWithJitter(&backoff, c.opt.ReconnectMaxBackoff, ctx)
+				continue
+			}
+			c.setConn(conn, codec)
 			uptimeStart := time.Now()
-			err = c.run(ctx, conn)
+			err = c.run(ctx, conn, br, codec)
 
 			if err != nil {
 				log.Println("tcpbridge: connection error, ", err)
@@ -216,22 +276,93 @@ func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) setConn(conn net.Conn) {
+func (c *Client) setConn(conn net.Conn, codec Codec) {
 	c.mu.Lock()
 	c.conn = conn
+	c.codec = codec
 	c.mu.Unlock()
 	c.healthy.Store(true)
 	c.resetBreaker()
 }
 
-func (c *Client) run(ctx context.Context, conn net.Conn) error {
+// handshake offers Options.Codecs and returns the codec the server picked.
+// Frames that arrive before the answer are dispatched as usual.
+func (c *Client) handshake(conn net.Conn, br *bufio.Reader) (Codec, error) {
+	ndjson := codecs[CodecNDJSON]
+	offer := make([]string, 0, len(c.opt.Codecs)+1)
+	for _, name := range c.opt.Codecs {
+		if _, ok := lookupCodec(name); ok && name != CodecNDJSON {
+			offer = append(offer, name)
+		}
+	}
+	if len(offer) == 0 {
+		return ndjson, nil
+	}
+	offer = append(offer, CodecNDJSON)
+
+	hello, _ := ndjson.Encode(Frame{Type: "HELLO", Codecs: offer})
+	if _, err := c.writeFrame(conn, hello); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.opt.HandshakeTimeout)
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_ = conn.SetReadDeadline(deadline)
+		f, err := ndjson.Decode(br)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Printf("tcpbridge: no HELLO from server, staying on %s", CodecNDJSON)
+				return ndjson, nil
+			}
+			if errors.Is(err, ErrBadFrame) {
+				continue
+			}
+			return nil, err
+		}
+		if f.Type != "HELLO" {
+			c.dispatch(f)
+			continue
+		}
+		codec, ok := lookupCodec(f.Codec)
+		if !ok {
+			return nil, fmt.Errorf("%w: server chose unknown codec %q", ErrBadFrame, f.Codec)
+		}
+		log.Printf("tcpbridge: negotiated codec %s", codec.Name())
+		return codec, nil
+	}
+}
+
+func (c *Client) dispatch(f Frame) {
+	switch f.Type {
+	case "PONG":
+		c.lastPongNS.Store(time.Now().UnixNano())
+	case "RES":
+		c.complete(f.ID, f.Body, nil)
+	case "ERR":
+		c.complete(f.ID, nil, &RemoteError{Msg: f.Msg})
+	case "EVT":
+		c.broadcast(Event{Topic: f.Topic, Body: f.Body})
+	default:
+		// ignore unknown
+	}
+}
+
+func (c *Client) run(ctx context.Context, conn net.Conn, br *bufio.Reader, codec Codec) error {
 	c.wg.Add(3)
 	errs := make(chan error, 3)
 
 	// writer
 	go func() {
 		defer c.wg.Done()
-		for buf := range c.wq {
+		for f := range c.wq {
+			buf, err := codec.Encode(f)
+			if err != nil {
+				log.Printf("tcpbridge: cannot encode %s frame: %v", f.Type, err)
+				if f.Type == "CMD" {
+					c.complete(f.ID, nil, err)
+				}
+				continue
+			}
 			if _, err := c.writeFrame(conn, buf); err != nil {
 				errs <- err
 				return
@@ -242,42 +373,25 @@ func (c *Client) run(ctx context.Context, conn net.Conn) error {
 	// reader/demux
 	go func() {
 		defer c.wg.Done()
-		br := bufio.NewReader(conn)
 		for {
 			if c.opt.ReadTimeout > 0 {
 				_ = conn.SetReadDeadline(time.Now().Add(c.opt.ReadTimeout))
 			}
-			line, err := br.ReadBytes('\n')
+			f, err := codec.Decode(br)
 			if err != nil {
 				// If it's just a timeout, continue waiting for data
 				if ne, ok := err.(net.Error); ok && ne.Timeout() {
 					log.Printf("tcpbridge: read timeout — continuing")
 					continue
 				}
+				if errors.Is(err, ErrBadFrame) {
+					log.Printf("tcpbridge: bad frame (ignored): %v", err)
+					continue
+				}
 				errs <- err
 				return
 			}
-			str := strings.TrimSpace(string(line))
-			if str == "" {
-				continue
-			}
-			var m message
-			if err := json.Unmarshal([]byte(str), &m); err != nil {
-				log.Printf("tcpbridge: bad frame (ignored): %q err=%v", str, err)
-				continue
-			}
-			switch m.Type {
-			case "PONG":
-				c.lastPongNS.Store(time.Now().UnixNano())
-			case "RES":
-				c.complete(m.ID, []byte(m.Body), nil)
-			case "ERR":
-				c.complete(m.ID, nil, errors.New(m.Msg))
-			case "EVT":
-				c.broadcast(Event{Topic: m.Topic, Body: []byte(m.Body)})
-			default:
-				// ignore unknown
-			}
+			c.dispatch(f)
 		}
 	}()
 
@@ -291,7 +405,7 @@ func (c *Client) run(ctx context.Context, conn net.Conn) error {
 			select {
 			case <-t.C:
 				last := time.Unix(0, c.lastPongNS.Load())
-				c.enqueueJSON(message{Type: "PING"})
+				c.enqueueFrame(Frame{Type: "PING"})
 				tmr := time.NewTimer(c.opt.HeartbeatTimeout)
 				select {
 				case <-tmr.C:
@@ -333,29 +447,16 @@ func (c *Client) writeFrame(conn net.Conn, buf []byte) (int, error) {
 	return conn.Write(buf)
 }
 
-func (c *Client) enqueue(buf []byte) {
-	if c.closed.Load() {
-		return
-	}
-	select {
-	case c.wq <- buf:
-	default:
-		c.wq <- buf
-	}
-}
-
-func (c *Client) enqueueJSON(m message) {
+func (c *Client) enqueueFrame(f Frame) {
 	if c.closed.Load() {
 		return
 	}
-	b, _ := json.Marshal(m)
-	b = append(b, '\n')
 	select {
-	case c.wq <- b:
+	case c.wq <- f:
 	default:
 		// queue full — drop PINGs; for CMD/critical you might still block
-		if m.Type != "PING" {
-			c.wq <- b // allow blocking for non-heartbeat frames
+		if f.Type != "PING" {
+			c.wq <- f // allow blocking for non-heartbeat frames
 		}
 	}
 }
@@ -379,7 +480,7 @@ func (c *Client) Send(ctx context.Context, payload []byte) ([]byte, error) {
 	c.pending[id] = respCh
 	c.pendingMu.Unlock()
 
-	c.enqueueJSON(message{Type: "CMD", ID: id, Body: string(payload)})
+	c.enqueueFrame(Frame{Type: "CMD", ID: id, Body: payload})
 
 	tmo := c.opt.CommandTimeout
 	if deadline, ok := ctx.Deadline(); ok {
@@ -467,9 +568,13 @@ func (c *Client) broadcast(evt Event) {
 		select {
 		case ch <- evt:
 		default:
+			c.evDrop.Add(1)
 			log.Printf("tcpbridge: slow subscriber; dropping evt")
 		}
 	}
+	for _, s := range c.spills {
+		s.push(evt)
+	}
 	c.subsMu.RUnlock()
 }
 
@@ -478,6 +583,18 @@ func (c *Client) Status() Status {
 	st.Connected = c.healthy.Load()
 	st.LastHeartbeat = time.Unix(0, c.lastPongNS.Load())
 	st.QueueLen = len(c.wq)
+	c.mu.RLock()
+	if c.codec != nil {
+		st.Codec = c.codec.Name()
+	}
+	c.mu.RUnlock()
+	st.EventsDropped = c.evDrop.Load()
+	c.subsMu.RLock()
+	for _, s := range c.spills {
+		st.EventsSpilled += s.spilled.Load()
+		st.EventsDropped += s.dropped.Load()
+	}
+	c.subsMu.RUnlock()
 	st.BreakerState = func() BreakerState {
 		c.brMu.Lock()
 		defer c.brMu.Unlock()
@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"limpan/rotaria-bot/entities"
 	"limpan/rotaria-bot/internals/db"
+	"limpan/rotaria-bot/internals/imq"
 	"limpan/rotaria-bot/internals/tcpbridge"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
@@ -19,18 +24,51 @@ type Config struct {
 	MinecraftAddress                   string
 	WhitelistRequestsChannelID         string
 	ReportChannelID                    string
+	AuditChannelID                     string // optional mirror of the audit log
 	MinecraftDiscordMessengerChannelID string
 	ServerStatusChannelID              string
-	DatabaseConfigPath                 string
+	DatabaseConfigPath                 string // SQLite file, or a postgres:// DSN
+	QueueDatabasePath                  string // SQLite file for the durable queues when the database is Postgres
 	MemberRoleID                       string
 	GuildID                            string
 	MessageWebhookUrl                  string
+
+	// Minecraft events overflow to this directory when Discord falls behind.
+	EventSpillDir      string
+	EventSpillMaxBytes int64
+
+	// How many Minecraft accounts a member may link (0 = no limit), and
+	// whether accounts after the first need staff approval.
+	MaxAccountsPerMember int
+	AltsRequireApproval  bool
+
+	// How long a member who left Discord can rejoin and get their whitelist
+	// back; 0 removes them straight away.
+	DepartureGracePeriod time.Duration
+
+	// Archived chat older than this many days is deleted; 0 keeps it forever.
+	ChatRetentionDays int
+
+	// Raw server status samples are kept this many days; /stats reads the
+	// hourly and daily rollups, which last longer.
+	StatusRetentionDays int
+
+	// Database backups. An interval of 0 turns off scheduled backups;
+	// /db backup still works.
+	BackupDir        string
+	BackupInterval   time.Duration
+	BackupKeepDaily  int
+	BackupKeepWeekly int
+
+	// Bridge frame codecs to offer the mod, e.g. "msgpack". Empty keeps NDJSON.
+	BridgeCodecs []string
 }
 
 type App struct {
 	Config         Config
 	DiscordSession *discordgo.Session
 	MinecraftConn  *tcpbridge.Client
+	Store          db.Store
 	Commands       []*discordgo.ApplicationCommand
 
 	// status workers
@@ -45,6 +83,23 @@ type App struct {
 
 	// blacklist words
 	blacklist []string
+
+	// durable queue for commands sent to the mod
+	bridgeQueue  imq.Queue
+	bridgeDLQ    imq.Queue
+	bridgeWorker *imq.Worker
+
+	// scheduled purges of members who left Discord
+	departureQueue  imq.Queue
+	departureDLQ    imq.Queue
+	departureWorker *imq.Worker
+
+	// Minecraft -> Discord chat webhook sender
+	chatRelay *chatRelay
+
+	backups *db.Backups
+	// the durable queues' own SQLite file, when the main database isn't SQLite
+	queueStore *db.SQLStore
 }
 
 // TODO: Fuck den här, vi måste lösa det på nått bättre sätt sen
@@ -53,8 +108,28 @@ var (
 )
 
 func main() {
-	app := &App{}
+	// The migrate subcommand only needs the database, not a full config.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		_ = godotenv.Load()
+		if err := runMigrateCommand(os.Getenv("DatabaseConfigPath"), os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// So does restore, which must run with the bot stopped.
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		_ = godotenv.Load()
+		app := &App{Config: Config{DatabaseConfigPath: os.Getenv("DatabaseConfigPath")}}
+		if err := app.loadBackupConfig(); err != nil {
+			log.Fatal(err)
+		}
+		if err := runRestoreCommand(app.Config, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
+	app := &App{}
 	if err := app.loadConfig(); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -100,12 +175,77 @@ func (a *App) loadConfig() error {
 		MinecraftDiscordMessengerChannelID: os.Getenv("MinecraftDiscordMessengerChannelID"),
 		WhitelistRequestsChannelID:         os.Getenv("WhitelistRequestsChannelID"),
 		ReportChannelID:                    os.Getenv("ReportChannelID"),
+		AuditChannelID:                     os.Getenv("AuditChannelID"),
 		ServerStatusChannelID:              os.Getenv("ServerStatusChannelID"),
 		MinecraftAddress:                   os.Getenv("MinecraftAddress"),
 		DatabaseConfigPath:                 os.Getenv("DatabaseConfigPath"),
+		QueueDatabasePath:                  os.Getenv("QueueDatabasePath"),
 		MemberRoleID:                       os.Getenv("MemberRoleID"),
 		GuildID:                            os.Getenv("GuildID"),
 		MessageWebhookUrl:                  os.Getenv("MessageWebhookUrl"),
+		EventSpillDir:                      os.Getenv("EventSpillDir"),
+	}
+
+	for _, c := range strings.Split(os.Getenv("BridgeCodecs"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			a.Config.BridgeCodecs = append(a.Config.BridgeCodecs, c)
+		}
+	}
+
+	if err := a.loadBackupConfig(); err != nil {
+		return err
+	}
+
+	a.Config.MaxAccountsPerMember = 1
+	if v := os.Getenv("MaxAccountsPerMember"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid MaxAccountsPerMember %q", v)
+		}
+		a.Config.MaxAccountsPerMember = n
+	}
+	a.Config.AltsRequireApproval = true
+	if v := os.Getenv("AltsRequireApproval"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid AltsRequireApproval %q: %w", v, err)
+		}
+		a.Config.AltsRequireApproval = b
+	}
+
+	a.Config.DepartureGracePeriod = 7 * 24 * time.Hour
+	if v := os.Getenv("DepartureGracePeriod"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid DepartureGracePeriod %q", v)
+		}
+		a.Config.DepartureGracePeriod = d
+	}
+
+	a.Config.ChatRetentionDays = 90
+	if v := os.Getenv("ChatRetentionDays"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid ChatRetentionDays %q: %w", v, err)
+		}
+		a.Config.ChatRetentionDays = days
+	}
+
+	a.Config.StatusRetentionDays = 3
+	if v := os.Getenv("StatusRetentionDays"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 {
+			return fmt.Errorf("invalid StatusRetentionDays %q", v)
+		}
+		a.Config.StatusRetentionDays = days
+	}
+
+	if v := os.Getenv("EventSpillMaxMB"); v != "" {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid EventSpillMaxMB %q: %w", v, err)
+		}
+		a.Config.EventSpillMaxBytes = mb << 20
 	}
 
 	if a.Config.DiscordToken == "" {
@@ -131,12 +271,40 @@ func (a *App) connectToServices() error {
 		return fmt.Errorf("cannot open Discord session: %w", err)
 	}
 
-	db.InitializeDatabase(a.Config.DatabaseConfigPath)
+	ctx := context.Background()
+	store, err := db.OpenStore(ctx, a.Config.DatabaseConfigPath)
+	if err != nil {
+		return err
+	}
+	a.Store = store
+	queueConn, err := a.openQueueDatabase(ctx, store)
+	if err != nil {
+		return err
+	}
 
 	// Connect to Minecraft server
-	a.MinecraftConn = tcpbridge.New(a.Config.MinecraftAddress, tcpbridge.Options{}) //, tcpbridge.Options{Log: log.New(os.Stdout, "tcpbridge: ", log.LstdFlags)})
-	ctx := context.Background()
+	a.MinecraftConn = tcpbridge.New(a.Config.MinecraftAddress, tcpbridge.Options{Codecs: a.Config.BridgeCodecs}) //, tcpbridge.Options{Log: log.New(os.Stdout, "tcpbridge: ", log.LstdFlags)})
 	a.MinecraftConn.Start(ctx)
+	a.startBridgeMonitor(ctx)
+	if err := a.startBridgeQueue(ctx, queueConn); err != nil {
+		return err
+	}
+	if err := a.startChatRelay(ctx, queueConn); err != nil {
+		return err
+	}
+	if err := a.startDepartures(ctx, queueConn); err != nil {
+		return err
+	}
+	a.reportWhitelistConflicts(ctx)
+	a.startNameRefresher(ctx)
+	a.startSessionTracker(ctx)
+	a.startChatArchivePruner(ctx)
+	a.startStatusHistory(ctx)
+	if store.Dialect == db.SQLite {
+		a.startBackups(ctx, store.Conn)
+	} else {
+		log.Printf("Built-in backups only cover SQLite; back up %s with its own tools", store.Dialect)
+	}
 	st := a.MinecraftConn.Status()
 	if !st.Connected && st.BreakerState != tcpbridge.BreakerClosed {
 		return fmt.Errorf("failed to connect to Minecraft mod socket: %w", tcpbridge.ErrUnavailable)
@@ -163,7 +331,10 @@ func (a *App) setupDiscordHandlers() {
 	a.DiscordSession.AddHandler(a.onReportModalSubmitted)
 	a.DiscordSession.AddHandler(a.onReportAction)
 	a.DiscordSession.AddHandler(a.onReportActionModalSubmitted)
+	a.DiscordSession.AddHandler(a.onChatlogPage)
+	a.DiscordSession.AddHandler(a.onWhitelistSync)
 	a.DiscordSession.AddHandler(a.onUserLeft)
+	a.DiscordSession.AddHandler(a.onUserJoined)
 	a.DiscordSession.AddHandler(onWhitelistModalRequested)
 	a.DiscordSession.AddHandler(onApplicationCommand)
 }
@@ -172,11 +343,43 @@ func (a *App) shutdown() {
 	if a.DiscordSession != nil {
 		a.DiscordSession.Close()
 	}
+	if a.bridgeWorker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := a.bridgeWorker.Stop(ctx); err != nil {
+			log.Printf("Bridge worker did not stop cleanly: %v", err)
+		}
+		cancel()
+	}
+	if a.departureWorker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := a.departureWorker.Stop(ctx); err != nil {
+			log.Printf("Departures worker did not stop cleanly: %v", err)
+		}
+		cancel()
+		a.departureQueue.Close()
+		a.departureDLQ.Close()
+	}
+	if a.chatRelay != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := a.chatRelay.Close(ctx); err != nil {
+			log.Printf("Chat relay did not stop cleanly: %v", err)
+		}
+		cancel()
+	}
+	if a.bridgeQueue != nil {
+		a.bridgeQueue.Close()
+		a.bridgeDLQ.Close()
+	}
+	if a.queueStore != nil {
+		a.queueStore.Close()
+	}
 	if a.MinecraftConn != nil {
 		a.MinecraftConn.Close()
 	}
 
-	db.Close()
+	if a.Store != nil {
+		a.Store.Close()
+	}
 }
 
 func onApplicationCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -194,7 +397,12 @@ func onApplicationCommand(s *discordgo.Session, i *discordgo.InteractionCreate)
 func (a *App) onUserLeft(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
 	user := m.User.ID
 	log.Println("user left" + user)
-	a.removeWhitelist(user)
+	if a.Config.DepartureGracePeriod > 0 {
+		a.departMember(user)
+	} else {
+		a.removeWhitelist(entities.AuditSystem, user, "Left the Discord server")
+	}
+	a.withdrawApplications(s, user)
 }
 
 func (a *App) onDiscordMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -213,12 +421,28 @@ func (a *App) onDiscordMessage(s *discordgo.Session, m *discordgo.MessageCreate)
 		}
 
 		// Filter Discord → Minecraft with blacklist
-		if a.isBlacklisted(m.Content) {
+		blocked := a.isBlacklisted(m.Content)
+		a.archiveChat(entities.ChatMessage{
+			Source:   entities.ChatFromDiscord,
+			Author:   m.Author.DisplayName(),
+			AuthorID: m.Author.ID,
+			Content:  m.Content,
+			Blocked:  blocked,
+		})
+		if blocked {
 			log.Printf("Blocked blacklisted Discord message: %s", m.Content)
 			err := a.DiscordSession.ChannelMessageDelete(m.ChannelID, m.ID)
 			if err != nil {
 				log.Printf("Failed to delete message: %v", err)
 			}
+			a.audit(entities.AuditEntry{
+				ActorID:    entities.AuditSystem,
+				Action:     entities.AuditChatDelete,
+				TargetUser: m.Author.ID,
+				Target:     truncate(m.Content, 200),
+				Reason:     "Blacklisted word in chat",
+				Result:     auditResult(err),
+			})
 			return
 		}
 
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"limpan/rotaria-bot/entities"
 	"limpan/rotaria-bot/internals/db"
 	"limpan/rotaria-bot/namemc"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -86,23 +88,24 @@ func showWhitelistModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
 }
 
 // add plan parameter
-func (a *App) sendWLForReview(s *discordgo.Session, mcUsername, discordId, age, plan string) {
+func (a *App) sendWLForReview(s *discordgo.Session, app *entities.Application) {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
-	embed := &discordgo.MessageEmbed{
-		Title:       "Whitelist Request",
-		Description: "A new whitelist request has been submitted.",
-		Color:       0x3B82F6, // blue
-		Fields: []*discordgo.MessageEmbedField{
-			{Name: "Applicant", Value: fmt.Sprintf("<@%s>", discordId), Inline: true},
-			{Name: "Minecraft Username", Value: fmt.Sprintf("`%s`", mcUsername), Inline: true},
-			{Name: "Age", Value: age, Inline: true},
-			{Name: "Plan on the Server", Value: plan, Inline: false}, // 👈 shows the modal text
-		},
-		Footer:    &discordgo.MessageEmbedFooter{Text: "Rotaria Whitelist"},
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	embed := applicationEmbed(app)
+	embed.Description = "A new whitelist request has been submitted."
+	// Show staff what the member already has when this would be an alt.
+	if linked, err := a.Store.GetWhitelistEntries(context.Background(), app.DiscordID); err == nil && len(linked) > 0 {
+		names := make([]string, len(linked))
+		for n, e := range linked {
+			names[n] = fmt.Sprintf("`%s`", e.MinecraftUsername)
+		}
+		embed.Description = "A request for an additional account has been submitted."
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("Linked Accounts (%s)", accountSlots(len(linked), a.Config.MaxAccountsPerMember)),
+			Value: strings.Join(names, ", "),
+		})
 	}
 
 	components := []discordgo.MessageComponent{
@@ -111,23 +114,27 @@ func (a *App) sendWLForReview(s *discordgo.Session, mcUsername, discordId, age,
 				discordgo.Button{
 					Label:    "Approve",
 					Style:    discordgo.SuccessButton,
-					CustomID: fmt.Sprintf("approve_%s|%s", mcUsername, discordId),
+					CustomID: fmt.Sprintf("application_approve_%d", app.ID),
 				},
 				discordgo.Button{
 					Label:    "Reject",
 					Style:    discordgo.DangerButton,
-					CustomID: fmt.Sprintf("reject_%s|%s", mcUsername, discordId),
+					CustomID: fmt.Sprintf("application_reject_%d", app.ID),
 				},
 			},
 		},
 	}
 
-	_, err := s.ChannelMessageSendComplex(a.Config.WhitelistRequestsChannelID, &discordgo.MessageSend{
+	msg, err := s.ChannelMessageSendComplex(a.Config.WhitelistRequestsChannelID, &discordgo.MessageSend{
 		Embeds:     []*discordgo.MessageEmbed{embed},
 		Components: components,
 	})
 	if err != nil {
 		log.Printf("Error sending whitelist review message: %v", err)
+		return
+	}
+	if err := a.Store.SetApplicationMessage(context.Background(), app.ID, msg.ChannelID, msg.ID); err != nil {
+		log.Printf("Error recording review message for application %d: %v", app.ID, err)
 	}
 }
 
@@ -161,8 +168,25 @@ func (a *App) onWhitelistModalSubmitted(s *discordgo.Session, i *discordgo.Inter
 
 		log.Printf("UUID for Minecraft username %s: %s", minecraftUsername, uuid)
 
+		app := &entities.Application{
+			DiscordID:         submittingUser.ID,
+			MinecraftUsername: minecraftUsername,
+			MinecraftUUID:     uuid,
+			Age:               age,
+			Plan:              plan,
+		}
+		if err := a.Store.CreateApplication(context.Background(), app); err != nil {
+			if errors.Is(err, db.ErrApplicationPending) {
+				respondEphemeral(s, i, "⏳ You already have a whitelist application waiting for review.")
+				return
+			}
+			log.Printf("Error saving whitelist application for %s: %v", submittingUser.ID, err)
+			respondEphemeral(s, i, "❌ Something went wrong saving your application. Please try again later.")
+			return
+		}
+
 		// 👇 pass plan to review embed
-		a.sendWLForReview(s, minecraftUsername, submittingUser.ID, age, plan)
+		a.sendWLForReview(s, app)
 
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -223,7 +247,9 @@ func (a *App) onWhitelistModalResponse(s *discordgo.Session, i *discordgo.Intera
 				})
 			}
 
-			cp.Footer = &discordgo.MessageEmbedFooter{Text: "Rotaria Whitelist"}
+			if cp.Footer == nil {
+				cp.Footer = &discordgo.MessageEmbedFooter{Text: "Rotaria Whitelist"}
+			}
 			cp.Timestamp = time.Now().UTC().Format(time.RFC3339)
 			edited = &cp
 		} else {
@@ -246,52 +272,121 @@ func (a *App) onWhitelistModalResponse(s *discordgo.Session, i *discordgo.Intera
 	}
 
 	switch {
+	case strings.HasPrefix(customID, "application_approve_"), strings.HasPrefix(customID, "application_reject_"):
+		approved := strings.HasPrefix(customID, "application_approve_")
+		id, err := strconv.ParseInt(customID[strings.LastIndex(customID, "_")+1:], 10, 64)
+		if err != nil {
+			log.Printf("Invalid application customID %q", customID)
+			return
+		}
+		ctx := context.Background()
+		app, err := a.Store.GetApplication(ctx, id)
+		if err != nil || app == nil {
+			log.Printf("Error loading application %d: %v", id, err)
+			respondEphemeral(s, i, fmt.Sprintf("❌ Could not load application #%d.", id))
+			return
+		}
+		if app.Status != entities.ApplicationPending {
+			respondEphemeral(s, i, fmt.Sprintf("⚠️ Application #%d was already %s.", id, app.Status))
+			return
+		}
+
+		entry := entities.AuditEntry{
+			ActorID:    staffID,
+			Action:     entities.AuditApplicationApprove,
+			TargetUser: app.DiscordID,
+			Target:     fmt.Sprintf("#%d %s", id, app.MinecraftUsername),
+		}
+		if !approved {
+			entry.Action = entities.AuditApplicationReject
+			err := a.Store.DecideApplication(ctx, id, entities.ApplicationRejected, staffID)
+			entry.Result = auditResult(err)
+			a.audit(entry)
+			if err != nil {
+				log.Printf("Error rejecting application %d: %v", id, err)
+				respondEphemeral(s, i, fmt.Sprintf("⚠️ Could not reject application #%d: %v", id, err))
+				return
+			}
+			updateEmbed(false, app.MinecraftUsername, app.DiscordID)
+			return
+		}
+
+		// Claim the decision first so two staff clicking approve can't both
+		// whitelist the player.
+		if err := a.Store.DecideApplication(ctx, id, entities.ApplicationApproved, staffID); err != nil {
+			entry.Result = auditResult(err)
+			a.audit(entry)
+			log.Printf("Error approving application %d: %v", id, err)
+			respondEphemeral(s, i, fmt.Sprintf("⚠️ Could not approve application #%d: %v", id, err))
+			return
+		}
+		// addWhitelist also queues the command for the MC mod
+		if err := a.addWhitelist(app.DiscordID, app.MinecraftUsername, app.MinecraftUUID); err != nil {
+			// Back to pending, so it can be approved once the conflict is
+			// sorted out.
+			if rerr := a.Store.ReopenApplication(ctx, id, entities.ApplicationApproved); rerr != nil {
+				log.Printf("Error reopening application %d: %v", id, rerr)
+			}
+			entry.Result = auditResult(err)
+			a.audit(entry)
+			respondEphemeral(s, i, whitelistConflictMessage(err, app.MinecraftUsername, app.DiscordID))
+			return
+		}
+		a.audit(entry)
+		a.welcomeWhitelisted(s, app.DiscordID, app.MinecraftUsername)
+		updateEmbed(true, app.MinecraftUsername, app.DiscordID)
+
+	// Requests posted before applications were stored carry the player in
+	// the customID: approve_<name>|<discord id>[|<uuid>].
 	case strings.HasPrefix(customID, "approve_"):
 		data := strings.TrimPrefix(customID, "approve_")
-		parts := strings.SplitN(data, "|", 2)
-		if len(parts) != 2 {
+		parts := strings.SplitN(data, "|", 3)
+		if len(parts) < 2 {
 			log.Println("Invalid approve_ customID format")
 			return
 		}
 		username := parts[0]
 		requester := parts[1]
-
-		a.addWhitelist(requester, username)
-
-		// tell the MC mod (already in your code)
-		ctx := context.Background()
-		if a.MinecraftConn != nil {
-			if _, err := a.MinecraftConn.Send(ctx, []byte(fmt.Sprintf("whitelist add %s\n", username))); err != nil {
-				log.Printf("Error sending to Minecraft mod: %v", err)
-			}
+		uuid := "" // requests posted before UUIDs were stored
+		if len(parts) == 3 {
+			uuid = parts[2]
 		}
 
-		// role assignment (already in your code)
-		if err := s.GuildMemberRoleAdd(a.Config.GuildID, requester, a.Config.MemberRoleID); err != nil {
-			log.Printf("Failed to assign role to %s: %v", requester, err)
+		// addWhitelist also queues the command for the MC mod
+		err := a.addWhitelist(requester, username, uuid)
+		a.audit(entities.AuditEntry{
+			ActorID:    staffID,
+			Action:     entities.AuditApplicationApprove,
+			TargetUser: requester,
+			Target:     username,
+			Result:     auditResult(err),
+		})
+		if err != nil {
+			respondEphemeral(s, i, whitelistConflictMessage(err, username, requester))
+			return
 		}
 
+		a.welcomeWhitelisted(s, requester, username)
+
 		// ✅ EDIT the embed, keeping the Plan field visible
 		updateEmbed(true, username, requester)
 
-		// Optional: DM the user (include their plan if you want)
-		if dm, err := s.UserChannelCreate(requester); err == nil {
-			_, _ = s.ChannelMessageSend(dm.ID, fmt.Sprintf(
-				"✅ You have been whitelisted on Rotaria!\nWelcome, `%s` 🎉",
-				username,
-			))
-		}
-
 	case strings.HasPrefix(customID, "reject_"):
 		data := strings.TrimPrefix(customID, "reject_")
-		parts := strings.SplitN(data, "|", 2)
-		if len(parts) != 2 {
+		parts := strings.SplitN(data, "|", 3)
+		if len(parts) < 2 {
 			log.Println("Invalid reject_ customID format")
 			return
 		}
 		username := parts[0]
 		requester := parts[1]
 
+		a.audit(entities.AuditEntry{
+			ActorID:    staffID,
+			Action:     entities.AuditApplicationReject,
+			TargetUser: requester,
+			Target:     username,
+		})
 		updateEmbed(false, username, requester)
 
 	default:
@@ -299,63 +394,128 @@ func (a *App) onWhitelistModalResponse(s *discordgo.Session, i *discordgo.Intera
 	}
 }
 
-func (a *App) addWhitelist(discordId, minecraftUsername string) {
+// welcomeWhitelisted gives a newly approved member the role and a DM.
+func (a *App) welcomeWhitelisted(s *discordgo.Session, discordID, minecraftUsername string) {
+	if err := s.GuildMemberRoleAdd(a.Config.GuildID, discordID, a.Config.MemberRoleID); err != nil {
+		log.Printf("Failed to assign role to %s: %v", discordID, err)
+	}
+	if dm, err := s.UserChannelCreate(discordID); err == nil {
+		_, _ = s.ChannelMessageSend(dm.ID, fmt.Sprintf(
+			"✅ You have been whitelisted on Rotaria!\nWelcome, `%s` 🎉",
+			minecraftUsername,
+		))
+	}
+}
+
+// whitelistConflictMessage explains to staff why an approval didn't go through.
+func whitelistConflictMessage(err error, username, requester string) string {
+	var conflict *db.ConflictError
+	switch {
+	case errors.As(err, &conflict) && errors.Is(err, db.ErrAccountLimit):
+		return fmt.Sprintf("⚠️ <@%s> is already whitelisted as `%s` and has no free account slots. Remove an account first if they want to switch.",
+			requester, conflict.Existing.MinecraftUsername)
+	case errors.As(err, &conflict) && errors.Is(err, db.ErrUsernameClaimed) && conflict.Existing.DiscordID == requester:
+		return fmt.Sprintf("⚠️ `%s` is already linked to <@%s>.", conflict.Existing.MinecraftUsername, requester)
+	case errors.As(err, &conflict) && errors.Is(err, db.ErrUsernameClaimed):
+		return fmt.Sprintf("⚠️ `%s` is already claimed by <@%s> (stored as `%s`).",
+			username, conflict.Existing.DiscordID, conflict.Existing.MinecraftUsername)
+	case errors.Is(err, db.ErrAccountLimit), errors.Is(err, db.ErrUsernameClaimed):
+		return fmt.Sprintf("⚠️ Could not whitelist `%s`: %v", username, err)
+	}
+	return fmt.Sprintf("❌ Failed to whitelist `%s` for <@%s>. Check the bot logs.", username, requester)
+}
+
+func (a *App) addWhitelist(discordId, minecraftUsername, minecraftUUID string) error {
+	if minecraftUUID == "" {
+		uuid, err := namemc.New().UsernameToUUID(minecraftUsername)
+		if err != nil {
+			log.Printf("Could not resolve UUID for %s, storing name only: %v", minecraftUsername, err)
+		}
+		minecraftUUID = uuid
+	}
 	whitelistEntry := entities.WhiteListEntry{
 		DiscordID:         discordId,
 		MinecraftUsername: minecraftUsername,
+		MinecraftUUID:     namemc.NormalizeUUID(minecraftUUID),
 	}
 
-	err := db.AddWhitelistDatabaseEntry(whitelistEntry)
+	ctx := context.Background()
+	err := a.Store.AddWhitelistEntry(ctx, whitelistEntry, a.Config.MaxAccountsPerMember)
+	var conflict *db.ConflictError
+	if errors.As(err, &conflict) && errors.Is(err, db.ErrUsernameClaimed) &&
+		whitelistEntry.MinecraftUUID != "" && conflict.Existing.MinecraftUUID != "" &&
+		conflict.Existing.MinecraftUUID != whitelistEntry.MinecraftUUID {
+		// A different account held this name before a rename we haven't
+		// picked up yet; catch that row up and try again.
+		a.refreshMinecraftName(&conflict.Existing)
+		err = a.Store.AddWhitelistEntry(ctx, whitelistEntry, a.Config.MaxAccountsPerMember)
+	}
 	if err != nil {
 		log.Printf("Error adding whitelist entry for Discord ID %s: %v", discordId, err)
-		return
-	}
-
-	if a.MinecraftConn == nil {
-		log.Println("Minecraft connection is not established. I will not add the user to the whitelist")
-		return
+		return err
 	}
 
 	msg := fmt.Sprintf("whitelist add %s\n", minecraftUsername)
-	ctx := context.Background()
-	_, err = a.MinecraftConn.Send(ctx, []byte(msg))
+	err = a.queueBridgeCommand(msg, bridgePriorityNormal, playerHeaders(whitelistEntry))
 	if err != nil {
-		log.Printf("Error sending to Minecraft mod: %v", err)
+		log.Printf("Error queueing whitelist add for %s: %v", minecraftUsername, err)
 	}
 
 	log.Printf("Added %s to whitelist (Discord ID: %s)", minecraftUsername, discordId)
+	return nil
 }
 
-func (a *App) removeWhitelist(discordId string) {
-	whitelistEntry, err := db.GetWhitelistEntry(discordId)
+// removeWhitelist takes all of a member's accounts off the whitelist and
+// records who did it and why in the audit log.
+func (a *App) removeWhitelist(actorID, discordId, reason string) {
+	entries, err := a.Store.GetWhitelistEntries(context.Background(), discordId)
 	if err != nil {
-		log.Printf("Error retrieving whitelist entry for Discord ID %s: %v", discordId, err)
+		log.Printf("Error retrieving whitelist entries for Discord ID %s: %v", discordId, err)
 		return
 	}
-	if whitelistEntry == nil {
+	if len(entries) == 0 {
 		log.Printf("No whitelist entry found for Discord ID %s", discordId)
 		return
 	}
+	for i := range entries {
+		a.removeWhitelistEntry(actorID, &entries[i], reason)
+	}
+}
 
-	if a.MinecraftConn == nil {
-		log.Println("Minecraft connection is not established. I will not remove the user from the whitelist")
-		return
+// removeWhitelistEntry takes one account off the whitelist.
+func (a *App) removeWhitelistEntry(actorID string, whitelistEntry *entities.WhiteListEntry, reason string) error {
+	ctx := context.Background()
+	discordId := whitelistEntry.DiscordID
+
+	// The mod works on names, so make sure we have the current one.
+	a.refreshMinecraftName(whitelistEntry)
+
+	audit := entities.AuditEntry{
+		ActorID:    actorID,
+		Action:     entities.AuditWhitelistRemove,
+		TargetUser: discordId,
+		Target:     whitelistEntry.MinecraftUsername,
+		Reason:     reason,
 	}
 
 	msg := fmt.Sprintf("unwhitelist %s\n", whitelistEntry.MinecraftUsername)
-	ctx := context.Background()
-	_, err = a.MinecraftConn.Send(ctx, []byte(msg))
+	err := a.queueBridgeCommand(msg, bridgePriorityNormal, playerHeaders(*whitelistEntry))
 	if err != nil {
-		log.Printf("Error sending to Minecraft mod: %v", err)
+		log.Printf("Error queueing unwhitelist for %s: %v", whitelistEntry.MinecraftUsername, err)
+		audit.Result = "unwhitelist not queued: " + err.Error()
 	}
 
-	err = db.RemoveWhitelistDatabaseEntry(whitelistEntry.ID)
+	err = a.Store.RemoveWhitelistEntry(ctx, whitelistEntry.ID)
 	if err != nil {
 		log.Printf("Error removing whitelist entry for Discord ID %s: %v", discordId, err)
-		return
+		audit.Result = auditResult(err)
+		a.audit(audit)
+		return err
 	}
+	a.audit(audit)
 
 	log.Printf("Removed %s from whitelist (Discord ID: %s)", whitelistEntry.MinecraftUsername, discordId)
+	return nil
 }
 
 func (a *App) executeNonPrivilagedCommand(s *discordgo.Session, i *discordgo.InteractionCreate, command string) string {
@@ -375,19 +535,26 @@ func (a *App) executeNonPrivilagedCommand(s *discordgo.Session, i *discordgo.Int
 	return string(response)
 }
 
-func (a *App) kickPlayer(minecraftUsername string) {
-	if a.MinecraftConn == nil {
-		log.Println("Minecraft connection is not established. Cannot kick the player")
-		return
+func (a *App) kickPlayer(actorID, minecraftUsername, reason string) {
+	headers := map[string]string{"minecraft_username": minecraftUsername}
+	audit := entities.AuditEntry{
+		ActorID: actorID,
+		Action:  entities.AuditPlayerKick,
+		Target:  minecraftUsername,
+		Reason:  reason,
+	}
+	if entry, err := a.Store.GetWhitelistEntryByUsername(context.Background(), minecraftUsername); err == nil && entry != nil {
+		headers = playerHeaders(*entry)
+		audit.TargetUser = entry.DiscordID
 	}
-
 	msg := fmt.Sprintf("kick %s\n", minecraftUsername)
-	ctx := context.Background()
-	_, err := a.MinecraftConn.Send(ctx, []byte(msg))
+	err := a.queueBridgeCommand(msg, bridgePriorityModeration, headers)
+	audit.Result = auditResult(err)
+	a.audit(audit)
 	if err != nil {
-		log.Printf("Error sending kick command to Minecraft mod: %v", err)
+		log.Printf("Error queueing kick command: %v", err)
 		return
 	}
 
-	log.Printf("Sent kick command for player %s (Discord ID: %s)", minecraftUsername)
+	log.Printf("Queued kick command for player %s", minecraftUsername)
 }
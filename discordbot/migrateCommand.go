@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/internals/db"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = "usage: rotaria-bot migrate status|up [N]|down [N]"
+
+// runMigrateCommand handles `rotaria-bot migrate ...` against the configured
+// database without starting the bot.
+func runMigrateCommand(path string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid step count %q\n%s", args[1], migrateUsage)
+		}
+		steps = n
+	}
+
+	if path == "" {
+		return fmt.Errorf("DatabaseConfigPath is not set")
+	}
+	store, err := db.Open(path)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "status":
+		states, err := db.MigrationStatus(ctx, store)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, st := range states {
+			applied := "pending"
+			if !st.AppliedAt.IsZero() {
+				applied = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, applied)
+		}
+		return w.Flush()
+	case "up":
+		n, err := db.MigrateUp(ctx, store, steps)
+		fmt.Printf("Applied %d migration(s)\n", n)
+		return err
+	case "down":
+		n, err := db.MigrateDown(ctx, store, steps)
+		fmt.Printf("Rolled back %d migration(s)\n", n)
+		return err
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/tcpbridge"
 	"log"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/rotaria-smp/discordwebhook"
 )
 
 func loadBlacklist(path string) ([]string, error) {
@@ -47,41 +48,12 @@ func (a *App) readMinecraftMessages() {
 	a.startStatusWorkers()
 
 	// Subscribe to Minecraft events
-	_, events, cancel := a.MinecraftConn.Subscribe(4096)
+	events, cancel := a.subscribeMinecraftEvents()
 	defer cancel()
 
-	// Chat sender (unchanged, still ~1 msg/sec)
-	out := make(chan discordwebhook.Message, 4096)
-	go func() {
-		tokens := make(chan struct{}, 5)
-		for i := 0; i < cap(tokens); i++ {
-			tokens <- struct{}{}
-		}
-		refill := time.NewTicker(1 * time.Second)
-		defer refill.Stop()
-		for {
-			select {
-			case <-refill.C:
-				select {
-				case tokens <- struct{}{}:
-				default:
-				}
-			case msg, ok := <-out:
-				if !ok {
-					return
-				}
-				<-tokens
-				if err := discordwebhook.SendMessage(a.Config.MessageWebhookUrl, msg); err != nil {
-					log.Printf("Error sending message to Discord: %v", err)
-				}
-			}
-		}
-	}()
-
 	for {
 		evt, ok := <-events
 		if !ok {
-			close(out)
 			return
 		}
 		body := strings.TrimSpace(string(evt.Body))
@@ -93,6 +65,7 @@ func (a *App) readMinecraftMessages() {
 		log.Printf("Received from Minecraft: topic=%v body=%q", evt.Topic, body)
 
 		if evt.Topic == entities.TopicStatus {
+			a.recordStatus(body)
 			latest := strings.TrimPrefix(body, "[UPDATE] ")
 
 			// push to workers
@@ -108,6 +81,11 @@ func (a *App) readMinecraftMessages() {
 			continue
 		}
 
+		switch evt.Topic {
+		case entities.TopicJoin, entities.TopicLeave, entities.TopicLifecycle:
+			a.trackSession(evt.Topic, body)
+		}
+
 		// Everything else goes to chat
 		var msg string
 		var fullUsername string
@@ -122,45 +100,101 @@ func (a *App) readMinecraftMessages() {
 		}
 
 		msg = content // only the message content for blacklist checking
+		blacklisted := msg != "" && a.isBlacklisted(msg)
 
-		if msg != "" && a.isBlacklisted(msg) {
+		if evt.Topic == entities.TopicChat && username != "" {
+			a.archiveChat(entities.ChatMessage{
+				Source:   entities.ChatFromMinecraft,
+				Author:   username,
+				AuthorID: a.playerUUID(username),
+				Content:  msg,
+				Blocked:  blacklisted || strings.Contains(msg, "@"),
+			})
+		}
+
+		if blacklisted {
 			log.Printf("Blocked blacklisted message from %s: %q", username, msg)
-			a.kickPlayer(username)
+			a.kickPlayer(entities.AuditSystem, username, fmt.Sprintf("Blacklisted word in chat: %q", msg))
 			continue
 		}
 
-		avatar := fmt.Sprintf("https://minotar.net/avatar/%s/128.png", username)
-		flag := discordwebhook.MessageFlagSuppressNotifications
-
-		genericEventUsername := "Rotaria"
-		rotariaAvatar := "https://cdn.discordapp.com/icons/1373389493218050150/24f94fe60c73b4af4956f10dbecb5919.webp"
-
-		message := discordwebhook.Message{
-			Content:   &msg,
-			Username:  &fullUsername,
-			AvatarURL: &avatar,
-			Flags:     &flag,
+		line := chatLine{
+			Username: fullUsername,
+			Avatar:   fmt.Sprintf("https://minotar.net/avatar/%s/128.png", username),
+			Content:  msg,
 		}
 		switch evt.Topic {
 		case entities.TopicJoin, entities.TopicLeave, entities.TopicLifecycle:
-			message.Username = &genericEventUsername
-			message.AvatarURL = &rotariaAvatar
+			line.Username = chatEventUsername
+			line.Avatar = chatEventAvatar
 		}
 
-		if strings.Contains(*message.Content, "@") {
-			log.Printf("Blocked blacklisted message from %s: %q", username, *message.Content)
+		if strings.Contains(line.Content, "@") {
+			log.Printf("Blocked blacklisted message from %s: %q", username, line.Content)
 
-			*message.Content = ""
+			line.Content = ""
 		}
 
-		if *message.Content != "" {
+		if line.Content != "" {
+			a.relayChat(line)
+		}
+	}
+}
+
+// subscribeMinecraftEvents prefers a disk-spilling subscription so bursts
+// (server start, mass joins) survive a rate-limited webhook. Falls back to a
+// plain in-memory subscription when no spill dir is configured.
+func (a *App) subscribeMinecraftEvents() (<-chan tcpbridge.Event, func()) {
+	if a.Config.EventSpillDir != "" {
+		sub, err := a.MinecraftConn.SubscribeSpill(tcpbridge.SpillOptions{
+			Dir:      a.Config.EventSpillDir,
+			Buffer:   4096,
+			MaxBytes: a.Config.EventSpillMaxBytes,
+		})
+		if err == nil {
+			return sub.Events(), sub.Cancel
+		}
+		log.Printf("Could not create spill subscriber in %s, falling back to memory: %v", a.Config.EventSpillDir, err)
+	}
+	_, events, cancel := a.MinecraftConn.Subscribe(4096)
+	return events, cancel
+}
+
+// startBridgeMonitor logs the events that had to be spilled to disk or were
+// dropped since the last check, so a slow consumer shows up in the logs and
+// not only in /queue status.
+func (a *App) startBridgeMonitor(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(bridgeMonitorInterval)
+		defer t.Stop()
+		var last tcpbridge.Status
+		for {
 			select {
-			case out <- message:
-			default:
-				log.Printf("chat queue full; dropping message")
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			st := a.MinecraftConn.Status()
+			if msg := eventLossReport(last, st); msg != "" {
+				log.Print(msg)
 			}
+			last = st
 		}
+	}()
+}
+
+const bridgeMonitorInterval = time.Minute
+
+// eventLossReport describes how the event counters grew from prev to cur, or
+// returns "" if nothing was spilled or dropped in between.
+func eventLossReport(prev, cur tcpbridge.Status) string {
+	spilled := cur.EventsSpilled - prev.EventsSpilled
+	dropped := cur.EventsDropped - prev.EventsDropped
+	if spilled == 0 && dropped == 0 {
+		return ""
 	}
+	return fmt.Sprintf("Minecraft events since the last check: %d spilled to disk, %d dropped (%d and %d since start)",
+		spilled, dropped, cur.EventsSpilled, cur.EventsDropped)
 }
 
 func (a *App) startStatusWorkers() {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/namemc"
+	"log"
+	"time"
+)
+
+// Whitelist rows are keyed by Mojang UUID; the stored name is only a cache.
+// A player who renames keeps their row (and the server keeps them whitelisted,
+// it stores UUIDs too), and the refresher catches the name up.
+const (
+	nameRefreshInterval = 6 * time.Hour
+	nameRefreshMaxAge   = 24 * time.Hour
+	nameRefreshBatch    = 50
+	nameLookupGap       = time.Second // stay well under Mojang's rate limit
+)
+
+func (a *App) startNameRefresher(ctx context.Context) {
+	go func() {
+		client := namemc.New()
+		t := time.NewTimer(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			a.refreshStaleNames(ctx, client)
+			t.Reset(nameRefreshInterval)
+		}
+	}()
+}
+
+func (a *App) refreshStaleNames(ctx context.Context, client *namemc.Client) {
+	entries, err := a.Store.ListStaleWhitelistEntries(ctx, time.Now().Add(-nameRefreshMaxAge), nameRefreshBatch)
+	if err != nil {
+		log.Printf("Failed to list whitelist entries for name refresh: %v", err)
+		return
+	}
+	for i := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		a.refreshEntryName(ctx, client, &entries[i])
+		time.Sleep(nameLookupGap)
+	}
+	if len(entries) > 0 {
+		log.Printf("Checked %d whitelist names against Mojang", len(entries))
+	}
+}
+
+// refreshMinecraftName brings entry's name up to date before it is used in a
+// command for the mod, which only understands names.
+func (a *App) refreshMinecraftName(entry *entities.WhiteListEntry) {
+	a.refreshEntryName(context.Background(), namemc.New(), entry)
+}
+
+// refreshEntryName looks the player up by UUID (or backfills the UUID for old
+// rows) and stores any change. On lookup errors entry is left as it was.
+func (a *App) refreshEntryName(ctx context.Context, client *namemc.Client, entry *entities.WhiteListEntry) {
+	if entry.MinecraftUUID == "" {
+		uuid, err := client.UsernameToUUID(entry.MinecraftUsername)
+		if err != nil {
+			log.Printf("Could not backfill UUID for %s: %v", entry.MinecraftUsername, err)
+			return
+		}
+		entry.MinecraftUUID = uuid
+		log.Printf("Backfilled UUID %s for %s", uuid, entry.MinecraftUsername)
+	} else {
+		name, err := client.UUIDToUsername(entry.MinecraftUUID)
+		if err != nil {
+			log.Printf("Could not look up name for UUID %s (%s): %v", entry.MinecraftUUID, entry.MinecraftUsername, err)
+			return
+		}
+		if name != entry.MinecraftUsername {
+			log.Printf("Player %s renamed to %s (UUID %s)", entry.MinecraftUsername, name, entry.MinecraftUUID)
+			entry.MinecraftUsername = name
+		}
+	}
+	if err := a.Store.UpdateWhitelistIdentity(ctx, entry.ID, entry.MinecraftUsername, entry.MinecraftUUID); err != nil {
+		log.Printf("Failed to update whitelist entry %d: %v", entry.ID, err)
+	}
+}
@@ -41,7 +41,12 @@ func (c *Client) UsernameToUUID(username string) (string, error) {
 	if resp.ID == "" {
 		return "", fmt.Errorf("uuid not found for %q", username)
 	}
-	return resp.ID, nil
+	return NormalizeUUID(resp.ID), nil
+}
+
+// NormalizeUUID returns uuid in Mojang's API form: lowercase hex, no dashes.
+func NormalizeUUID(uuid string) string {
+	return strings.ToLower(strings.ReplaceAll(uuid, "-", ""))
 }
 
 func (c *Client) UUIDToUsername(uuid string) (string, error) {
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/imq"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const dlqListLimit = 10
+
+func queueCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	return &discordgo.ApplicationCommand{
+		Name:                     "queue",
+		Description:              "Inspect the bridge command queues",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show the bridge connection, queue lengths and lost events",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+				Name:        "dlq",
+				Description: "Bridge commands that failed too many times",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "list",
+						Description: "Show dead-lettered commands",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "replay",
+						Description: "Send a dead-lettered command to the server again",
+						Options: []*discordgo.ApplicationCommandOption{
+							{
+								Type:        discordgo.ApplicationCommandOptionString,
+								Name:        "id",
+								Description: "Message ID from /queue dlq list",
+								Required:    true,
+							},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionSubCommand,
+						Name:        "purge",
+						Description: "Delete all dead-lettered commands",
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onQueueCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) > 0 && data.Options[0].Name == "status" {
+		respondEphemeralEmbed(s, i, a.queueStatusEmbed())
+		return
+	}
+	if len(data.Options) == 0 || data.Options[0].Name != "dlq" || len(data.Options[0].Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown queue command.")
+		return
+	}
+	dlq, ok := a.bridgeDLQ.(imq.Inspector)
+	if !ok {
+		respondEphemeral(s, i, "❌ The dead-letter queue is not available.")
+		return
+	}
+
+	ctx := context.Background()
+	sub := data.Options[0].Options[0]
+	switch sub.Name {
+	case "list":
+		msgs, err := dlq.Peek(ctx, dlqListLimit)
+		if err != nil {
+			log.Printf("Error listing DLQ: %v", err)
+			respondEphemeral(s, i, "❌ Could not read the dead-letter queue.")
+			return
+		}
+		if len(msgs) == 0 {
+			respondEphemeral(s, i, "✅ The dead-letter queue is empty.")
+			return
+		}
+		embed := &discordgo.MessageEmbed{
+			Title:       "Dead-lettered bridge commands",
+			Description: fmt.Sprintf("Showing %d of %d. Use `/queue dlq replay <id>` to retry one.", len(msgs), a.bridgeDLQ.Len()),
+			Color:       0xF59E0B, // amber
+			Footer:      &discordgo.MessageEmbedFooter{Text: "Rotaria Bridge"},
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		}
+		for _, m := range msgs {
+			embed.Fields = append(embed.Fields, dlqField(m))
+		}
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Embeds: []*discordgo.MessageEmbed{embed},
+				Flags:  discordgo.MessageFlagsEphemeral,
+			},
+		})
+
+	case "replay":
+		id := strings.TrimSpace(sub.Options[0].StringValue())
+		m, err := findDeadLetter(ctx, dlq, id)
+		if errors.Is(err, imq.ErrNotFound) {
+			respondEphemeral(s, i, fmt.Sprintf("❌ No dead-lettered command with ID `%s`.", id))
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading %s from DLQ: %v", id, err)
+			respondEphemeral(s, i, "❌ Could not read the dead-letter queue.")
+			return
+		}
+		// Publish before taking it off the DLQ, so a failed publish leaves it
+		// where it was.
+		err = a.bridgeQueue.Publish(ctx, replayMessage(m))
+		a.audit(entities.AuditEntry{
+			ActorID:    i.Member.User.ID,
+			Action:     entities.AuditQueueReplay,
+			TargetUser: m.Headers["discord_id"],
+			Target:     strings.TrimSpace(string(m.Body)),
+			Result:     auditResult(err),
+		})
+		if err != nil {
+			log.Printf("Error replaying %s: %v", id, err)
+			respondEphemeral(s, i, "❌ Could not requeue the command; it is still in the dead-letter queue.")
+			return
+		}
+		// ErrNotFound means someone else replayed it at the same time; their
+		// publish had the same ID, so it is only queued once.
+		if _, err := dlq.Take(ctx, id); err != nil && !errors.Is(err, imq.ErrNotFound) {
+			log.Printf("Error removing replayed %s from DLQ: %v", id, err)
+		}
+		log.Printf("DLQ message %s replayed by %s", id, i.Member.User.ID)
+		respondEphemeral(s, i, fmt.Sprintf("🔁 Requeued `%s`.", strings.TrimSpace(string(m.Body))))
+
+	case "purge":
+		n, err := dlq.Purge(ctx)
+		a.audit(entities.AuditEntry{
+			ActorID: i.Member.User.ID,
+			Action:  entities.AuditQueuePurge,
+			Target:  fmt.Sprintf("%d dead-lettered commands", n),
+			Result:  auditResult(err),
+		})
+		if err != nil {
+			log.Printf("Error purging DLQ: %v", err)
+			respondEphemeral(s, i, "❌ Could not purge the dead-letter queue.")
+			return
+		}
+		log.Printf("DLQ purged by %s (%d messages)", i.Member.User.ID, n)
+		respondEphemeral(s, i, fmt.Sprintf("🗑️ Removed %d dead-lettered commands.", n))
+
+	default:
+		respondEphemeral(s, i, "❌ Unknown queue command.")
+	}
+}
+
+func (a *App) queueStatusEmbed() *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     "Bridge status",
+		Color:     0x22C55E, // green
+		Footer:    &discordgo.MessageEmbedFooter{Text: "Rotaria Bridge"},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if a.MinecraftConn == nil {
+		embed.Description = "❌ The bridge has not been started."
+		embed.Color = 0xEF4444 // red
+		return embed
+	}
+	st := a.MinecraftConn.Status()
+	conn := "🟢 Connected"
+	if !st.Connected {
+		conn = "🔴 Disconnected"
+		embed.Color = 0xEF4444
+	}
+	conn += fmt.Sprintf("\nBreaker: %s", st.BreakerState)
+	if st.Codec != "" {
+		conn += fmt.Sprintf("\nCodec: %s", st.Codec)
+	}
+	if !st.LastHeartbeat.IsZero() && st.LastHeartbeat.Unix() > 0 {
+		conn += fmt.Sprintf("\nLast heartbeat <t:%d:R>", st.LastHeartbeat.Unix())
+	}
+	queueLen := func(q imq.Queue) string {
+		if q == nil {
+			return "—"
+		}
+		return fmt.Sprint(q.Len())
+	}
+	events := fmt.Sprintf("Spilled to disk: **%d**\nDropped: **%d**", st.EventsSpilled, st.EventsDropped)
+	if st.EventsDropped > 0 && st.Connected {
+		embed.Color = 0xF59E0B // amber
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{Name: "Connection", Value: conn, Inline: true},
+		{Name: "Commands", Value: fmt.Sprintf("Pending: **%s**\nDead-lettered: **%s**\nUnsent frames: **%d**",
+			queueLen(a.bridgeQueue), queueLen(a.bridgeDLQ), st.QueueLen), Inline: true},
+		{Name: "Events since start", Value: events, Inline: true},
+	}
+	if a.chatRelay != nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Chat relay",
+			Value:  fmt.Sprintf("Pending: **%d**\nDead-lettered: **%d**", a.chatRelay.q.Len(), a.chatRelay.dlq.Len()),
+			Inline: true,
+		})
+	}
+	return embed
+}
+
+// findDeadLetter returns the dead-lettered message with the given ID without
+// removing it.
+func findDeadLetter(ctx context.Context, dlq imq.Inspector, id string) (imq.Message, error) {
+	msgs, err := dlq.Peek(ctx, 0)
+	if err != nil {
+		return imq.Message{}, err
+	}
+	for _, m := range msgs {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return imq.Message{}, imq.ErrNotFound
+}
+
+// replayMessage is the copy of a dead letter that goes back on the bridge
+// queue. It gets an ID of its own: the original ID may still be remembered
+// by the queue, which would silently drop the replay. The ID is fixed per
+// dead letter, so replaying the same one twice only queues it once.
+func replayMessage(m imq.Message) imq.Message {
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	orig := m.ID
+	if o := headers["replay_of"]; o != "" {
+		orig = o
+	}
+	headers["replay_of"] = orig
+	return imq.Message{
+		ID:       fmt.Sprintf("%s-replay-%d", orig, m.EnqueuedAt.UnixNano()),
+		Body:     m.Body,
+		Headers:  headers,
+		Priority: m.Priority,
+	}
+}
+
+func dlqField(m imq.Message) *discordgo.MessageEmbedField {
+	var b strings.Builder
+	fmt.Fprintf(&b, "```%s```", truncate(strings.TrimSpace(string(m.Body)), 200))
+	fmt.Fprintf(&b, "Attempts: **%d** • queued <t:%d:R>\n", m.Attempts, m.EnqueuedAt.Unix())
+	if len(m.Headers) > 0 {
+		keys := make([]string, 0, len(m.Headers))
+		for k := range m.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "`%s`: %s\n", k, m.Headers[k])
+		}
+	}
+	if m.LastError != "" {
+		fmt.Fprintf(&b, "Last error: %s", truncate(m.LastError, 300))
+	}
+	return &discordgo.MessageEmbedField{Name: "ID " + m.ID, Value: truncate(b.String(), 1024)}
+}
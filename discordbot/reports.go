@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,7 +25,7 @@ func (a *App) onReportModalSubmitted(s *discordgo.Session, i *discordgo.Interact
 	reported := strings.TrimSpace(getModalInputValue(i, "reported_username"))
 	reason := strings.TrimSpace(getModalInputValue(i, "report_reason"))
 	evidence := strings.TrimSpace(getModalInputValue(i, "report_evidence"))
-	context := strings.TrimSpace(getModalInputValue(i, "report_context"))
+	where := strings.TrimSpace(getModalInputValue(i, "report_context"))
 
 	// normalize type
 	// switch reportType {
@@ -48,83 +53,162 @@ func (a *App) onReportModalSubmitted(s *discordgo.Session, i *discordgo.Interact
 		return
 	}
 
-	a.sendReportForReviewTyped(s, reporter.ID, reportType, reported, reason, evidence, context)
+	report := &entities.Report{
+		Type:       reportType,
+		ReporterID: reporter.ID,
+		Details:    reason,
+		Evidence:   evidence,
+		Context:    where,
+	}
+	if reportType == "player" {
+		report.Subject = reported
+	}
+	if err := a.Store.CreateReport(context.Background(), report); err != nil {
+		log.Printf("Error saving report from %s: %v", reporter.ID, err)
+		respondEphemeral(s, i, "❌ Could not submit your report. Please try again later.")
+		return
+	}
+	log.Printf("Report %s (%s) filed by %s", report.ShortID(), report.Type, reporter.ID)
+	a.sendReportForReview(s, report)
 
 	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("✅ Thanks! Your **%s** report has been submitted.", strings.Title(reportType)),
+			Content: fmt.Sprintf("✅ Thanks! Your **%s** report has been submitted as `%s`.", strings.Title(reportType), report.ShortID()),
 			Flags:   discordgo.MessageFlagsEphemeral,
 		},
 	})
 }
 
-func (a *App) sendReportForReviewTyped(
-	s *discordgo.Session,
-	reporterID, reportType, reported, reason, evidence, context string,
-) {
-	title := fmt.Sprintf("New %s Report", strings.Title(reportType))
+var reportColors = map[entities.ReportStatus]int{
+	entities.ReportOpen:      0xF44336, // red-ish
+	entities.ReportResolved:  0x22C55E, // green
+	entities.ReportDismissed: 0x6B7280, // gray
+}
 
+// reportEmbed renders a report from its stored record.
+func reportEmbed(r *entities.Report) *discordgo.MessageEmbed {
 	fields := []*discordgo.MessageEmbedField{
-		{Name: "Reporter", Value: fmt.Sprintf("<@%s>", reporterID), Inline: true},
-		{Name: "Type", Value: strings.Title(reportType), Inline: true},
+		{Name: "Reporter", Value: fmt.Sprintf("<@%s>", r.ReporterID), Inline: true},
+		{Name: "Type", Value: strings.Title(r.Type), Inline: true},
 	}
-
-	if reportType == "player" && strings.TrimSpace(reported) != "" {
+	if r.Subject != "" {
 		fields = append(fields, &discordgo.MessageEmbedField{
-			Name: "Reported Player", Value: fmt.Sprintf("`%s`", reported), Inline: true,
+			Name: "Reported Player", Value: fmt.Sprintf("`%s`", r.Subject), Inline: true,
 		})
 	}
+	if r.Details != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Details", Value: r.Details})
+	}
+	if r.Evidence != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Evidence", Value: r.Evidence})
+	}
+	if r.Context != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Context", Value: r.Context})
+	}
 
-	if strings.TrimSpace(reason) != "" {
-		fields = append(fields, &discordgo.MessageEmbedField{
-			Name: "Details", Value: reason, Inline: false,
-		})
+	status := strings.Title(string(r.Status))
+	if r.Status != entities.ReportOpen && !r.ClosedAt.IsZero() {
+		status += fmt.Sprintf(" <t:%d:R>", r.ClosedAt.Unix())
 	}
-	if strings.TrimSpace(evidence) != "" {
+	fields = append(fields, &discordgo.MessageEmbedField{Name: "Status", Value: status, Inline: true})
+	if r.AssigneeID != "" {
 		fields = append(fields, &discordgo.MessageEmbedField{
-			Name: "Evidence", Value: evidence, Inline: false,
+			Name: "Assignee", Value: fmt.Sprintf("<@%s>", r.AssigneeID), Inline: true,
 		})
 	}
-	if strings.TrimSpace(context) != "" {
-		fields = append(fields, &discordgo.MessageEmbedField{
-			Name: "Context", Value: context, Inline: false,
-		})
+	if r.Status != entities.ReportOpen {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Moderator Note", Value: ifEmpty(r.Resolution, "—")})
 	}
 
-	embed := &discordgo.MessageEmbed{
-		Title:       title,
-		Description: "A new report has been filed.",
-		Color:       0xF44336, // red-ish
+	description := "A new report has been filed."
+	if r.Status != entities.ReportOpen {
+		description = fmt.Sprintf("📝 This report was **%s**.", strings.Title(string(r.Status)))
+	}
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s • %s Report", r.ShortID(), strings.Title(r.Type)),
+		Description: description,
+		Color:       reportColors[r.Status],
 		Fields:      fields,
-		Footer:      &discordgo.MessageEmbedFooter{Text: "Rotaria Moderation"},
-		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Footer:      &discordgo.MessageEmbedFooter{Text: "Rotaria Moderation • " + r.ShortID()},
+		Timestamp:   r.CreatedAt.UTC().Format(time.RFC3339),
 	}
+}
 
-	components := []discordgo.MessageComponent{
+// reportComponents returns the review buttons; closed reports have none.
+func reportComponents(r *entities.Report) []discordgo.MessageComponent {
+	if r.Status != entities.ReportOpen {
+		return []discordgo.MessageComponent{}
+	}
+	return []discordgo.MessageComponent{
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Claim",
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("report_claim_%d", r.ID),
+				},
 				discordgo.Button{
 					Label:    "Mark as Resolved",
 					Style:    discordgo.SuccessButton,
-					CustomID: fmt.Sprintf("report_resolve_%s|%s", reported, reporterID),
+					CustomID: fmt.Sprintf("report_resolve_%d", r.ID),
 				},
 				discordgo.Button{
 					Label:    "Dismiss",
 					Style:    discordgo.DangerButton,
-					CustomID: fmt.Sprintf("report_dismiss_%s|%s", reported, reporterID),
+					CustomID: fmt.Sprintf("report_dismiss_%d", r.ID),
 				},
 			},
 		},
 	}
+}
 
-	_, err := s.ChannelMessageSendComplex(a.Config.ReportChannelID, &discordgo.MessageSend{
-		Embeds:     []*discordgo.MessageEmbed{embed},
-		Components: components,
+func (a *App) sendReportForReview(s *discordgo.Session, r *entities.Report) {
+	msg, err := s.ChannelMessageSendComplex(a.Config.ReportChannelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{reportEmbed(r)},
+		Components: reportComponents(r),
 	})
 	if err != nil {
 		log.Printf("Error sending report embed: %v", err)
+		return
 	}
+	if err := a.Store.SetReportMessage(context.Background(), r.ID, msg.ChannelID, msg.ID); err != nil {
+		log.Printf("Error saving review message for report %s: %v", r.ShortID(), err)
+	}
+}
+
+// errNoReviewMessage means the report's review message was never recorded
+// (posting it or saving its ID failed), so there is nothing to update.
+var errNoReviewMessage = errors.New("report has no review message")
+
+// refreshReportMessage re-renders the review message from the record.
+func (a *App) refreshReportMessage(s *discordgo.Session, r *entities.Report) error {
+	if r.MessageID == "" {
+		return errNoReviewMessage
+	}
+	components := reportComponents(r)
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Channel:    r.ChannelID,
+		ID:         r.MessageID,
+		Embeds:     &[]*discordgo.MessageEmbed{reportEmbed(r)},
+		Components: &components,
+	})
+	return err
+}
+
+// reportActionID splits "report_<action>_<id>" custom IDs. Messages posted
+// before reports were stored use "<reported>|<reporter>" instead of an ID
+// and report ok=false.
+func reportActionID(cid string) (action string, id int64, ok bool) {
+	for _, act := range []string{"claim", "resolve", "dismiss"} {
+		rest, found := strings.CutPrefix(cid, "report_"+act+"_")
+		if !found {
+			continue
+		}
+		id, err := strconv.ParseInt(rest, 10, 64)
+		return act, id, err == nil
+	}
+	return "", 0, false
 }
 
 func (a *App) onReportAction(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -133,6 +217,10 @@ func (a *App) onReportAction(s *discordgo.Session, i *discordgo.InteractionCreat
 	}
 	data := i.MessageComponentData()
 	cid := data.CustomID
+	if action, id, ok := reportActionID(cid); ok {
+		a.onStoredReportAction(s, i, action, id)
+		return
+	}
 	if !(strings.HasPrefix(cid, "report_resolve_") || strings.HasPrefix(cid, "report_dismiss_")) {
 		return
 	}
@@ -183,11 +271,139 @@ func (a *App) onReportAction(s *discordgo.Session, i *discordgo.InteractionCreat
 	})
 }
 
+func (a *App) onStoredReportAction(s *discordgo.Session, i *discordgo.InteractionCreate, action string, id int64) {
+	ctx := context.Background()
+	staffer := getSubmittingUser(i)
+	if action == "claim" {
+		err := a.Store.AssignReport(ctx, id, staffer.ID)
+		if err == nil || !errors.Is(err, db.ErrReportNotFound) {
+			a.audit(entities.AuditEntry{
+				ActorID: staffer.ID,
+				Action:  entities.AuditReportClaim,
+				Target:  entities.FormatReportID(id),
+				Result:  auditResult(err),
+			})
+		}
+		if err != nil {
+			respondEphemeral(s, i, reportErrorMessage(id, err))
+			return
+		}
+		log.Printf("Report %s claimed by %s", entities.FormatReportID(id), staffer.ID)
+		r, err := a.Store.GetReport(ctx, id)
+		if err != nil || r == nil {
+			log.Printf("Error reloading report %s: %v", entities.FormatReportID(id), err)
+			respondEphemeral(s, i, "✅ Claimed, but the message could not be refreshed.")
+			return
+		}
+		components := reportComponents(r)
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{reportEmbed(r)},
+				Components: components,
+			},
+		})
+		return
+	}
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: fmt.Sprintf("report_close_modal|%s|%d", action, id),
+			Title:    fmt.Sprintf("%s %s", strings.Title(action), entities.FormatReportID(id)),
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "moderator_note",
+							Label:       "Add a short note for the record",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: "What did you find/decide? Any guidance for the players?",
+							Required:    true,
+							MaxLength:   1000,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (a *App) onReportCloseModalSubmitted(s *discordgo.Session, i *discordgo.InteractionCreate, cid string) {
+	// Parse: report_close_modal|<action>|<id>
+	parts := strings.SplitN(cid, "|", 3)
+	if len(parts) != 3 {
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+	status := entities.ReportResolved
+	if parts[1] == "dismiss" {
+		status = entities.ReportDismissed
+	}
+	note := strings.TrimSpace(getModalInputValue(i, "moderator_note"))
+	staffer := getSubmittingUser(i)
+
+	ctx := context.Background()
+	err = a.Store.CloseReport(ctx, id, status, staffer.ID, note)
+	if err == nil || !errors.Is(err, db.ErrReportNotFound) {
+		action := entities.AuditReportResolve
+		if status == entities.ReportDismissed {
+			action = entities.AuditReportDismiss
+		}
+		a.audit(entities.AuditEntry{
+			ActorID: staffer.ID,
+			Action:  action,
+			Target:  entities.FormatReportID(id),
+			Reason:  note,
+			Result:  auditResult(err),
+		})
+	}
+	if err != nil {
+		respondEphemeral(s, i, reportErrorMessage(id, err))
+		return
+	}
+	log.Printf("Report %s %s by %s", entities.FormatReportID(id), status, staffer.ID)
+
+	r, err := a.Store.GetReport(ctx, id)
+	if err == nil && r != nil {
+		err = a.refreshReportMessage(s, r)
+	}
+	if errors.Is(err, errNoReviewMessage) {
+		respondEphemeral(s, i, fmt.Sprintf("⚠️ %s is %s, but it has no review message on record to update.", entities.FormatReportID(id), status))
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating review message for report %s: %v", entities.FormatReportID(id), err)
+		respondEphemeral(s, i, fmt.Sprintf("⚠️ %s is %s, but the report message could not be updated.", entities.FormatReportID(id), status))
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("✅ %s %s with note added.", entities.FormatReportID(id), status))
+}
+
+func reportErrorMessage(id int64, err error) string {
+	short := entities.FormatReportID(id)
+	switch {
+	case errors.Is(err, db.ErrReportNotFound):
+		return fmt.Sprintf("❌ No report %s.", short)
+	case errors.Is(err, db.ErrReportClosed):
+		return fmt.Sprintf("⚠️ %s has already been closed.", short)
+	}
+	log.Printf("Error updating report %s: %v", short, err)
+	return fmt.Sprintf("❌ Could not update %s.", short)
+}
+
 func (a *App) onReportActionModalSubmitted(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	if i.Type != discordgo.InteractionModalSubmit {
 		return
 	}
 	cid := i.ModalSubmitData().CustomID
+	if strings.HasPrefix(cid, "report_close_modal|") {
+		a.onReportCloseModalSubmitted(s, i, cid)
+		return
+	}
 	if !strings.HasPrefix(cid, "report_action_modal|") {
 		return
 	}
@@ -305,6 +521,17 @@ func (a *App) onReportActionModalSubmitted(s *discordgo.Session, i *discordgo.In
 		return
 	}
 
+	auditAction := entities.AuditReportResolve
+	if action == "dismiss" {
+		auditAction = entities.AuditReportDismiss
+	}
+	a.audit(entities.AuditEntry{
+		ActorID: staffer.ID,
+		Action:  auditAction,
+		Target:  fmt.Sprintf("report on %s by %s", ifEmpty(reported, "—"), reporter),
+		Reason:  note,
+	})
+
 	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
@@ -321,3 +548,165 @@ func ifEmpty(s, fallback string) string {
 	}
 	return s
 }
+
+const reportSearchLimit = 15
+
+func reportsCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	statusChoices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, st := range []entities.ReportStatus{entities.ReportOpen, entities.ReportResolved, entities.ReportDismissed} {
+		statusChoices = append(statusChoices, &discordgo.ApplicationCommandOptionChoice{Name: string(st), Value: string(st)})
+	}
+	typeChoices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, t := range []string{"player", "bug", "other"} {
+		typeChoices = append(typeChoices, &discordgo.ApplicationCommandOptionChoice{Name: t, Value: t})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:                     "reports",
+		Description:              "Search member reports",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "List reports, newest first",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "status",
+						Description: "Only reports in this state",
+						Choices:     statusChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "type",
+						Description: "Only reports of this type",
+						Choices:     typeChoices,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "player",
+						Description: "Part of the reported player's name",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "reporter",
+						Description: "Member who filed the report",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "assignee",
+						Description: "Staff member handling the report",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show one report in full",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "id",
+						Description: "Report ID, e.g. R-0042",
+						Required:    true,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onReportsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown reports command.")
+		return
+	}
+	ctx := context.Background()
+	sub := data.Options[0]
+	switch sub.Name {
+	case "search":
+		f := db.ReportFilter{Limit: reportSearchLimit}
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "status":
+				f.Status = entities.ReportStatus(opt.StringValue())
+			case "type":
+				f.Type = opt.StringValue()
+			case "player":
+				f.Subject = strings.TrimSpace(opt.StringValue())
+			case "reporter":
+				f.ReporterID = opt.UserValue(nil).ID
+			case "assignee":
+				f.AssigneeID = opt.UserValue(nil).ID
+			}
+		}
+		reports, err := a.Store.SearchReports(ctx, f)
+		if err != nil {
+			log.Printf("Error searching reports: %v", err)
+			respondEphemeral(s, i, "❌ Could not search reports.")
+			return
+		}
+		if len(reports) == 0 {
+			respondEphemeral(s, i, "🔎 No reports match.")
+			return
+		}
+		var b strings.Builder
+		for _, r := range reports {
+			b.WriteString(a.reportLine(&r))
+			b.WriteByte('\n')
+		}
+		respondEphemeralEmbed(s, i, &discordgo.MessageEmbed{
+			Title:       "Reports",
+			Description: truncate(b.String(), 4000),
+			Color:       reportColors[entities.ReportOpen],
+			Footer:      &discordgo.MessageEmbedFooter{Text: "Use /reports show <id> for details"},
+		})
+
+	case "show":
+		raw := sub.Options[0].StringValue()
+		id, ok := entities.ParseReportID(raw)
+		if !ok {
+			respondEphemeral(s, i, fmt.Sprintf("❌ `%s` is not a report ID.", raw))
+			return
+		}
+		r, err := a.Store.GetReport(ctx, id)
+		if err != nil {
+			log.Printf("Error loading report %s: %v", entities.FormatReportID(id), err)
+			respondEphemeral(s, i, "❌ Could not load that report.")
+			return
+		}
+		if r == nil {
+			respondEphemeral(s, i, fmt.Sprintf("❌ No report %s.", entities.FormatReportID(id)))
+			return
+		}
+		embed := reportEmbed(r)
+		if link := a.messageLink(r.ChannelID, r.MessageID); link != "" {
+			embed.Description += fmt.Sprintf("\n[Review message](%s)", link)
+		}
+		respondEphemeralEmbed(s, i, embed)
+
+	default:
+		respondEphemeral(s, i, "❌ Unknown reports command.")
+	}
+}
+
+func (a *App) reportLine(r *entities.Report) string {
+	line := fmt.Sprintf("`%s` %s", r.ShortID(), r.Type)
+	if r.Subject != "" {
+		line += fmt.Sprintf(" `%s`", r.Subject)
+	}
+	line += fmt.Sprintf(" by <@%s> • **%s** <t:%d:d>", r.ReporterID, r.Status, r.CreatedAt.Unix())
+	if r.AssigneeID != "" {
+		line += fmt.Sprintf(" → <@%s>", r.AssigneeID)
+	}
+	if link := a.messageLink(r.ChannelID, r.MessageID); link != "" {
+		line += fmt.Sprintf(" • [message](%s)", link)
+	}
+	return line
+}
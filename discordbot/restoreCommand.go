@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/internals/db"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+const restoreUsage = "usage: rotaria-bot restore list | rotaria-bot restore <backup file>"
+
+// runRestoreCommand handles `rotaria-bot restore ...`. The bot must be
+// stopped first; the current database is backed up before it is replaced,
+// so a restore can itself be undone.
+func runRestoreCommand(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(restoreUsage)
+	}
+	if cfg.DatabaseConfigPath == "" {
+		return fmt.Errorf("DatabaseConfigPath is not set")
+	}
+	d, _, path := db.ParseDSN(cfg.DatabaseConfigPath)
+	if d != db.SQLite {
+		return fmt.Errorf("restore only handles SQLite databases; restore %s with its own tools", d)
+	}
+	ctx := context.Background()
+	backups := &db.Backups{Dir: cfg.BackupDir}
+
+	if args[0] == "list" {
+		list, err := backups.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tSIZE\tTAKEN")
+		for _, bk := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", bk.Path, formatBytes(bk.Size), bk.At.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	}
+
+	src := args[0]
+	if _, err := os.Stat(src); err != nil {
+		// allow just the file name of a backup in BackupDir
+		if alt := filepath.Join(cfg.BackupDir, src); alt != src {
+			if _, err2 := os.Stat(alt); err2 == nil {
+				src = alt
+			} else {
+				return err
+			}
+		}
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		store, err := db.Open(path)
+		if err != nil {
+			return fmt.Errorf("open database: %w", err)
+		}
+		backups.Conn = store.Conn
+		safety, err := backups.Create(ctx)
+		store.Close()
+		if err != nil {
+			return fmt.Errorf("back up current database before restoring: %w", err)
+		}
+		fmt.Printf("Saved the current database to %s\n", safety.Path)
+	}
+
+	if err := db.Restore(ctx, src, path); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", path, src)
+	return nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Sessions are built from the mod's join and leave events. While the bridge
+// is up, open sessions are touched every sessionTouchInterval; if the bridge
+// drops or the bot restarts they are closed at that last touch, so a missed
+// leave costs at most one interval of playtime instead of inventing hours.
+const (
+	sessionTouchInterval = time.Minute
+	leaderboardSize      = 10
+)
+
+var minecraftNameRe = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+
+// playerEventName pulls the player out of "**Name** joined the server."
+// and "**Name** left the server.".
+func playerEventName(body string) (string, bool) {
+	rest, ok := strings.CutPrefix(body, "**")
+	if !ok {
+		return "", false
+	}
+	name, _, ok := strings.Cut(rest, "**")
+	if !ok || !minecraftNameRe.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// trackSession updates sessions for join, leave and shutdown events.
+func (a *App) trackSession(evt entities.Topic, body string) {
+	ctx := context.Background()
+	now := time.Now()
+	switch evt {
+	case entities.TopicJoin:
+		name, ok := playerEventName(body)
+		if !ok {
+			log.Printf("Could not read player from join event %q", body)
+			return
+		}
+		if err := a.Store.StartSession(ctx, name, a.playerUUID(name), now); err != nil {
+			log.Printf("Error starting session for %s: %v", name, err)
+		}
+	case entities.TopicLeave:
+		name, ok := playerEventName(body)
+		if !ok {
+			log.Printf("Could not read player from leave event %q", body)
+			return
+		}
+		if err := a.Store.EndSession(ctx, name, now, entities.SessionLeft); err != nil {
+			log.Printf("Error ending session for %s: %v", name, err)
+		}
+	case entities.TopicLifecycle:
+		if !strings.Contains(body, "shutting down") {
+			return
+		}
+		if err := a.Store.TouchOpenSessions(ctx, now); err != nil {
+			log.Printf("Error touching sessions: %v", err)
+		}
+		if n, err := a.Store.EndOpenSessions(ctx, entities.SessionShutdown); err != nil {
+			log.Printf("Error closing sessions at shutdown: %v", err)
+		} else if n > 0 {
+			log.Printf("Closed %d player sessions at server shutdown", n)
+		}
+	}
+}
+
+func (a *App) playerUUID(name string) string {
+	entry, err := a.Store.GetWhitelistEntryByUsername(context.Background(), name)
+	if err != nil || entry == nil {
+		return ""
+	}
+	return entry.MinecraftUUID
+}
+
+// startSessionTracker closes sessions left open by the last run and then
+// keeps open sessions fresh, closing them when the bridge goes down and
+// picking up whoever is online when it comes back.
+func (a *App) startSessionTracker(ctx context.Context) {
+	if n, err := a.Store.EndOpenSessions(ctx, entities.SessionRestart); err != nil {
+		log.Printf("Error closing sessions from the previous run: %v", err)
+	} else if n > 0 {
+		log.Printf("Closed %d player sessions left open by the previous run", n)
+	}
+	go func() {
+		t := time.NewTicker(sessionTouchInterval)
+		defer t.Stop()
+		wasUp := false
+		for {
+			up := a.MinecraftConn != nil && a.MinecraftConn.Status().Connected
+			switch {
+			case up && !wasUp:
+				a.resumeOnlineSessions(ctx)
+			case up:
+				if err := a.Store.TouchOpenSessions(ctx, time.Now()); err != nil {
+					log.Printf("Error touching sessions: %v", err)
+				}
+			case wasUp:
+				if n, err := a.Store.EndOpenSessions(ctx, entities.SessionOutage); err != nil {
+					log.Printf("Error closing sessions after bridge outage: %v", err)
+				} else if n > 0 {
+					log.Printf("Bridge down; closed %d player sessions", n)
+				}
+			}
+			wasUp = up
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// resumeOnlineSessions asks the server who is on and opens sessions for them,
+// since their join events happened while we weren't listening.
+func (a *App) resumeOnlineSessions(ctx context.Context) {
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := a.MinecraftConn.Send(cctx, []byte("commandexec list\n"))
+	if err != nil {
+		log.Printf("Could not list online players to resume sessions: %v", err)
+		return
+	}
+	names := parseOnlinePlayers(string(resp))
+	now := time.Now()
+	for _, name := range names {
+		if err := a.Store.StartSession(ctx, name, a.playerUUID(name), now); err != nil {
+			log.Printf("Error starting session for %s: %v", name, err)
+		}
+	}
+	if len(names) > 0 {
+		log.Printf("Resumed sessions for %d online players", len(names))
+	}
+}
+
+// parseOnlinePlayers reads the vanilla /list reply:
+// "There are 2 of a max of 20 players online: Steve, Alex".
+func parseOnlinePlayers(resp string) []string {
+	i := strings.LastIndex(resp, ":")
+	if i < 0 {
+		return nil
+	}
+	var names []string
+	for _, n := range strings.Split(resp[i+1:], ",") {
+		if n = strings.TrimSpace(n); minecraftNameRe.MatchString(n) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+var playtimePeriods = []struct {
+	name string
+	d    time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"all", 0},
+}
+
+func periodOption(description string) *discordgo.ApplicationCommandOption {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, p := range playtimePeriods {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: p.name, Value: p.name})
+	}
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "period",
+		Description: description,
+		Choices:     choices,
+	}
+}
+
+// periodStart returns when the named period began; zero means all time.
+func periodStart(name string, now time.Time) time.Time {
+	for _, p := range playtimePeriods {
+		if p.name == name && p.d > 0 {
+			return now.Add(-p.d)
+		}
+	}
+	return time.Time{}
+}
+
+func periodLabel(name string) string {
+	if name == "" || name == "all" {
+		return "all time"
+	}
+	return "the last " + name
+}
+
+func sessionCommands() []*discordgo.ApplicationCommand {
+	return []*discordgo.ApplicationCommand{
+		{
+			Name:        "playtime",
+			Description: "Show how long a player has been on the server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "player",
+					Description: "Minecraft username (defaults to yours)",
+				},
+				periodOption("Only count this period (default: all time)"),
+			},
+		},
+		{
+			Name:        "seen",
+			Description: "Show when a player was last on the server",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "player",
+					Description: "Minecraft username",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "leaderboard",
+			Description: "Top players by playtime",
+			Options:     []*discordgo.ApplicationCommandOption{periodOption("Only count this period (default: all time)")},
+		},
+	}
+}
+
+func (a *App) onPlaytimeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var player, period string
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "player":
+			player = strings.TrimSpace(opt.StringValue())
+		case "period":
+			period = opt.StringValue()
+		}
+	}
+	ctx := context.Background()
+	if player == "" {
+		user := getSubmittingUser(i)
+		// Members with alts get their oldest (main) account.
+		entries, err := a.Store.GetWhitelistEntries(ctx, user.ID)
+		if err != nil || len(entries) == 0 {
+			respondEphemeral(s, i, "❌ You're not whitelisted; name a player to look up.")
+			return
+		}
+		player = entries[0].MinecraftUsername
+	}
+	now := time.Now()
+	p, err := a.Store.GetPlaytime(ctx, player, periodStart(period, now), now)
+	if err != nil {
+		log.Printf("Error loading playtime for %s: %v", player, err)
+		respondEphemeral(s, i, "❌ Could not load playtime.")
+		return
+	}
+	if p == nil {
+		respondEphemeral(s, i, fmt.Sprintf("🔎 `%s` hasn't played in %s.", player, periodLabel(period)))
+		return
+	}
+	msg := fmt.Sprintf("⏱️ `%s` has played **%s** over %d sessions in %s.",
+		p.MinecraftUsername, formatPlaytime(p.Total), p.Sessions, periodLabel(period))
+	if p.Online {
+		msg += " 🟢 Online now."
+	}
+	respondEphemeral(s, i, msg)
+}
+
+func (a *App) onSeenCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	player := strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue())
+	p, err := a.Store.GetPlaytime(context.Background(), player, time.Time{}, time.Now())
+	if err != nil {
+		log.Printf("Error loading sessions for %s: %v", player, err)
+		respondEphemeral(s, i, "❌ Could not look that player up.")
+		return
+	}
+	switch {
+	case p == nil:
+		respondEphemeral(s, i, fmt.Sprintf("🔎 `%s` has never been seen on the server.", player))
+	case p.Online:
+		respondEphemeral(s, i, fmt.Sprintf("🟢 `%s` is online right now.", p.MinecraftUsername))
+	default:
+		respondEphemeral(s, i, fmt.Sprintf("👋 `%s` was last seen <t:%d:R> (<t:%d:f>).",
+			p.MinecraftUsername, p.LastSeen.Unix(), p.LastSeen.Unix()))
+	}
+}
+
+func (a *App) onLeaderboardCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var period string
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "period" {
+			period = opt.StringValue()
+		}
+	}
+	now := time.Now()
+	top, err := a.Store.TopPlaytime(context.Background(), periodStart(period, now), now, leaderboardSize)
+	if err != nil {
+		log.Printf("Error loading playtime leaderboard: %v", err)
+		respondEphemeral(s, i, "❌ Could not load the leaderboard.")
+		return
+	}
+	if len(top) == 0 {
+		respondEphemeral(s, i, fmt.Sprintf("🔎 Nobody has played in %s.", periodLabel(period)))
+		return
+	}
+	var b strings.Builder
+	for n, p := range top {
+		fmt.Fprintf(&b, "**%d.** `%s` — %s", n+1, p.MinecraftUsername, formatPlaytime(p.Total))
+		if p.Online {
+			b.WriteString(" 🟢")
+		}
+		b.WriteByte('\n')
+	}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "Playtime leaderboard",
+				Description: b.String(),
+				Color:       0x22C55E,
+				Footer:      &discordgo.MessageEmbedFooter{Text: "Rotaria • " + periodLabel(period)},
+				Timestamp:   now.UTC().Format(time.RFC3339),
+			}},
+		},
+	})
+}
+
+// formatPlaytime renders d as e.g. "3d 4h 12m".
+func formatPlaytime(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	mins := d / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, mins)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, mins)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"limpan/rotaria-bot/entities"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlayerEventName(t *testing.T) {
+	tests := []struct {
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{"**Steve** joined the server.", "Steve", true},
+		{"**Alex_2** left the server.", "Alex_2", true},
+		{"Steve joined the server.", "", false},
+		{"**Steve joined the server.", "", false},
+		{"**not a name** joined the server.", "", false},
+		{"**ThisNameIsWayTooLong** joined the server.", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := playerEventName(tt.body)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("playerEventName(%q) = %q, %v; want %q, %v", tt.body, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseOnlinePlayers(t *testing.T) {
+	tests := []struct {
+		resp string
+		want []string
+	}{
+		{"There are 2 of a max of 20 players online: Steve, Alex", []string{"Steve", "Alex"}},
+		{"There are 0 of a max of 20 players online: ", nil},
+		{"Unknown command", nil},
+		{"There are 2 of a max of 20 players online: Steve, §cBad", []string{"Steve"}},
+	}
+	for _, tt := range tests {
+		if got := parseOnlinePlayers(tt.resp); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOnlinePlayers(%q) = %q, want %q", tt.resp, got, tt.want)
+		}
+	}
+}
+
+func TestTrackSessionPairsEvents(t *testing.T) {
+	a := &App{Store: openTestStore(t)}
+	ctx := context.Background()
+	playtime := func(name string) (sessions int, online bool) {
+		t.Helper()
+		p, err := a.Store.GetPlaytime(ctx, name, time.Time{}, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p == nil {
+			return 0, false
+		}
+		return p.Sessions, p.Online
+	}
+
+	a.trackSession(entities.TopicJoin, "**Steve** joined the server.")
+	a.trackSession(entities.TopicJoin, "**Steve** joined the server.") // repeated join: same session
+	a.trackSession(entities.TopicJoin, "**Alex** joined the server.")
+	a.trackSession(entities.TopicLeave, "**Steve** left the server.")
+	if n, online := playtime("Steve"); n != 1 || online {
+		t.Fatalf("Steve after leaving: %d sessions, online %v; want 1 closed", n, online)
+	}
+	if n, online := playtime("Alex"); n != 1 || !online {
+		t.Fatalf("Alex: %d sessions, online %v; want 1 open", n, online)
+	}
+
+	a.trackSession(entities.TopicLifecycle, "Server is shutting down")
+	if n, online := playtime("Alex"); n != 1 || online {
+		t.Fatalf("Alex after shutdown: %d sessions, online %v; want 1 closed", n, online)
+	}
+
+	a.trackSession(entities.TopicLeave, "**Alex** left the server.") // after shutdown: nothing open
+	a.trackSession(entities.TopicJoin, "**Steve** joined the server.")
+	if n, online := playtime("Steve"); n != 2 || !online {
+		t.Fatalf("Steve rejoining: %d sessions, online %v; want 2, online", n, online)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Every status event from the mod is stored as a sample. Samples are kept
+// for Config.StatusRetentionDays and rolled up into hourly buckets (kept for
+// statusHourlyRetention) and daily ones (kept for good), which /stats reads.
+const (
+	statusRollupInterval  = 10 * time.Minute
+	statusHourlyRetention = 90 * 24 * time.Hour
+	// Rollups redo this much history each run, so an hour that was rolled
+	// up while it was still filling gets finished.
+	statusRollupOverlap = 2 * time.Hour
+	// TPS below this counts as lag in /stats tps.
+	statusLagTPS = 18
+)
+
+// The mod sends "[UPDATE] TPS: 19.87 | Online: 3", leaving out the online
+// part when nobody is on. It formats with the JVM's locale, so the decimal
+// separator may be a comma.
+var statusRe = regexp.MustCompile(`TPS:\s*([0-9]+(?:[.,][0-9]+)?)(?:\s*\|\s*Online:\s*([0-9]+))?`)
+
+func parseStatus(body string) (entities.StatusSample, bool) {
+	m := statusRe.FindStringSubmatch(body)
+	if m == nil {
+		return entities.StatusSample{}, false
+	}
+	tps, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+	if err != nil {
+		return entities.StatusSample{}, false
+	}
+	sample := entities.StatusSample{At: time.Now(), TPS: tps}
+	if m[2] != "" {
+		sample.Players, _ = strconv.Atoi(m[2])
+	}
+	return sample, true
+}
+
+// recordStatus stores a status event; errors are logged and otherwise
+// ignored.
+func (a *App) recordStatus(body string) {
+	sample, ok := parseStatus(body)
+	if !ok {
+		log.Printf("Unrecognised status event: %q", body)
+		return
+	}
+	if err := a.Store.RecordStatus(context.Background(), sample); err != nil {
+		log.Printf("Error recording server status: %v", err)
+	}
+}
+
+func (a *App) startStatusHistory(ctx context.Context) {
+	go func() {
+		t := time.NewTimer(time.Minute)
+		defer t.Stop()
+		// The first run catches up on everything still in the raw table; the
+		// oldest hour there has been partly pruned, and RollupStatus keeps
+		// its earlier, complete rollup.
+		since := time.Now().AddDate(0, 0, -a.Config.StatusRetentionDays)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			now := time.Now()
+			if err := a.Store.RollupStatus(ctx, since); err != nil {
+				log.Printf("Error rolling up server status: %v", err)
+			} else {
+				since = now.Add(-statusRollupOverlap)
+			}
+			n, err := a.Store.PruneStatus(ctx, now.AddDate(0, 0, -a.Config.StatusRetentionDays), now.Add(-statusHourlyRetention))
+			if err != nil {
+				log.Printf("Error pruning server status history: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d old server status rows", n)
+			}
+			t.Reset(statusRollupInterval)
+		}
+	}()
+}
+
+var statsRanges = []struct {
+	name       string
+	d          time.Duration
+	resolution time.Duration
+}{
+	{"24h", 24 * time.Hour, entities.StatusHourly},
+	{"7d", 7 * 24 * time.Hour, entities.StatusDaily},
+	{"30d", 30 * 24 * time.Hour, entities.StatusDaily},
+}
+
+func statsCommand() *discordgo.ApplicationCommand {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, r := range statsRanges {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: r.name, Value: r.name})
+	}
+	rangeOption := []*discordgo.ApplicationCommandOption{{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "range",
+		Description: "How far back to look (default 24h)",
+		Choices:     choices,
+	}}
+	return &discordgo.ApplicationCommand{
+		Name:        "stats",
+		Description: "Server performance history",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "tps",
+				Description: "Ticks per second over time",
+				Options:     rangeOption,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "players",
+				Description: "Players online over time",
+				Options:     rangeOption,
+			},
+		},
+	}
+}
+
+func (a *App) onStatsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown stats command.")
+		return
+	}
+	sub := data.Options[0]
+	rng := statsRanges[0]
+	for _, o := range sub.Options {
+		if o.Name != "range" {
+			continue
+		}
+		for _, r := range statsRanges {
+			if r.name == o.StringValue() {
+				rng = r
+			}
+		}
+	}
+
+	now := time.Now()
+	rollups, err := a.Store.StatusRollups(context.Background(), rng.resolution, now.Add(-rng.d))
+	if err != nil {
+		log.Printf("Error loading server status history: %v", err)
+		respondEphemeral(s, i, "❌ Could not load server stats.")
+		return
+	}
+	if len(rollups) == 0 {
+		respondEphemeral(s, i, fmt.Sprintf("🔎 No server stats for the last %s yet.", rng.name))
+		return
+	}
+
+	var embed *discordgo.MessageEmbed
+	switch sub.Name {
+	case "tps":
+		embed = tpsStatsEmbed(rollups)
+	case "players":
+		embed = playerStatsEmbed(rollups)
+	default:
+		respondEphemeral(s, i, "❌ Unknown stats command.")
+		return
+	}
+	unit := "hour"
+	if rng.resolution == entities.StatusDaily {
+		unit = "day (UTC)"
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Rotaria • last %s • one bar per %s", rng.name, unit)}
+	embed.Timestamp = now.UTC().Format(time.RFC3339)
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Embeds: []*discordgo.MessageEmbed{embed}},
+	})
+}
+
+func tpsStatsEmbed(rollups []entities.StatusRollup) *discordgo.MessageEmbed {
+	var (
+		lowest, highest = math.Inf(1), math.Inf(-1)
+		sum             float64
+		samples, lagN   int
+		worst           entities.StatusRollup
+		avgs            = make([]float64, len(rollups))
+	)
+	for n, r := range rollups {
+		avgs[n] = r.TPSAvg
+		sum += r.TPSAvg * float64(r.Samples)
+		samples += r.Samples
+		if r.TPSMin < lowest {
+			lowest, worst = r.TPSMin, r
+		}
+		highest = math.Max(highest, r.TPSMax)
+		if r.TPSAvg < statusLagTPS {
+			lagN++
+		}
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Server TPS",
+		Description: "`" + sparkline(avgs, 0, 20) + "`",
+		Color:       statsColor(sum / float64(samples)),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Average", Value: fmt.Sprintf("%.2f", sum/float64(samples)), Inline: true},
+			{Name: "Lowest", Value: fmt.Sprintf("%.2f (<t:%d:f>)", lowest, worst.Bucket.Unix()), Inline: true},
+			{Name: "Highest", Value: fmt.Sprintf("%.2f", highest), Inline: true},
+			{Name: fmt.Sprintf("Periods averaging under %d TPS", statusLagTPS), Value: fmt.Sprintf("%d of %d", lagN, len(rollups)), Inline: true},
+		},
+	}
+}
+
+func playerStatsEmbed(rollups []entities.StatusRollup) *discordgo.MessageEmbed {
+	var (
+		sum     float64
+		samples int
+		peak    entities.StatusRollup
+		peaks   = make([]float64, len(rollups))
+	)
+	for n, r := range rollups {
+		peaks[n] = float64(r.PlayersMax)
+		sum += r.PlayersAvg * float64(r.Samples)
+		samples += r.Samples
+		if r.PlayersMax > peak.PlayersMax || n == 0 {
+			peak = r
+		}
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Players Online",
+		Description: "`" + sparkline(peaks, 0, math.Max(1, float64(peak.PlayersMax))) + "`",
+		Color:       0x3B82F6,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Peak", Value: fmt.Sprintf("%d (<t:%d:f>)", peak.PlayersMax, peak.Bucket.Unix()), Inline: true},
+			{Name: "Average", Value: fmt.Sprintf("%.1f", sum/float64(samples)), Inline: true},
+		},
+	}
+}
+
+// statsColor is green for a healthy average TPS, amber when it lags and red
+// when it struggles.
+func statsColor(tps float64) int {
+	switch {
+	case tps >= statusLagTPS:
+		return 0x22C55E
+	case tps >= 15:
+		return 0xF59E0B
+	}
+	return 0xEF4444
+}
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values scaled between lo and hi as one bar each.
+func sparkline(values []float64, lo, hi float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		n := 0
+		if hi > lo {
+			n = int(math.Round((v - lo) / (hi - lo) * float64(len(sparkBars)-1)))
+		}
+		n = max(0, min(n, len(sparkBars)-1))
+		b.WriteRune(sparkBars[n])
+	}
+	return b.String()
+}
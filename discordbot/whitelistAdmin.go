@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"limpan/rotaria-bot/entities"
+	"limpan/rotaria-bot/internals/db"
+	"limpan/rotaria-bot/namemc"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Players were whitelisted by hand before the bot existed, and ops still
+// use /whitelist add in-game, so the DB and the server's whitelist.json
+// drift apart. /whitelist-admin moves the DB in and out as JSON/CSV and
+// reconciles it against the server. Rows that migrations had to take out
+// of the whitelist wait under /whitelist-admin conflicts.
+
+const (
+	whitelistImportMaxBytes = 1 << 20
+	// Server-only names are looked up on Mojang to spot renames; cap that so
+	// a badly drifted server doesn't keep the reconcile busy for minutes.
+	reconcileLookupLimit = 50
+	reconcileListLimit   = 15
+	conflictListLimit    = 20
+)
+
+var discordIDRe = regexp.MustCompile(`^[0-9]{15,21}$`)
+
+var conflictMinID = float64(1)
+
+func whitelistAdminCommand() *discordgo.ApplicationCommand {
+	adminPerm := int64(discordgo.PermissionAdministrator)
+	return &discordgo.ApplicationCommand{
+		Name:                     "whitelist-admin",
+		Description:              "Export, import, reconcile and clean up the whitelist",
+		DefaultMemberPermissions: &adminPerm,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "export",
+				Description: "Download the whitelist database",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "format",
+						Description: "File format (default json)",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "JSON", Value: "json"},
+							{Name: "CSV", Value: "csv"},
+						},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "import",
+				Description: "Add entries from a JSON or CSV export",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionAttachment,
+						Name:        "file",
+						Description: "Columns: discord_id, minecraft_username, minecraft_uuid (optional)",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reconcile",
+				Description: "Compare the database with the server's whitelist",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "conflicts",
+				Description: "List whitelist rows that a database upgrade set aside",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "dismiss",
+						Description: "Forget the set-aside row with this ID once it's sorted out",
+						MinValue:    &conflictMinID,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (a *App) onWhitelistAdminCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this command.")
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		respondEphemeral(s, i, "❌ Unknown whitelist-admin command.")
+		return
+	}
+	sub := data.Options[0]
+	switch sub.Name {
+	case "export":
+		format := "json"
+		for _, o := range sub.Options {
+			if o.Name == "format" {
+				format = o.StringValue()
+			}
+		}
+		a.exportWhitelist(s, i, format)
+
+	case "import":
+		var att *discordgo.MessageAttachment
+		for _, o := range sub.Options {
+			if o.Name == "file" && data.Resolved != nil {
+				att = data.Resolved.Attachments[o.Value.(string)]
+			}
+		}
+		if att == nil {
+			respondEphemeral(s, i, "❌ Attach a JSON or CSV file.")
+			return
+		}
+		// Resolving UUIDs takes a Mojang round trip per row.
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+		})
+		content := a.importWhitelist(i.Member.User.ID, att)
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+
+	case "reconcile":
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+		})
+		a.editReconcileResponse(s, i, "")
+
+	case "conflicts":
+		for _, o := range sub.Options {
+			if o.Name == "dismiss" {
+				a.dismissWhitelistConflict(s, i, int(o.IntValue()))
+				return
+			}
+		}
+		conflicts, err := a.Store.ListWhitelistConflicts(context.Background())
+		if err != nil {
+			log.Printf("Error listing whitelist conflicts: %v", err)
+			respondEphemeral(s, i, "❌ Could not list the set-aside rows.")
+			return
+		}
+		respondEphemeralEmbed(s, i, conflictsEmbed(conflicts))
+
+	default:
+		respondEphemeral(s, i, "❌ Unknown whitelist-admin command.")
+	}
+}
+
+func (a *App) exportWhitelist(s *discordgo.Session, i *discordgo.InteractionCreate, format string) {
+	entries, err := a.Store.ListWhitelistEntries(context.Background())
+	if err != nil {
+		log.Printf("Error listing whitelist for export: %v", err)
+		respondEphemeral(s, i, "❌ Could not read the whitelist.")
+		return
+	}
+	file, err := encodeWhitelist(entries, format)
+	if err != nil {
+		log.Printf("Error encoding whitelist export: %v", err)
+		respondEphemeral(s, i, "❌ Could not export the whitelist.")
+		return
+	}
+	name := fmt.Sprintf("whitelist-%s.%s", time.Now().UTC().Format("20060102"), format)
+	a.audit(entities.AuditEntry{
+		ActorID: i.Member.User.ID,
+		Action:  entities.AuditWhitelistExport,
+		Target:  name,
+		Reason:  fmt.Sprintf("%d entries", len(entries)),
+	})
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("📤 Exported %d whitelist entries.", len(entries)),
+			Files:   []*discordgo.File{{Name: name, ContentType: "text/" + format, Reader: bytes.NewReader(file)}},
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// importWhitelist adds every valid row that isn't already in the DB and
+// returns a summary for the staff member who ran it. Rows go through
+// addWhitelist, so the server gets a whitelist add for each of them too.
+func (a *App) importWhitelist(actorID string, att *discordgo.MessageAttachment) string {
+	if att.Size > whitelistImportMaxBytes {
+		return fmt.Sprintf("❌ `%s` is too large (max %s).", att.Filename, formatBytes(whitelistImportMaxBytes))
+	}
+	data, err := downloadAttachment(att.URL)
+	if err != nil {
+		log.Printf("Error downloading whitelist import %s: %v", att.Filename, err)
+		return "❌ Could not download the file."
+	}
+	recs, err := decodeWhitelist(data)
+	if err != nil {
+		return fmt.Sprintf("❌ Could not read `%s`: %v", att.Filename, err)
+	}
+
+	var added, existing int
+	var problems []string
+	for n, rec := range recs {
+		entry := n + 1
+		switch {
+		case !discordIDRe.MatchString(rec.DiscordID):
+			problems = append(problems, fmt.Sprintf("entry %d: invalid discord_id %q", entry, rec.DiscordID))
+			continue
+		case !minecraftNameRe.MatchString(rec.MinecraftUsername):
+			problems = append(problems, fmt.Sprintf("entry %d: invalid minecraft_username %q", entry, rec.MinecraftUsername))
+			continue
+		}
+		err := a.addWhitelist(rec.DiscordID, rec.MinecraftUsername, rec.MinecraftUUID)
+		var conflict *db.ConflictError
+		switch {
+		case err == nil:
+			added++
+		case errors.As(err, &conflict) && conflict.Existing.DiscordID == rec.DiscordID &&
+			strings.EqualFold(conflict.Existing.MinecraftUsername, rec.MinecraftUsername):
+			existing++
+		default:
+			problems = append(problems, fmt.Sprintf("entry %d: %s: %v", entry, rec.MinecraftUsername, err))
+		}
+	}
+
+	a.audit(entities.AuditEntry{
+		ActorID: actorID,
+		Action:  entities.AuditWhitelistImport,
+		Target:  att.Filename,
+		Reason:  fmt.Sprintf("%d added, %d already present, %d skipped", added, existing, len(problems)),
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📥 Imported `%s`: %d added, %d already present, %d skipped.", att.Filename, added, existing, len(problems))
+	for n, p := range problems {
+		if n == reconcileListLimit {
+			fmt.Fprintf(&b, "\n…and %d more", len(problems)-n)
+			break
+		}
+		b.WriteString("\n• " + p)
+	}
+	return truncate(b.String(), 2000)
+}
+
+func downloadAttachment(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, whitelistImportMaxBytes+1))
+}
+
+// whitelistDiff is the difference between the DB and the server's list.
+type whitelistDiff struct {
+	OnlyDB     []entities.WhiteListEntry // whitelisted here, missing on the server
+	OnlyServer []entities.WhiteListEntry // on the server, no DB row; only the name and, if looked up, the UUID
+	Renamed    []renamedEntry            // same UUID, different name
+}
+
+type renamedEntry struct {
+	Entry      entities.WhiteListEntry
+	ServerName string
+}
+
+func (d *whitelistDiff) empty() bool {
+	return len(d.OnlyDB) == 0 && len(d.OnlyServer) == 0 && len(d.Renamed) == 0
+}
+
+// fetchServerWhitelist asks the mod for `whitelist list`, which answers
+// "There are N whitelisted player(s): a, b" or "There are no whitelisted
+// players".
+func (a *App) fetchServerWhitelist(ctx context.Context) ([]string, error) {
+	if a.MinecraftConn == nil {
+		return nil, errBridgeNotConnected
+	}
+	resp, err := a.MinecraftConn.Send(ctx, []byte("commandexec whitelist list\n"))
+	if err != nil {
+		return nil, err
+	}
+	return parseWhitelistList(string(resp))
+}
+
+func parseWhitelistList(resp string) ([]string, error) {
+	resp = strings.TrimSpace(resp)
+	if strings.Contains(resp, "no whitelisted players") {
+		return nil, nil
+	}
+	idx := strings.LastIndex(resp, ":")
+	if idx == -1 || !strings.HasPrefix(resp, "There are") {
+		return nil, fmt.Errorf("unexpected whitelist list reply %q", resp)
+	}
+	var names []string
+	for _, n := range strings.Split(resp[idx+1:], ",") {
+		if n = strings.TrimSpace(n); minecraftNameRe.MatchString(n) {
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
+
+// reconcileWhitelist matches server names to DB rows by name, then tries
+// the UUIDs of what's left over so a rename shows up as one mismatch
+// instead of an add plus a remove. lookup resolves a name to a UUID.
+func reconcileWhitelist(entries []entities.WhiteListEntry, serverNames []string, lookup func(string) (string, error)) whitelistDiff {
+	byName := make(map[string]int, len(entries))
+	byUUID := make(map[string]int, len(entries))
+	for n, e := range entries {
+		byName[strings.ToLower(e.MinecraftUsername)] = n
+		if e.MinecraftUUID != "" {
+			byUUID[e.MinecraftUUID] = n
+		}
+	}
+	matched := make([]bool, len(entries))
+	var unmatched []string
+	for _, name := range serverNames {
+		if n, ok := byName[strings.ToLower(name)]; ok && !matched[n] {
+			matched[n] = true
+			continue
+		}
+		unmatched = append(unmatched, name)
+	}
+
+	var d whitelistDiff
+	for k, name := range unmatched {
+		extra := entities.WhiteListEntry{MinecraftUsername: name}
+		if k < reconcileLookupLimit && lookup != nil {
+			if uuid, err := lookup(name); err == nil {
+				extra.MinecraftUUID = namemc.NormalizeUUID(uuid)
+				if n, ok := byUUID[extra.MinecraftUUID]; ok && !matched[n] {
+					matched[n] = true
+					d.Renamed = append(d.Renamed, renamedEntry{Entry: entries[n], ServerName: name})
+					continue
+				}
+			}
+		}
+		d.OnlyServer = append(d.OnlyServer, extra)
+	}
+	for n, e := range entries {
+		if !matched[n] {
+			d.OnlyDB = append(d.OnlyDB, e)
+		}
+	}
+	return d
+}
+
+func (a *App) computeWhitelistDiff(ctx context.Context) (whitelistDiff, error) {
+	serverNames, err := a.fetchServerWhitelist(ctx)
+	if err != nil {
+		return whitelistDiff{}, fmt.Errorf("fetch server whitelist: %w", err)
+	}
+	entries, err := a.Store.ListWhitelistEntries(ctx)
+	if err != nil {
+		return whitelistDiff{}, fmt.Errorf("list whitelist: %w", err)
+	}
+	client := namemc.New()
+	lookup := func(name string) (string, error) {
+		time.Sleep(nameLookupGap)
+		return client.UsernameToUUID(name)
+	}
+	return reconcileWhitelist(entries, serverNames, lookup), nil
+}
+
+// editReconcileResponse recomputes the diff and puts it in the deferred
+// response, with notice (the outcome of a fix, if any) on top.
+func (a *App) editReconcileResponse(s *discordgo.Session, i *discordgo.InteractionCreate, notice string) {
+	d, err := a.computeWhitelistDiff(context.Background())
+	if err != nil {
+		log.Printf("Error reconciling whitelist: %v", err)
+		content := fmt.Sprintf("❌ Could not reconcile the whitelist: %v", err)
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+	embed, components := reconcileEmbed(&d)
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &notice,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &components,
+	})
+}
+
+func reconcileEmbed(d *whitelistDiff) (*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	if d.empty() {
+		return &discordgo.MessageEmbed{
+			Title:       "Whitelist reconcile",
+			Description: "✅ The database and the server whitelist match.",
+			Color:       0x22C55E,
+		}, []discordgo.MessageComponent{}
+	}
+	list := func(items []string) string {
+		if len(items) == 0 {
+			return "—"
+		}
+		var b strings.Builder
+		for n, it := range items {
+			if n == reconcileListLimit {
+				fmt.Fprintf(&b, "…and %d more", len(items)-n)
+				break
+			}
+			b.WriteString(it + "\n")
+		}
+		return truncate(b.String(), 1024)
+	}
+	var onlyDB, onlyServer, renamed []string
+	for _, e := range d.OnlyDB {
+		onlyDB = append(onlyDB, fmt.Sprintf("`%s` (<@%s>)", e.MinecraftUsername, e.DiscordID))
+	}
+	for _, e := range d.OnlyServer {
+		onlyServer = append(onlyServer, fmt.Sprintf("`%s`", e.MinecraftUsername))
+	}
+	for _, r := range d.Renamed {
+		renamed = append(renamed, fmt.Sprintf("`%s` here, `%s` on the server (<@%s>)", r.Entry.MinecraftUsername, r.ServerName, r.Entry.DiscordID))
+	}
+	embed := &discordgo.MessageEmbed{
+		Title: "Whitelist reconcile",
+		Color: 0xF59E0B,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: fmt.Sprintf("Only in the database (%d)", len(d.OnlyDB)), Value: list(onlyDB)},
+			{Name: fmt.Sprintf("Only on the server (%d)", len(d.OnlyServer)), Value: list(onlyServer)},
+			{Name: fmt.Sprintf("Name mismatch (%d)", len(d.Renamed)), Value: list(renamed)},
+		},
+		Footer: &discordgo.MessageEmbedFooter{Text: "Fixes re-check both lists before they run"},
+	}
+	row := discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    fmt.Sprintf("Whitelist %d on server", len(d.OnlyDB)),
+			Style:    discordgo.PrimaryButton,
+			CustomID: "wlsync_add",
+			Disabled: len(d.OnlyDB) == 0,
+		},
+		discordgo.Button{
+			Label:    fmt.Sprintf("Unwhitelist %d from server", len(d.OnlyServer)),
+			Style:    discordgo.DangerButton,
+			CustomID: "wlsync_remove",
+			Disabled: len(d.OnlyServer) == 0,
+		},
+		discordgo.Button{
+			Label:    fmt.Sprintf("Refresh %d names", len(d.Renamed)),
+			Style:    discordgo.SecondaryButton,
+			CustomID: "wlsync_names",
+			Disabled: len(d.Renamed) == 0,
+		},
+	}}
+	return embed, []discordgo.MessageComponent{row}
+}
+
+func (a *App) dismissWhitelistConflict(s *discordgo.Session, i *discordgo.InteractionCreate, id int) {
+	err := a.Store.DismissWhitelistConflict(context.Background(), id)
+	if errors.Is(err, db.ErrConflictNotFound) {
+		respondEphemeral(s, i, fmt.Sprintf("❌ There is no set-aside row #%d.", id))
+		return
+	}
+	a.audit(entities.AuditEntry{
+		ActorID: i.Member.User.ID,
+		Action:  entities.AuditWhitelistDismiss,
+		Target:  fmt.Sprintf("#%d", id),
+		Result:  auditResult(err),
+	})
+	if err != nil {
+		log.Printf("Error dismissing whitelist conflict %d: %v", id, err)
+		respondEphemeral(s, i, "❌ Could not dismiss the row.")
+		return
+	}
+	respondEphemeral(s, i, fmt.Sprintf("🗑️ Set-aside row #%d dismissed.", id))
+}
+
+func conflictsEmbed(conflicts []entities.WhitelistConflict) *discordgo.MessageEmbed {
+	if len(conflicts) == 0 {
+		return &discordgo.MessageEmbed{
+			Title:       "Set-aside whitelist rows",
+			Description: "✅ Nothing is waiting.",
+			Color:       0x22C55E,
+		}
+	}
+	var b strings.Builder
+	for n, c := range conflicts {
+		if n == conflictListLimit {
+			fmt.Fprintf(&b, "…and %d more", len(conflicts)-n)
+			break
+		}
+		fmt.Fprintf(&b, "`#%d` <@%s> • `%s` • %s\n", c.ID, c.DiscordID, c.MinecraftUsername, c.Reason)
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Set-aside whitelist rows",
+		Description: truncate(b.String(), 4096),
+		Color:       0xF59E0B,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "A database upgrade took these out of the whitelist. Re-add the player if they should keep access, then /whitelist-admin conflicts dismiss:<id>",
+		},
+	}
+}
+
+// reportWhitelistConflicts tells staff at startup about rows a migration set
+// aside, until they have all been dismissed.
+func (a *App) reportWhitelistConflicts(ctx context.Context) {
+	conflicts, err := a.Store.ListWhitelistConflicts(ctx)
+	if err != nil {
+		log.Printf("Error listing whitelist conflicts: %v", err)
+		return
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+	log.Printf("%d whitelist rows are set aside; see /whitelist-admin conflicts", len(conflicts))
+	if a.Config.WhitelistRequestsChannelID == "" || a.DiscordSession == nil {
+		return
+	}
+	if _, err := a.DiscordSession.ChannelMessageSendEmbed(a.Config.WhitelistRequestsChannelID, conflictsEmbed(conflicts)); err != nil {
+		log.Printf("Error reporting whitelist conflicts: %v", err)
+	}
+}
+
+// onWhitelistSync applies one category of fixes from the reconcile message.
+// The diff is computed again first, so a fix never acts on a stale list.
+func (a *App) onWhitelistSync(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+	action := i.MessageComponentData().CustomID
+	if !strings.HasPrefix(action, "wlsync_") {
+		return
+	}
+	if !isAdmin(i) {
+		respondEphemeral(s, i, "❌ You need administrator permissions for this.")
+		return
+	}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	ctx := context.Background()
+	d, err := a.computeWhitelistDiff(ctx)
+	if err != nil {
+		log.Printf("Error reconciling whitelist: %v", err)
+		content := fmt.Sprintf("❌ Could not reconcile the whitelist: %v", err)
+		_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return
+	}
+
+	actorID := i.Member.User.ID
+	var done, failed int
+	record := func(target, discordID, reason string, err error) {
+		a.audit(entities.AuditEntry{
+			ActorID:    actorID,
+			Action:     entities.AuditWhitelistSync,
+			TargetUser: discordID,
+			Target:     target,
+			Reason:     reason,
+			Result:     auditResult(err),
+		})
+		if err != nil {
+			failed++
+		} else {
+			done++
+		}
+	}
+	var what string
+	switch action {
+	case "wlsync_add":
+		what = "queued for the server whitelist"
+		for _, e := range d.OnlyDB {
+			a.refreshMinecraftName(&e)
+			err := a.queueBridgeCommand(fmt.Sprintf("whitelist add %s\n", e.MinecraftUsername), bridgePriorityNormal, playerHeaders(e))
+			record(e.MinecraftUsername, e.DiscordID, "reconcile: missing on server", err)
+		}
+	case "wlsync_remove":
+		what = "queued for removal from the server"
+		// The diff only looked up the first reconcileLookupLimit names;
+		// resolve the rest so the queue can key removals by UUID too.
+		client := namemc.New()
+		for _, e := range d.OnlyServer {
+			if e.MinecraftUUID == "" {
+				time.Sleep(nameLookupGap)
+				if uuid, err := client.UsernameToUUID(e.MinecraftUsername); err == nil {
+					e.MinecraftUUID = namemc.NormalizeUUID(uuid)
+				} else {
+					log.Printf("Could not resolve %s before unwhitelisting: %v", e.MinecraftUsername, err)
+				}
+			}
+			err := a.queueBridgeCommand(fmt.Sprintf("unwhitelist %s\n", e.MinecraftUsername), bridgePriorityNormal, playerHeaders(e))
+			record(e.MinecraftUsername, "", "reconcile: not in database", err)
+		}
+	case "wlsync_names":
+		what = "names refreshed from Mojang"
+		for _, r := range d.Renamed {
+			before := r.Entry.MinecraftUsername
+			a.refreshMinecraftName(&r.Entry)
+			reason := "reconcile: name mismatch"
+			if r.Entry.MinecraftUsername == before {
+				// Our name is current; the server's copy catches up the
+				// next time the player joins.
+				reason += ", server name is stale"
+			}
+			record(fmt.Sprintf("%s → %s", before, r.Entry.MinecraftUsername), r.Entry.DiscordID, reason, nil)
+		}
+	default:
+		return
+	}
+
+	notice := fmt.Sprintf("🔧 %d %s.", done, what)
+	if failed > 0 {
+		notice += fmt.Sprintf(" %d failed; see the audit log.", failed)
+	}
+	if action != "wlsync_names" && done > 0 {
+		notice += " The server list updates once the bridge queue has sent them."
+	}
+	a.editReconcileResponse(s, i, notice)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"limpan/rotaria-bot/entities"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReconcileWhitelist(t *testing.T) {
+	steve := entities.WhiteListEntry{DiscordID: "1", MinecraftUsername: "Steve", MinecraftUUID: "aaaa"}
+	alex := entities.WhiteListEntry{DiscordID: "2", MinecraftUsername: "Alex", MinecraftUUID: "bbbb"}
+	noUUID := entities.WhiteListEntry{DiscordID: "3", MinecraftUsername: "Herobrine"}
+	uuids := map[string]string{"Alexandra": "BB-BB", "Notch": "cccc", "Herobrine2": "dddd"}
+	lookup := func(name string) (string, error) {
+		if u, ok := uuids[name]; ok {
+			return u, nil
+		}
+		return "", errors.New("not found")
+	}
+	name := func(e entities.WhiteListEntry) string { return e.MinecraftUsername }
+
+	tests := []struct {
+		name       string
+		entries    []entities.WhiteListEntry
+		server     []string
+		lookup     func(string) (string, error)
+		onlyDB     []string
+		onlyServer []string // name=uuid
+		renamed    []string // db name->server name
+	}{
+		{
+			name:    "in sync, names ignore case",
+			entries: []entities.WhiteListEntry{steve, alex},
+			server:  []string{"alex", "STEVE"},
+			lookup:  lookup,
+		},
+		{
+			name:    "missing on the server",
+			entries: []entities.WhiteListEntry{steve, alex, noUUID},
+			server:  []string{"Steve"},
+			lookup:  lookup,
+			onlyDB:  []string{"Alex", "Herobrine"},
+		},
+		{
+			name:       "only on the server",
+			entries:    []entities.WhiteListEntry{steve},
+			server:     []string{"Steve", "Notch", "Ghost"},
+			lookup:     lookup,
+			onlyServer: []string{"Notch=cccc", "Ghost="},
+		},
+		{
+			name:    "renamed, matched by normalised UUID",
+			entries: []entities.WhiteListEntry{steve, alex},
+			server:  []string{"Steve", "Alexandra"},
+			lookup:  lookup,
+			renamed: []string{"Alex->Alexandra"},
+		},
+		{
+			name:       "no UUID on record can't be a rename",
+			entries:    []entities.WhiteListEntry{noUUID},
+			server:     []string{"Herobrine2"},
+			lookup:     lookup,
+			onlyDB:     []string{"Herobrine"},
+			onlyServer: []string{"Herobrine2=dddd"},
+		},
+		{
+			name:       "listed twice on the server",
+			entries:    []entities.WhiteListEntry{steve},
+			server:     []string{"Steve", "steve"},
+			onlyServer: []string{"steve="},
+		},
+		{
+			name:       "without lookups renames look like add plus remove",
+			entries:    []entities.WhiteListEntry{alex},
+			server:     []string{"Alexandra"},
+			onlyDB:     []string{"Alex"},
+			onlyServer: []string{"Alexandra="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := reconcileWhitelist(tt.entries, tt.server, tt.lookup)
+			var onlyDB, onlyServer, renamed []string
+			for _, e := range d.OnlyDB {
+				onlyDB = append(onlyDB, name(e))
+			}
+			for _, e := range d.OnlyServer {
+				onlyServer = append(onlyServer, e.MinecraftUsername+"="+e.MinecraftUUID)
+			}
+			for _, r := range d.Renamed {
+				renamed = append(renamed, name(r.Entry)+"->"+r.ServerName)
+			}
+			if !reflect.DeepEqual(onlyDB, tt.onlyDB) {
+				t.Errorf("OnlyDB = %v, want %v", onlyDB, tt.onlyDB)
+			}
+			if !reflect.DeepEqual(onlyServer, tt.onlyServer) {
+				t.Errorf("OnlyServer = %v, want %v", onlyServer, tt.onlyServer)
+			}
+			if !reflect.DeepEqual(renamed, tt.renamed) {
+				t.Errorf("Renamed = %v, want %v", renamed, tt.renamed)
+			}
+			if d.empty() != (tt.onlyDB == nil && tt.onlyServer == nil && tt.renamed == nil) {
+				t.Errorf("empty() = %v", d.empty())
+			}
+		})
+	}
+}
+
+func TestReconcileWhitelistLookupLimit(t *testing.T) {
+	var server []string
+	for n := range reconcileLookupLimit + 5 {
+		server = append(server, fmt.Sprintf("Player%d", n))
+	}
+	lookups := 0
+	d := reconcileWhitelist(nil, server, func(string) (string, error) {
+		lookups++
+		return "", errors.New("not found")
+	})
+	if lookups != reconcileLookupLimit || len(d.OnlyServer) != len(server) {
+		t.Fatalf("%d lookups, %d only on the server; want %d, %d", lookups, len(d.OnlyServer), reconcileLookupLimit, len(server))
+	}
+}
+
+func TestParseWhitelistList(t *testing.T) {
+	tests := []struct {
+		resp    string
+		want    []string
+		wantErr bool
+	}{
+		{resp: "There are no whitelisted players"},
+		{resp: "There are 2 whitelisted player(s): Steve, Alex\n", want: []string{"Steve", "Alex"}},
+		{resp: "There are 1 whitelisted player(s): Steve", want: []string{"Steve"}},
+		{resp: "There are 2 whitelisted player(s): Steve, not a name!", want: []string{"Steve"}},
+		{resp: "Unknown command", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseWhitelistList(tt.resp)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseWhitelistList(%q) = %v, %v; want %v", strings.TrimSpace(tt.resp), got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"limpan/rotaria-bot/entities"
+	"strings"
+)
+
+// Whitelist export/import files. JSON is an array of objects; CSV has a
+// header row with the same field names. minecraft_uuid may be empty.
+
+var whitelistCSVHeader = []string{"discord_id", "minecraft_username", "minecraft_uuid"}
+
+type whitelistRecord struct {
+	DiscordID         string `json:"discord_id"`
+	MinecraftUsername string `json:"minecraft_username"`
+	MinecraftUUID     string `json:"minecraft_uuid,omitempty"`
+}
+
+func encodeWhitelist(entries []entities.WhiteListEntry, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		recs := make([]whitelistRecord, len(entries))
+		for i, e := range entries {
+			recs[i] = whitelistRecord{e.DiscordID, e.MinecraftUsername, e.MinecraftUUID}
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(recs); err != nil {
+			return nil, err
+		}
+	case "csv":
+		w := csv.NewWriter(&buf)
+		_ = w.Write(whitelistCSVHeader)
+		for _, e := range entries {
+			_ = w.Write([]string{e.DiscordID, e.MinecraftUsername, e.MinecraftUUID})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWhitelist reads a JSON or CSV export, guessing the format from the
+// first non-space byte.
+func decodeWhitelist(data []byte) ([]whitelistRecord, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	var recs []whitelistRecord
+	if trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &recs); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(trimmed))
+		r.FieldsPerRecord = -1
+		header, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		col := make(map[string]int)
+		for i, h := range header {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		if _, ok := col["discord_id"]; !ok {
+			return nil, errors.New("CSV header must include discord_id and minecraft_username")
+		}
+		if _, ok := col["minecraft_username"]; !ok {
+			return nil, errors.New("CSV header must include discord_id and minecraft_username")
+		}
+		field := func(row []string, name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %w", err)
+			}
+			recs = append(recs, whitelistRecord{
+				DiscordID:         field(row, "discord_id"),
+				MinecraftUsername: field(row, "minecraft_username"),
+				MinecraftUUID:     field(row, "minecraft_uuid"),
+			})
+		}
+	}
+	for i := range recs {
+		recs[i].DiscordID = strings.TrimSpace(recs[i].DiscordID)
+		recs[i].MinecraftUsername = strings.TrimSpace(recs[i].MinecraftUsername)
+		recs[i].MinecraftUUID = strings.TrimSpace(recs[i].MinecraftUUID)
+	}
+	return recs, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"limpan/rotaria-bot/entities"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeWhitelist(t *testing.T) {
+	steve := whitelistRecord{DiscordID: "100000000000000001", MinecraftUsername: "Steve", MinecraftUUID: "aaaa"}
+	alex := whitelistRecord{DiscordID: "100000000000000002", MinecraftUsername: "Alex"}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []whitelistRecord
+		wantErr string
+	}{
+		{
+			name: "JSON",
+			data: `  [{"discord_id": "100000000000000001", "minecraft_username": "Steve", "minecraft_uuid": "aaaa"},
+				{"discord_id": " 100000000000000002 ", "minecraft_username": "Alex "}]`,
+			want: []whitelistRecord{steve, alex},
+		},
+		{
+			name: "JSON empty list",
+			data: `[]`,
+			want: []whitelistRecord{},
+		},
+		{
+			name:    "JSON broken",
+			data:    `[{"discord_id": 1}]`,
+			wantErr: "invalid JSON",
+		},
+		{
+			name: "CSV",
+			data: "discord_id,minecraft_username,minecraft_uuid\n100000000000000001,Steve,aaaa\n100000000000000002,Alex,\n",
+			want: []whitelistRecord{steve, alex},
+		},
+		{
+			name: "CSV columns in any order and case, uuid optional",
+			data: "Minecraft_Username , DISCORD_ID\n Steve ,100000000000000001\r\nAlex,100000000000000002",
+			want: []whitelistRecord{{DiscordID: steve.DiscordID, MinecraftUsername: "Steve"}, alex},
+		},
+		{
+			name: "CSV short row",
+			data: "discord_id,minecraft_username,minecraft_uuid\n100000000000000002,Alex",
+			want: []whitelistRecord{alex},
+		},
+		{
+			name: "CSV header only",
+			data: "discord_id,minecraft_username\n",
+		},
+		{
+			name:    "CSV missing a column",
+			data:    "discord_id,minecraft_uuid\n1,aaaa\n",
+			wantErr: "CSV header must include",
+		},
+		{
+			name:    "CSV bad quoting",
+			data:    "discord_id,minecraft_username\n\"1,Steve\n",
+			wantErr: "invalid CSV",
+		},
+		{
+			name:    "empty",
+			data:    " \n\t",
+			wantErr: "file is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeWhitelist([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeWhitelistRoundTrips(t *testing.T) {
+	entries := []entities.WhiteListEntry{
+		{DiscordID: "100000000000000001", MinecraftUsername: "Steve", MinecraftUUID: "aaaa"},
+		{DiscordID: "100000000000000002", MinecraftUsername: "Alex"},
+	}
+	want := []whitelistRecord{
+		{DiscordID: "100000000000000001", MinecraftUsername: "Steve", MinecraftUUID: "aaaa"},
+		{DiscordID: "100000000000000002", MinecraftUsername: "Alex"},
+	}
+	for _, format := range []string{"json", "csv"} {
+		data, err := encodeWhitelist(entries, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		got, err := decodeWhitelist(data)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s round trip = %+v, %v", format, got, err)
+		}
+	}
+	if _, err := encodeWhitelist(entries, "xml"); err == nil {
+		t.Fatal("encodeWhitelist accepted xml")
+	}
+}
@@ -1,7 +1,5 @@
 package awiant.connector;
 
-import com.google.gson.Gson;
-import com.google.gson.JsonObject;
 import net.minecraft.network.chat.Component;
 import net.minecraft.server.MinecraftServer;
 import net.minecraftforge.server.ServerLifecycleHooks;
@@ -10,14 +8,12 @@ import java.io.*;
 import java.net.ServerSocket;
 import java.net.Socket;
 import java.nio.charset.StandardCharsets;
-import java.util.ArrayList;
 import java.util.List;
 import java.util.concurrent.*;
 
 public class DiscordBridge {
     private ServerSocket serverSocket;
     private final int port;
-    private final Gson gson = new Gson();
 
     private final java.util.concurrent.atomic.AtomicLong gen = new java.util.concurrent.atomic.AtomicLong();
     private volatile ClientSession session; // the single active connection
@@ -34,7 +30,7 @@ public class DiscordBridge {
     private void acceptLoop() {
         try {
             serverSocket = new ServerSocket(port);
-            Connector.LOGGER.info("Discord bridge (NDJSON) listening on port {}", port);
+            Connector.LOGGER.info("Discord bridge listening on port {} (codecs: {}, {})", port, FrameCodec.MSGPACK, FrameCodec.NDJSON);
             while (!serverSocket.isClosed()) {
                 Socket s = serverSocket.accept();
                 Connector.LOGGER.info("accepted {}", s);
@@ -62,45 +58,34 @@ public class DiscordBridge {
     }
 
     private void handleClient(ClientSession sess) {
-        try (InputStream rin = sess.socket.getInputStream();
-            BufferedReader hin = new BufferedReader(new InputStreamReader(rin, StandardCharsets.UTF_8))) {
-
+        try (InputStream in = new BufferedInputStream(sess.socket.getInputStream())) {
             for (;;) {
-                String line = hin.readLine();
-                if (line == null) {
-                    Connector.LOGGER.info("client EOF (id={})", sess.id);
-                    break;
-                }
-                line = line.trim();
-                if (line.isEmpty()) continue;
-
-                Connector.LOGGER.debug("recv json (id={}): {}", sess.id, line);
-                JsonObject m;
+                Frame m;
                 try {
-                    m = gson.fromJson(line, JsonObject.class);
-                } catch (com.google.gson.JsonSyntaxException ex) {
-                    Connector.LOGGER.warn("bad json syntax (id={}): {}", sess.id, line);
+                    m = sess.reader.decode(in);
+                } catch (FrameCodec.BadFrameException ex) {
+                    Connector.LOGGER.warn("bad frame (id={}): {}", sess.id, ex.getMessage());
                     continue;
                 }
-                if (m == null || !m.has("type")) {
-                    Connector.LOGGER.warn("bad json frame (id={}): {}", sess.id, line);
-                    continue;
+                if (m == null) {
+                    Connector.LOGGER.info("client EOF (id={})", sess.id);
+                    break;
                 }
+                Connector.LOGGER.debug("recv {} (id={}): {}", m.type, sess.id, m.bodyString());
 
-                String type = m.get("type").getAsString();
-                switch (type) {
+                switch (m.type) {
+                    case "HELLO":
+                        onHello(sess, m);
+                        break;
                     case "PING":
-                        writeImmediate(sess, json("type","PONG"));
+                        writeImmediate(sess, Frame.of("PONG"));
                         break;
-                    case "CMD": {
-                        String id = m.has("id") ? m.get("id").getAsString() : "";
-                        String body = m.has("body") ? m.get("body").getAsString() : "";
-                        onCommand(sess, id, body);
+                    case "CMD":
+                        onCommand(sess, m.id == null ? "" : m.id, m.bodyString());
                         break;
-                    }
 
                     default:
-                        Connector.LOGGER.warn("unknown frame type (id={}): {}", sess.id, type);
+                        Connector.LOGGER.warn("unknown frame type (id={}): {}", sess.id, m.type);
                 }
             }
         } catch (IOException e) {
@@ -111,11 +96,29 @@ public class DiscordBridge {
         }
     }
 
-    private void writeImmediate(ClientSession sess, JsonObject m) {
+    // The bot offers codecs in preference order before sending anything
+    // else. The answer goes out in NDJSON and both directions switch right
+    // after it; the bot sends nothing more until it has read the answer.
+    private void onHello(ClientSession sess, Frame hello) {
+        FrameCodec codec = FrameCodec.choose(hello.codecs);
+        try {
+            byte[] answer = FrameCodec.Ndjson.INSTANCE.encode(Frame.of("HELLO").codec(codec.name()));
+            synchronized (sess.out) {
+                sess.out.write(answer);
+                sess.out.flush();
+                sess.writer = codec;
+            }
+            sess.reader = codec;
+            Connector.LOGGER.info("negotiated codec {} (id={})", codec.name(), sess.id);
+        } catch (IOException ioe) {
+            Connector.LOGGER.warn("HELLO answer failed (id={}) : {}", sess.id, ioe.toString());
+        }
+    }
+
+    private void writeImmediate(ClientSession sess, Frame m) {
         try {
-            byte[] bytes = (gson.toJson(m) + "\n").getBytes(StandardCharsets.UTF_8);
             synchronized (sess.out) {
-                sess.out.write(bytes);
+                sess.out.write(sess.writer.encode(m));
                 sess.out.flush();
             }
         } catch (IOException ioe) {
@@ -127,7 +130,7 @@ public class DiscordBridge {
         String cmd = bodyUtf8.trim();
         MinecraftServer server = ServerLifecycleHooks.getCurrentServer();
         if (server == null) {
-            sess.enqueueControl(json("type","ERR","id",id,"msg","server not ready"));
+            sess.enqueueControl(Frame.of("ERR").id(id).msg("server not ready"));
             return;
         }
 
@@ -168,10 +171,10 @@ public class DiscordBridge {
         try {
             String res = fut.get(5, TimeUnit.SECONDS);
             // Control path so RES is never stuck behind EVTs
-            sess.enqueueControl(json("type","RES","id",id,"body",res));
+            sess.enqueueControl(Frame.of("RES").id(id).body(res));
         } catch (Exception e) {
             String msg = e.getMessage() != null ? e.getMessage() : "error";
-            sess.enqueueControl(json("type","ERR","id",id,"msg",msg));
+            sess.enqueueControl(Frame.of("ERR").id(id).msg(msg));
         }
     }
 
@@ -184,10 +187,9 @@ public class DiscordBridge {
     public void sendEvent(String topic, byte[] body) {
         ClientSession s = session;
         if (s == null) return;
-        String str = new String(body, StandardCharsets.UTF_8);
         // EVTs go to the normal queue; may be dropped when too full
-        s.enqueue(json("type", "EVT", "topic", topic, "body", str));
-        Connector.LOGGER.debug("send EVT (id={}) topic={} body={}", s.id, topic, str);
+        s.enqueue(Frame.of("EVT").topic(topic).body(body));
+        Connector.LOGGER.debug("send EVT (id={}) topic={} bytes={}", s.id, topic, body.length);
     }
 
     public boolean isConnected() {
@@ -195,27 +197,18 @@ public class DiscordBridge {
         return s != null && !s.socket.isClosed() && s.socket.isConnected();
     }
 
-    private JsonObject json(Object... kv) {
-        JsonObject o = new JsonObject();
-        for (int i = 0; i + 1 < kv.length; i += 2) {
-            String k = String.valueOf(kv[i]);
-            Object v = kv[i + 1];
-            if (v == null) { o.addProperty(k, (String) null); continue; }
-            if (v instanceof Number n) o.addProperty(k, n);
-            else if (v instanceof Boolean b) o.addProperty(k, b);
-            else o.addProperty(k, String.valueOf(v));
-        }
-        return o;
-    }
-
     private final class ClientSession {
         final long id;
         final Socket socket;
         final OutputStream out;
+        // Both start as NDJSON and change once, on HELLO. reader is only
+        // touched by the client thread; writer only while holding out.
+        FrameCodec reader = FrameCodec.Ndjson.INSTANCE;
+        FrameCodec writer = FrameCodec.Ndjson.INSTANCE;
         // Separate queues: control (PONG/RES/ERR) and regular EVT
-        final BlockingQueue<String> control = new LinkedBlockingQueue<>(1_000);
-        final BlockingQueue<String> outbox = new LinkedBlockingQueue<>(10_000);
-        final Thread writer;
+        final BlockingQueue<Frame> control = new LinkedBlockingQueue<>(1_000);
+        final BlockingQueue<Frame> outbox = new LinkedBlockingQueue<>(10_000);
+        final Thread writerThread;
 
         ClientSession(long id, Socket socket) throws IOException {
             this.id = id;
@@ -225,19 +218,25 @@ public class DiscordBridge {
             try { this.socket.setSendBufferSize(256 * 1024); } catch (Exception ignore) {}
             this.out = socket.getOutputStream();
 
-            this.writer = new Thread(() -> {
+            this.writerThread = new Thread(() -> {
                 try {
                     for (;;) {
-                        String line = control.poll(); // try control first
-                        if (line == null) {
+                        Frame f = control.poll(); // try control first
+                        if (f == null) {
                             // wait a short time on regular queue; then re-check control
-                            line = outbox.poll(200, TimeUnit.MILLISECONDS);
-                            if (line == null) {
+                            f = outbox.poll(200, TimeUnit.MILLISECONDS);
+                            if (f == null) {
                                 continue; // loop and poll control again
                             }
                         }
-                        byte[] bytes = line.getBytes(StandardCharsets.UTF_8);
                         synchronized (out) {
+                            byte[] bytes;
+                            try {
+                                bytes = writer.encode(f);
+                            } catch (FrameCodec.BadFrameException e) {
+                                Connector.LOGGER.warn("cannot encode {} (id={}): {}", f.type, id, e.getMessage());
+                                continue;
+                            }
                             out.write(bytes);
                             out.flush();
                         }
@@ -251,23 +250,21 @@ public class DiscordBridge {
                 }
             }, "discord-bridge-writer-" + id);
 
-            this.writer.setDaemon(true);
-            this.writer.start();
+            this.writerThread.setDaemon(true);
+            this.writerThread.start();
         }
 
-        void enqueue(JsonObject m) {
-            String line = gson.toJson(m) + "\n";
+        void enqueue(Frame m) {
             // If EVT queue is near full, drop newest EVT (shed load) to protect control frames
-            if (!outbox.offer(line)) {
+            if (!outbox.offer(m)) {
                 Connector.LOGGER.warn("outbox full; dropping EVT (id={})", id);
             }
         }
 
-        void enqueueControl(JsonObject m) {
-            String line = gson.toJson(m) + "\n";
+        void enqueueControl(Frame m) {
             // Control frames should almost never drop; wait briefly if needed
             try {
-                if (!control.offer(line, 200, TimeUnit.MILLISECONDS)) {
+                if (!control.offer(m, 200, TimeUnit.MILLISECONDS)) {
                     Connector.LOGGER.warn("control queue saturated; dropping control frame (id={})", id);
                 }
             } catch (InterruptedException e) {
@@ -276,7 +273,7 @@ public class DiscordBridge {
         }
 
         void stop() {
-            writer.interrupt();
+            writerThread.interrupt();
             control.clear();
             outbox.clear();
             try { socket.close(); } catch (IOException ignore) {}
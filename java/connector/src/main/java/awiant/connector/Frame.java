@@ -0,0 +1,35 @@
+package awiant.connector;
+
+import java.nio.charset.StandardCharsets;
+import java.util.List;
+
+/**
+ * Codec-neutral form of a bridge protocol message. Mirrors tcpbridge.Frame on
+ * the bot side; body stays raw bytes so msgpack can carry it as bin.
+ */
+final class Frame {
+    String type;
+    String id;
+    String topic;
+    String msg;
+    byte[] body;
+    List<String> codecs; // HELLO offer
+    String codec;        // HELLO answer
+
+    static Frame of(String type) {
+        Frame f = new Frame();
+        f.type = type;
+        return f;
+    }
+
+    Frame id(String id) { this.id = id; return this; }
+    Frame topic(String topic) { this.topic = topic; return this; }
+    Frame msg(String msg) { this.msg = msg; return this; }
+    Frame body(byte[] body) { this.body = body; return this; }
+    Frame body(String body) { this.body = body.getBytes(StandardCharsets.UTF_8); return this; }
+    Frame codec(String codec) { this.codec = codec; return this; }
+
+    String bodyString() {
+        return body == null ? "" : new String(body, StandardCharsets.UTF_8);
+    }
+}
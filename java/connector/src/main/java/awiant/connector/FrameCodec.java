@@ -0,0 +1,291 @@
+package awiant.connector;
+
+import com.google.gson.Gson;
+import com.google.gson.JsonArray;
+import com.google.gson.JsonElement;
+import com.google.gson.JsonObject;
+import com.google.gson.JsonSyntaxException;
+
+import java.io.ByteArrayOutputStream;
+import java.io.DataInputStream;
+import java.io.EOFException;
+import java.io.IOException;
+import java.io.InputStream;
+import java.nio.ByteBuffer;
+import java.nio.charset.StandardCharsets;
+import java.util.ArrayList;
+import java.util.List;
+import java.util.Locale;
+
+/**
+ * Wire codecs for the Discord bridge. Must stay in step with
+ * internals/tcpbridge/codec.go in the bot.
+ */
+interface FrameCodec {
+    String NDJSON = "ndjson";
+    String MSGPACK = "msgpack";
+
+    int MAX_FRAME_SIZE = 16 << 20;
+
+    String name();
+
+    byte[] encode(Frame f) throws IOException;
+
+    /** Reads one frame, or returns null on a clean EOF between frames. */
+    Frame decode(InputStream in) throws IOException;
+
+    /** The frame was skipped; the stream is still usable. */
+    final class BadFrameException extends IOException {
+        BadFrameException(String msg) { super(msg); }
+    }
+
+    /** Picks the first codec in the offer that the mod understands. */
+    static FrameCodec choose(List<String> offer) {
+        if (offer != null) {
+            for (String name : offer) {
+                FrameCodec c = byName(name);
+                if (c != null) return c;
+            }
+        }
+        return Ndjson.INSTANCE;
+    }
+
+    static FrameCodec byName(String name) {
+        if (name == null) return null;
+        return switch (name.toLowerCase(Locale.ROOT)) {
+            case NDJSON -> Ndjson.INSTANCE;
+            case MSGPACK -> Msgpack.INSTANCE;
+            default -> null;
+        };
+    }
+
+    // ---------------- NDJSON ----------------
+
+    final class Ndjson implements FrameCodec {
+        static final Ndjson INSTANCE = new Ndjson();
+        private final Gson gson = new Gson();
+
+        @Override public String name() { return NDJSON; }
+
+        @Override
+        public byte[] encode(Frame f) {
+            JsonObject o = new JsonObject();
+            o.addProperty("type", f.type);
+            if (f.id != null) o.addProperty("id", f.id);
+            if (f.body != null) o.addProperty("body", f.bodyString());
+            if (f.topic != null) o.addProperty("topic", f.topic);
+            if (f.msg != null) o.addProperty("msg", f.msg);
+            if (f.codecs != null && !f.codecs.isEmpty()) {
+                JsonArray a = new JsonArray();
+                f.codecs.forEach(a::add);
+                o.add("codecs", a);
+            }
+            if (f.codec != null) o.addProperty("codec", f.codec);
+            return (gson.toJson(o) + "\n").getBytes(StandardCharsets.UTF_8);
+        }
+
+        @Override
+        public Frame decode(InputStream in) throws IOException {
+            for (;;) {
+                String line = readLine(in);
+                if (line == null) return null;
+                line = line.trim();
+                if (line.isEmpty()) continue;
+
+                JsonObject m;
+                try {
+                    m = gson.fromJson(line, JsonObject.class);
+                } catch (JsonSyntaxException ex) {
+                    throw new BadFrameException("bad json syntax: " + line);
+                }
+                if (m == null || !m.has("type")) {
+                    throw new BadFrameException("bad json frame: " + line);
+                }
+                Frame f = Frame.of(m.get("type").getAsString());
+                f.id = str(m, "id");
+                f.topic = str(m, "topic");
+                f.msg = str(m, "msg");
+                f.codec = str(m, "codec");
+                String body = str(m, "body");
+                if (body != null) f.body(body);
+                if (m.has("codecs") && m.get("codecs").isJsonArray()) {
+                    f.codecs = new ArrayList<>();
+                    for (JsonElement e : m.getAsJsonArray("codecs")) {
+                        f.codecs.add(e.getAsString());
+                    }
+                }
+                return f;
+            }
+        }
+
+        private static String str(JsonObject m, String key) {
+            JsonElement e = m.get(key);
+            return e == null || e.isJsonNull() ? null : e.getAsString();
+        }
+
+        // Reads byte by byte so nothing past the newline is consumed; the
+        // stream may switch to msgpack right after a HELLO.
+        private static String readLine(InputStream in) throws IOException {
+            ByteArrayOutputStream buf = new ByteArrayOutputStream(256);
+            for (;;) {
+                int b = in.read();
+                if (b < 0) {
+                    if (buf.size() == 0) return null;
+                    throw new EOFException("truncated line");
+                }
+                if (b == '\n') return buf.toString(StandardCharsets.UTF_8);
+                if (buf.size() >= MAX_FRAME_SIZE) {
+                    throw new IOException("line longer than " + MAX_FRAME_SIZE + " bytes");
+                }
+                buf.write(b);
+            }
+        }
+    }
+
+    // ---------------- MessagePack ----------------
+    //
+    // [uint32 big-endian length][msgpack map]
+    // Keys are the NDJSON field names; "body" is bin, "codecs" an array of
+    // str, everything else str.
+
+    final class Msgpack implements FrameCodec {
+        static final Msgpack INSTANCE = new Msgpack();
+
+        @Override public String name() { return MSGPACK; }
+
+        @Override
+        public byte[] encode(Frame f) throws IOException {
+            ByteArrayOutputStream out = new ByteArrayOutputStream(32 + (f.body == null ? 0 : f.body.length));
+            out.write(new byte[4]); // length, filled in below
+            int n = 0;
+            for (Object v : new Object[]{f.type, f.id, f.topic, f.msg, f.body, f.codec}) {
+                if (v != null) n++;
+            }
+            boolean hasCodecs = f.codecs != null && !f.codecs.isEmpty();
+            if (hasCodecs) n++;
+            out.write(0x80 | n); // fixmap
+
+            if (f.type != null) { str(out, "type"); str(out, f.type); }
+            if (f.id != null) { str(out, "id"); str(out, f.id); }
+            if (f.topic != null) { str(out, "topic"); str(out, f.topic); }
+            if (f.msg != null) { str(out, "msg"); str(out, f.msg); }
+            if (f.body != null) { str(out, "body"); bin(out, f.body); }
+            if (hasCodecs) {
+                if (f.codecs.size() > 15) throw new BadFrameException("too many codecs");
+                str(out, "codecs");
+                out.write(0x90 | f.codecs.size()); // fixarray
+                for (String c : f.codecs) str(out, c);
+            }
+            if (f.codec != null) { str(out, "codec"); str(out, f.codec); }
+
+            byte[] b = out.toByteArray();
+            if (b.length - 4 > MAX_FRAME_SIZE) {
+                throw new BadFrameException("frame too large (" + (b.length - 4) + " bytes)");
+            }
+            ByteBuffer.wrap(b, 0, 4).putInt(b.length - 4);
+            return b;
+        }
+
+        @Override
+        public Frame decode(InputStream in) throws IOException {
+            int first = in.read();
+            if (first < 0) return null;
+            DataInputStream din = new DataInputStream(in);
+            int size = (first << 24) | (din.readUnsignedByte() << 16) | din.readUnsignedShort();
+            if (size < 0 || size > MAX_FRAME_SIZE) {
+                // The stream can't be resynchronised; drop the connection.
+                throw new IOException("frame of " + Integer.toUnsignedString(size) + " bytes");
+            }
+            byte[] buf = new byte[size];
+            din.readFully(buf);
+            try {
+                return decodeFrame(ByteBuffer.wrap(buf));
+            } catch (RuntimeException e) {
+                throw new BadFrameException("bad msgpack frame: " + e);
+            }
+        }
+
+        private static Frame decodeFrame(ByteBuffer r) throws BadFrameException {
+            int tag = r.get() & 0xff;
+            int n;
+            if ((tag & 0xf0) == 0x80) n = tag & 0x0f;
+            else if (tag == 0xde) n = r.getShort() & 0xffff;
+            else if (tag == 0xdf) n = r.getInt();
+            else throw new BadFrameException(String.format("expected map, got 0x%02x", tag));
+
+            Frame f = new Frame();
+            for (int i = 0; i < n; i++) {
+                String key = new String(bytes(r), StandardCharsets.UTF_8);
+                if (key.equals("codecs")) {
+                    int t = r.get() & 0xff;
+                    int len;
+                    if ((t & 0xf0) == 0x90) len = t & 0x0f;
+                    else if (t == 0xdc) len = r.getShort() & 0xffff;
+                    else throw new BadFrameException(String.format("expected array, got 0x%02x", t));
+                    f.codecs = new ArrayList<>(len);
+                    for (int j = 0; j < len; j++) {
+                        f.codecs.add(new String(bytes(r), StandardCharsets.UTF_8));
+                    }
+                    continue;
+                }
+                byte[] v = bytes(r);
+                String s = v == null ? null : new String(v, StandardCharsets.UTF_8);
+                switch (key) {
+                    case "type" -> f.type = s;
+                    case "id" -> f.id = s;
+                    case "topic" -> f.topic = s;
+                    case "msg" -> f.msg = s;
+                    case "body" -> f.body = v;
+                    case "codec" -> f.codec = s;
+                    default -> { } // ignore unknown keys
+                }
+            }
+            if (f.type == null) throw new BadFrameException("frame without type");
+            return f;
+        }
+
+        /** Reads a str, bin or nil value. */
+        private static byte[] bytes(ByteBuffer r) throws BadFrameException {
+            int tag = r.get() & 0xff;
+            int n;
+            if ((tag & 0xe0) == 0xa0) n = tag & 0x1f;
+            else if (tag == 0xd9 || tag == 0xc4) n = r.get() & 0xff;
+            else if (tag == 0xda || tag == 0xc5) n = r.getShort() & 0xffff;
+            else if (tag == 0xdb || tag == 0xc6) n = r.getInt();
+            else if (tag == 0xc0) return null;
+            else throw new BadFrameException(String.format("expected str/bin, got 0x%02x", tag));
+            if (n < 0 || n > r.remaining()) throw new BadFrameException("truncated value");
+            byte[] v = new byte[n];
+            r.get(v);
+            return v;
+        }
+
+        private static void str(ByteArrayOutputStream out, String s) {
+            byte[] b = s.getBytes(StandardCharsets.UTF_8);
+            int n = b.length;
+            if (n < 32) out.write(0xa0 | n);
+            else if (n <= 0xff) { out.write(0xd9); out.write(n); }
+            else if (n <= 0xffff) { out.write(0xda); u16(out, n); }
+            else { out.write(0xdb); u32(out, n); }
+            out.writeBytes(b);
+        }
+
+        private static void bin(ByteArrayOutputStream out, byte[] b) {
+            int n = b.length;
+            if (n <= 0xff) { out.write(0xc4); out.write(n); }
+            else if (n <= 0xffff) { out.write(0xc5); u16(out, n); }
+            else { out.write(0xc6); u32(out, n); }
+            out.writeBytes(b);
+        }
+
+        private static void u16(ByteArrayOutputStream out, int n) {
+            out.write(n >>> 8);
+            out.write(n);
+        }
+
+        private static void u32(ByteArrayOutputStream out, int n) {
+            u16(out, n >>> 16);
+            u16(out, n);
+        }
+    }
+}